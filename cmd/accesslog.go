@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// AccessLog writes one line per HTTP request, separate from the application
+// log, in Common Log Format, Combined Log Format or JSON.
+type AccessLog struct {
+	format string
+	out    io.Writer
+}
+
+// accessLogConfig mirrors the serve.access-log config keys.
+type accessLogConfig struct {
+	Path       string `mapstructure:"path"`
+	Format     string `mapstructure:"format"`
+	MaxSize    string `mapstructure:"max-size"`
+	MaxAge     string `mapstructure:"max-age"`
+	MaxBackups int    `mapstructure:"max-backups"`
+	Compress   bool   `mapstructure:"compress"`
+}
+
+// newAccessLog opens the access log described by cfg. An empty path disables
+// it and "-" writes to stdout.
+func newAccessLog(cfg accessLogConfig) (*AccessLog, error) {
+	if cfg.Path == "" {
+		return nil, nil
+	}
+	file, err := cfg.rotation()
+	if err != nil {
+		return nil, err
+	}
+	format := strings.ToLower(cfg.Format)
+	if format == "" {
+		format = "combined"
+	}
+
+	if cfg.Path == "-" {
+		return &AccessLog{format: format, out: os.Stdout}, nil
+	}
+	return &AccessLog{format: format, out: file}, nil
+}
+
+// rotation checks the settings and returns the unopened log file.
+func (cfg accessLogConfig) rotation() (*RotatingFile, error) {
+	switch strings.ToLower(cfg.Format) {
+	case "", "common", "combined", "json":
+	default:
+		return nil, fmt.Errorf("invalid access log format %q (use common, combined or json)", cfg.Format)
+	}
+
+	file := &RotatingFile{Path: cfg.Path, MaxBackups: cfg.MaxBackups, Compress: cfg.Compress}
+	if cfg.MaxSize != "" {
+		size, err := parseByteSize(cfg.MaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log max size: %w", err)
+		}
+		file.MaxSize = size
+	}
+	if cfg.MaxAge != "" {
+		age, err := parseAge(cfg.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access log max age: %w", err)
+		}
+		file.MaxAge = age
+	}
+	return file, nil
+}
+
+// parseAge parses a Go duration or a number of days such as "7d".
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		if _, err := fmt.Sscanf(days, "%d", &n); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q is not a duration", s)
+	}
+	return d, nil
+}
+
+// accessRecorder captures the status and the bytes actually written. It
+// implements io.ReaderFrom so http.ServeFile can still use sendfile.
+type accessRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *accessRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *accessRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *accessRecorder) ReadFrom(r io.Reader) (int64, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := rec.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(rec.ResponseWriter, r)
+	}
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *accessRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *accessRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessEntry is a JSON access log line.
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration"`
+	Range     string    `json:"range,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// Middleware logs every request once its response has been written.
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		entry := accessEntry{
+			Time:      start,
+			Client:    getClientIP(r),
+			Method:    r.Method,
+			Path:      redactedRequestURI(r.URL),
+			Protocol:  r.Proto,
+			Status:    rec.status,
+			Bytes:     rec.bytes,
+			Duration:  time.Since(start).Seconds(),
+			Range:     r.Header.Get("Range"),
+			Referer:   r.Referer(),
+			UserAgent: r.UserAgent(),
+			RequestID: w.Header().Get("X-Request-ID"),
+		}
+		a.out.Write(a.line(entry))
+	})
+}
+
+// redactedQueryParams are query parameters that carry credentials, such as
+// the bearer token baked into /install.sh?token=.
+var redactedQueryParams = map[string]bool{"token": true}
+
+// redactedRequestURI is the request URI with credential values in the query
+// replaced, keeping everything else as the client sent it.
+func redactedRequestURI(u *url.URL) string {
+	uri := u.RequestURI()
+	if u.RawQuery == "" {
+		return uri
+	}
+	path, _, _ := strings.Cut(uri, "?")
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		key, _, hasValue := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil && hasValue && redactedQueryParams[strings.ToLower(name)] {
+			pairs[i] = key + "=REDACTED"
+		}
+	}
+	return path + "?" + strings.Join(pairs, "&")
+}
+
+func (a *AccessLog) line(e accessEntry) []byte {
+	if a.format == "json" {
+		line, _ := json.Marshal(e)
+		return append(line, '\n')
+	}
+
+	// Common Log Format: host ident authuser [date] "request" status bytes
+	bytes := "-"
+	if e.Bytes > 0 {
+		bytes = fmt.Sprintf("%d", e.Bytes)
+	}
+	line := fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s`,
+		e.Client, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, clfEscape(e.Path), e.Protocol, e.Status, bytes)
+	if a.format == "combined" {
+		line += fmt.Sprintf(` "%s" "%s"`, clfEscape(orDash(e.Referer)), clfEscape(orDash(e.UserAgent)))
+	}
+	return []byte(line + "\n")
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfEscape escapes quotes, backslashes and control characters so a client
+// cannot forge log lines.
+func clfEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
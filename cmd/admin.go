@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin checks the request for the configured admin token. It writes
+// an error response and returns false when the request is not authorized.
+func (s *ModelServer) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.adminToken == "" {
+		http.Error(w, "Admin API disabled (no admin token configured)", http.StatusForbidden)
+		return false
+	}
+
+	token := bearerToken(r)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ollama-lancache"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		requestLog(r).Warn("Rejected admin request", "method", r.Method, "path", r.URL.Path)
+		return false
+	}
+
+	return true
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// writeJSON encodes v as the JSON response body with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes {"error": msg} with the given status code.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// handleAdminCatalog serves the curation API:
+//
+//	GET   /api/admin/catalog        - every entry, including pending and rejected
+//	GET   /api/admin/catalog/{ref}  - a single entry
+//	PATCH /api/admin/catalog/{ref}  - update status, description, deprecation
+func (s *ModelServer) handleAdminCatalog(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	// Make sure newly pulled models show up as pending before listing.
+	if _, err := s.syncCatalog(r.Context()); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	refStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/catalog"), "/")
+
+	if refStr == "" {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		entries, err := s.catalog.List()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+
+	ref, err := parseModelRef(refStr)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, ok := s.catalog.Get(ref.String())
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "model not in catalog")
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+
+	case http.MethodPatch, http.MethodPut:
+		var patch CatalogPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		entry, err := s.catalog.Update(ref.String(), patch.apply)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+		requestLog(r).Info("Catalog entry updated", logModel, entry.Ref, "status", entry.Status, "deprecated", entry.Deprecated)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
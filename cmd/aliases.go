@@ -0,0 +1,354 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AliasChange records one retargeting of an alias.
+type AliasChange struct {
+	Target    string    `json:"target"`
+	ChangedAt time.Time `json:"changed_at"`
+	Source    string    `json:"source"` // "config" or "api"
+}
+
+// Alias points a reference such as "team-default:latest" at an existing
+// model without duplicating its manifest on disk.
+type Alias struct {
+	Name      string        `json:"name"`
+	Target    string        `json:"target"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	History   []AliasChange `json:"history"`
+}
+
+// AliasStore persists aliases as JSON in the data directory.
+type AliasStore struct {
+	path string
+
+	mu      sync.Mutex
+	aliases map[string]*Alias
+	modTime time.Time
+}
+
+func newAliasStore(dataDir string) (*AliasStore, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	a := &AliasStore{
+		path:    filepath.Join(dataDir, "aliases.json"),
+		aliases: make(map[string]*Alias),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AliasStore) reloadLocked() error {
+	info, err := os.Stat(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat aliases: %w", err)
+	}
+	if info.ModTime().Equal(a.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to read aliases: %w", err)
+	}
+
+	var aliases []*Alias
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return fmt.Errorf("failed to parse aliases %s: %w", a.path, err)
+	}
+
+	a.aliases = make(map[string]*Alias, len(aliases))
+	for _, alias := range aliases {
+		a.aliases[alias.Name] = alias
+	}
+	a.modTime = info.ModTime()
+	return nil
+}
+
+func (a *AliasStore) saveLocked() error {
+	data, err := json.MarshalIndent(a.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(a.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write aliases: %w", err)
+	}
+	if info, err := os.Stat(a.path); err == nil {
+		a.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func (a *AliasStore) listLocked() []Alias {
+	aliases := make([]Alias, 0, len(a.aliases))
+	for _, alias := range a.aliases {
+		aliases = append(aliases, *alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+	return aliases
+}
+
+// List returns every alias sorted by name.
+func (a *AliasStore) List() ([]Alias, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return a.listLocked(), nil
+}
+
+// Get returns the alias with the given name.
+func (a *AliasStore) Get(name string) (Alias, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.reloadLocked(); err != nil {
+		return Alias{}, false
+	}
+	alias, ok := a.aliases[name]
+	if !ok {
+		return Alias{}, false
+	}
+	return *alias, true
+}
+
+// Set points name at target, recording the change in the alias history.
+// Setting an alias to its current target is a no-op.
+func (a *AliasStore) Set(name, target, source string) (Alias, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.reloadLocked(); err != nil {
+		return Alias{}, err
+	}
+	if name == target {
+		return Alias{}, fmt.Errorf("alias %s cannot point at itself", name)
+	}
+	if _, ok := a.aliases[target]; ok {
+		return Alias{}, fmt.Errorf("alias target %s is itself an alias", target)
+	}
+	for _, other := range a.aliases {
+		if other.Target == name {
+			return Alias{}, fmt.Errorf("%s is the target of alias %s", name, other.Name)
+		}
+	}
+
+	alias, ok := a.aliases[name]
+	if !ok {
+		alias = &Alias{Name: name}
+		a.aliases[name] = alias
+	} else if alias.Target == target {
+		return *alias, nil
+	}
+
+	now := time.Now()
+	alias.Target = target
+	alias.UpdatedAt = now
+	alias.History = append(alias.History, AliasChange{Target: target, ChangedAt: now, Source: source})
+
+	if err := a.saveLocked(); err != nil {
+		return Alias{}, err
+	}
+	return *alias, nil
+}
+
+// Delete removes an alias. It reports whether the alias existed.
+func (a *AliasStore) Delete(name string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.reloadLocked(); err != nil {
+		return false, err
+	}
+	if _, ok := a.aliases[name]; !ok {
+		return false, nil
+	}
+	delete(a.aliases, name)
+	return true, a.saveLocked()
+}
+
+// aliasConfig is one entry of the serve.aliases config list.
+type aliasConfig struct {
+	Name   string `mapstructure:"name"`
+	Target string `mapstructure:"target"`
+}
+
+// applyConfigAliases makes the store match the serve.aliases config list,
+// recording changes with the "config" source. Aliases last set from the
+// config that are no longer listed are removed; those set through the API
+// are left alone.
+func (a *AliasStore) applyConfigAliases(modelsDir string, aliases []aliasConfig) error {
+	configured := make(map[string]bool, len(aliases))
+	for _, cfg := range aliases {
+		aliasRef, err := parseModelRef(cfg.Name)
+		if err != nil {
+			return fmt.Errorf("invalid alias %q: %w", cfg.Name, err)
+		}
+		targetRef, err := parseModelRef(cfg.Target)
+		if err != nil {
+			return fmt.Errorf("invalid target for alias %q: %w", cfg.Name, err)
+		}
+		if err := aliasConflict(modelsDir, aliasRef); err != nil {
+			return err
+		}
+		if _, err := a.Set(aliasRef.String(), targetRef.String(), "config"); err != nil {
+			return err
+		}
+		configured[aliasRef.String()] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.reloadLocked(); err != nil {
+		return err
+	}
+	removed := false
+	for name, alias := range a.aliases {
+		if configured[name] || len(alias.History) == 0 || alias.History[len(alias.History)-1].Source != "config" {
+			continue
+		}
+		delete(a.aliases, name)
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return a.saveLocked()
+}
+
+// aliasConflict reports an error if a model is stored under the alias name;
+// its manifest would shadow the alias.
+func aliasConflict(modelsDir string, aliasRef ModelRef) error {
+	if _, err := os.Stat(aliasRef.manifestPath(modelsDir)); err == nil {
+		return fmt.Errorf("a model named %s already exists", aliasRef.String())
+	}
+	return nil
+}
+
+// resolveRef follows an alias to the model it points at. It returns the
+// reference whose manifest should be served and the alias that was followed,
+// if any.
+func (s *ModelServer) resolveRef(ref ModelRef) (ModelRef, *Alias) {
+	alias, ok := s.aliases.Get(ref.String())
+	if !ok {
+		return ref, nil
+	}
+	target, err := parseModelRef(alias.Target)
+	if err != nil {
+		return ref, nil
+	}
+	return target, &alias
+}
+
+// handleAdminAliases serves the alias API:
+//
+//	GET    /api/admin/aliases         - every alias with its history
+//	GET    /api/admin/aliases/{alias} - a single alias
+//	PUT    /api/admin/aliases/{alias} - create or retarget: {"target": "model:tag"}
+//	DELETE /api/admin/aliases/{alias} - remove an alias
+func (s *ModelServer) handleAdminAliases(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+
+	nameStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/aliases"), "/")
+
+	if nameStr == "" {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		aliases, err := s.aliases.List()
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, aliases)
+		return
+	}
+
+	aliasRef, err := parseModelRef(nameStr)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := aliasRef.String()
+
+	switch r.Method {
+	case http.MethodGet:
+		alias, ok := s.aliases.Get(name)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "alias not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, alias)
+
+	case http.MethodPut, http.MethodPost:
+		var body struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		targetRef, err := parseModelRef(body.Target)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid target: "+err.Error())
+			return
+		}
+		if _, err := os.Stat(targetRef.manifestPath(s.modelsDir)); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "target model not found: "+targetRef.String())
+			return
+		}
+		if err := aliasConflict(s.modelsDir, aliasRef); err != nil {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		alias, err := s.aliases.Set(name, targetRef.String(), "api")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, alias)
+		requestLog(r).Info("Alias set", "alias", alias.Name, logModel, alias.Target)
+
+	case http.MethodDelete:
+		existed, err := s.aliases.Delete(name)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !existed {
+			writeJSONError(w, http.StatusNotFound, "alias not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		requestLog(r).Info("Alias removed", "alias", name)
+
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BlobUsage describes one blob and the models that reference it.
+type BlobUsage struct {
+	Digest       string   `json:"digest"`
+	Size         int64    `json:"size"`
+	MediaType    string   `json:"media_type,omitempty"`
+	OnDisk       bool     `json:"on_disk"`
+	ReferencedBy []string `json:"referenced_by"`
+}
+
+// BlobIndex maps every blob in a models directory to the manifests that use
+// it, so sizes can be reported without double-counting shared layers.
+type BlobIndex struct {
+	blobs  map[string]*BlobUsage // digest -> usage
+	models map[string][]string   // model ref -> digests
+	disk   map[string]int64      // digest -> size of blob files present on disk
+}
+
+// buildBlobIndex reads every manifest found on disk (published or not, since
+// they all occupy space) and lists the blobs directory.
+func buildBlobIndex(ctx context.Context, modelsDir string, manifests []localManifest) *BlobIndex {
+	idx := &BlobIndex{
+		blobs:  make(map[string]*BlobUsage),
+		models: make(map[string][]string),
+		disk:   make(map[string]int64),
+	}
+
+	if entries, err := os.ReadDir(filepath.Join(modelsDir, "blobs")); err == nil {
+		for _, entry := range entries {
+			digest := strings.Replace(entry.Name(), "-", ":", 1)
+			if entry.IsDir() || !validDigest(digest) {
+				continue // skips "-partial" files and anything else
+			}
+			if info, err := entry.Info(); err == nil {
+				idx.disk[digest] = info.Size()
+			}
+		}
+	}
+
+	for _, m := range manifests {
+		manifest, err := readManifest(ctx, m.Path)
+		if err != nil {
+			slog.Warn("Skipping unreadable manifest", logModel, m.Ref.String(), "error", err)
+			continue
+		}
+
+		// Model sizes count layers only, like ModelInfo.Size; the config blob
+		// is indexed for lookups but left out of the per-model totals.
+		ref := m.Ref.String()
+		seen := make(map[string]bool)
+		for _, layer := range manifest.Blobs() {
+			if seen[layer.Digest] {
+				continue
+			}
+			seen[layer.Digest] = true
+			isConfig := layer.Digest == manifest.Config.Digest
+
+			usage, ok := idx.blobs[layer.Digest]
+			if !ok {
+				_, onDisk := idx.disk[layer.Digest]
+				usage = &BlobUsage{
+					Digest:    layer.Digest,
+					Size:      layer.Size,
+					MediaType: layer.MediaType,
+					OnDisk:    onDisk,
+				}
+				idx.blobs[layer.Digest] = usage
+			}
+			usage.ReferencedBy = append(usage.ReferencedBy, ref)
+			if !isConfig {
+				idx.models[ref] = append(idx.models[ref], layer.Digest)
+			}
+		}
+	}
+
+	for _, usage := range idx.blobs {
+		sort.Strings(usage.ReferencedBy)
+	}
+	return idx
+}
+
+// Sharing returns how many of a model's bytes are unique to it (and would be
+// freed by deleting it) and how many are shared with other models.
+func (idx *BlobIndex) Sharing(ref string) (unique, shared int64) {
+	for _, digest := range idx.models[ref] {
+		usage := idx.blobs[digest]
+		if len(usage.ReferencedBy) > 1 {
+			shared += usage.Size
+		} else {
+			unique += usage.Size
+		}
+	}
+	return unique, shared
+}
+
+// Blob returns the usage for a digest.
+func (idx *BlobIndex) Blob(digest string) (BlobUsage, bool) {
+	if usage, ok := idx.blobs[digest]; ok {
+		return *usage, true
+	}
+	if size, ok := idx.disk[digest]; ok {
+		return BlobUsage{Digest: digest, Size: size, OnDisk: true, ReferencedBy: []string{}}, true
+	}
+	return BlobUsage{}, false
+}
+
+// DedupedSize returns the size of the distinct blobs used by the given models.
+func (idx *BlobIndex) DedupedSize(refs []string) int64 {
+	counted := make(map[string]bool)
+	var total int64
+	for _, ref := range refs {
+		for _, digest := range idx.models[ref] {
+			if !counted[digest] {
+				counted[digest] = true
+				total += idx.blobs[digest].Size
+			}
+		}
+	}
+	return total
+}
+
+// DiskUsage returns the bytes used by blob files on disk, and how many of
+// those files (and bytes) no manifest references.
+func (idx *BlobIndex) DiskUsage() (total int64, orphans int, orphanBytes int64) {
+	for digest, size := range idx.disk {
+		total += size
+		if _, ok := idx.blobs[digest]; !ok {
+			orphans++
+			orphanBytes += size
+		}
+	}
+	return total, orphans, orphanBytes
+}
+
+// handleBlobInfo serves /api/blobs/{digest}: the blob's size and which
+// published models share it. References from unpublished models are only
+// counted, not named.
+func (s *ModelServer) handleBlobInfo(w http.ResponseWriter, r *http.Request) {
+	digest := strings.TrimPrefix(r.URL.Path, "/api/blobs/")
+	if !strings.Contains(digest, ":") {
+		digest = strings.Replace(digest, "-", ":", 1)
+	}
+	if !validDigest(digest) {
+		writeJSONError(w, http.StatusBadRequest, "invalid digest")
+		return
+	}
+
+	manifests, err := s.syncCatalog(r.Context())
+	if err != nil && manifests == nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to scan models")
+		return
+	}
+	idx := buildBlobIndex(r.Context(), s.modelsDir, manifests)
+
+	usage, ok := idx.Blob(digest)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "blob not found")
+		return
+	}
+
+	published := []string{}
+	hidden := 0
+	for _, ref := range usage.ReferencedBy {
+		if entry, ok := s.catalog.Get(ref); ok && entry.Status == CatalogApproved {
+			published = append(published, ref)
+		} else {
+			hidden++
+		}
+	}
+	usage.ReferencedBy = published
+
+	writeJSON(w, http.StatusOK, struct {
+		BlobUsage
+		Shared                bool `json:"shared"`
+		UnpublishedReferences int  `json:"unpublished_references"`
+		FreedIfModelDeleted   bool `json:"freed_if_model_deleted"`
+	}{
+		BlobUsage:             usage,
+		Shared:                len(published)+hidden > 1,
+		UnpublishedReferences: hidden,
+		FreedIfModelDeleted:   len(published)+hidden <= 1,
+	})
+
+	requestLog(r).Info("Blob info requested", logDigest, digest)
+}
@@ -0,0 +1,433 @@
+package cmd
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	bundleFormat  = "ollama-lancache-bundle"
+	bundleVersion = 1
+	bundleIndex   = "index.json"
+)
+
+// BundleIndex is the first entry of a bundle and describes everything in it.
+type BundleIndex struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	Created   time.Time     `json:"created"`
+	CreatedBy string        `json:"created_by"`
+	Models    []BundleModel `json:"models"`
+	Blobs     []BundleBlob  `json:"blobs"`
+	TotalSize int64         `json:"total_size_bytes"`
+}
+
+// BundleModel is a manifest stored in a bundle.
+type BundleModel struct {
+	Ref            string `json:"ref"`
+	Path           string `json:"path"`
+	ManifestDigest string `json:"manifest_digest"`
+	Size           int64  `json:"size"`
+}
+
+// BundleBlob is a de-duplicated blob stored in a bundle. The digest doubles
+// as its checksum.
+type BundleBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Path   string `json:"path"`
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export MODEL:TAG... -o bundle.tar",
+	Short: "Write models to an offline bundle for air-gapped sites",
+	Long: `Write one or more models to a self-describing tar archive containing an
+index with checksums, the manifests and de-duplicated blobs. The bundle can be
+installed with the import command on a machine without network access.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runExport,
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import bundle.tar",
+	Short: "Verify and install models from an offline bundle",
+	Long: `Verify and install the models in a bundle into an Ollama models directory or
+an ollama-lancache models directory.
+
+Blobs that are already present are skipped, and partially imported blobs are
+resumed, so an interrupted import can simply be run again. Manifests are only
+written once all of their blobs have been verified.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd, importCmd)
+
+	exportCmd.Flags().StringP("output", "o", "bundle.tar", "Bundle file to write (- for stdout)")
+	exportCmd.Flags().StringP("models-dir", "d", "", "Directory containing Ollama models (default: serve.models-dir or ~/.ollama/models)")
+
+	importCmd.Flags().StringP("models-dir", "d", "", "Directory to install into (default: serve.models-dir or ~/.ollama/models)")
+	importCmd.Flags().Bool("verify-existing", false, "Re-hash blobs that are already present instead of trusting their size")
+}
+
+// modelsDirFlag resolves --models-dir, then serve.models-dir, then the Ollama default.
+func modelsDirFlag(cmd *cobra.Command) (string, error) {
+	dir, _ := cmd.Flags().GetString("models-dir")
+	if dir == "" {
+		dir = viper.GetString("serve.models-dir")
+	}
+	if dir == "" {
+		return defaultModelsDir()
+	}
+	return dir, nil
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	modelsDir, err := modelsDirFlag(cmd)
+	if err != nil {
+		return err
+	}
+	output, _ := cmd.Flags().GetString("output")
+
+	index := BundleIndex{
+		Format:    bundleFormat,
+		Version:   bundleVersion,
+		Created:   time.Now().UTC(),
+		CreatedBy: "ollama-lancache " + version,
+	}
+	manifests := make(map[string][]byte)
+	seen := make(map[string]bool)
+
+	for _, arg := range args {
+		ref, err := parseModelRef(arg)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(ref.manifestPath(modelsDir))
+		if err != nil {
+			return fmt.Errorf("model %s not found in %s", ref, modelsDir)
+		}
+		manifest, err := parseManifest(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ref, err)
+		}
+
+		var size int64
+		for _, layer := range manifest.Blobs() {
+			size += layer.Size
+			if seen[layer.Digest] {
+				continue
+			}
+			info, err := os.Stat(blobPath(modelsDir, layer.Digest))
+			if err != nil {
+				return fmt.Errorf("%s: blob %s is missing", ref, layer.Digest)
+			}
+			if info.Size() != layer.Size {
+				return fmt.Errorf("%s: blob %s has size %d, manifest says %d", ref, layer.Digest, info.Size(), layer.Size)
+			}
+			seen[layer.Digest] = true
+			index.Blobs = append(index.Blobs, BundleBlob{
+				Digest: layer.Digest,
+				Size:   layer.Size,
+				Path:   "blobs/" + strings.ReplaceAll(layer.Digest, ":", "-"),
+			})
+			index.TotalSize += layer.Size
+		}
+
+		sum := sha256.Sum256(data)
+		manifestPath := path.Join("manifests", ref.Host, ref.Namespace, ref.Model, ref.Tag)
+		manifests[manifestPath] = data
+		index.Models = append(index.Models, BundleModel{
+			Ref:            ref.String(),
+			Path:           manifestPath,
+			ManifestDigest: "sha256:" + hex.EncodeToString(sum[:]),
+			Size:           size,
+		})
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	logOut := cmd.ErrOrStderr()
+
+	tw := tar.NewWriter(out)
+	indexData, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, bundleIndex, indexData); err != nil {
+		return err
+	}
+
+	// Blobs come before manifests so that an interrupted import never leaves
+	// a manifest pointing at a blob that was not written.
+	for i, blob := range index.Blobs {
+		fmt.Fprintf(logOut, "📦 [%d/%d] %s (%.2f MB)\n", i+1, len(index.Blobs), blob.Digest[:19]+"...", float64(blob.Size)/1024/1024)
+		if err := writeTarBlob(tw, blob.Path, blobPath(modelsDir, blob.Digest), blob.Size); err != nil {
+			return fmt.Errorf("failed to write blob %s: %w", blob.Digest, err)
+		}
+	}
+	for _, model := range index.Models {
+		if err := writeTarFile(tw, model.Path, manifests[model.Path]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(logOut, "✅ Exported %d models (%d blobs, %.2f GB) to %s\n", len(index.Models), len(index.Blobs), float64(index.TotalSize)/1024/1024/1024, output)
+	return nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeTarBlob(tw *tar.Writer, name, src string, size int64) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, size)
+	return err
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	modelsDir, err := modelsDirFlag(cmd)
+	if err != nil {
+		return err
+	}
+	verifyExisting, _ := cmd.Flags().GetBool("verify-existing")
+	out := cmd.OutOrStdout()
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleIndex {
+		return fmt.Errorf("%s is not an ollama-lancache bundle (missing %s)", args[0], bundleIndex)
+	}
+	var index BundleIndex
+	if err := json.NewDecoder(tr).Decode(&index); err != nil {
+		return fmt.Errorf("invalid bundle index: %w", err)
+	}
+	if index.Format != bundleFormat || index.Version > bundleVersion {
+		return fmt.Errorf("unsupported bundle format %s v%d", index.Format, index.Version)
+	}
+
+	blobs := make(map[string]BundleBlob, len(index.Blobs))
+	for _, blob := range index.Blobs {
+		if !validDigest(blob.Digest) {
+			return fmt.Errorf("invalid digest in bundle index: %q", blob.Digest)
+		}
+		blobs[blob.Path] = blob
+	}
+	models := make(map[string]BundleModel, len(index.Models))
+	for _, model := range index.Models {
+		models[model.Path] = model
+	}
+
+	fmt.Fprintf(out, "📦 Importing %d models (%d blobs, %.2f GB) into %s\n", len(index.Models), len(index.Blobs), float64(index.TotalSize)/1024/1024/1024, modelsDir)
+	if err := os.MkdirAll(filepath.Join(modelsDir, "blobs"), 0755); err != nil {
+		return err
+	}
+
+	verified := make(map[string]bool)
+	imported := 0
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("bundle is truncated or corrupt: %w", err)
+		}
+
+		if blob, ok := blobs[hdr.Name]; ok {
+			if hdr.Size != blob.Size {
+				return fmt.Errorf("blob %s: archive size %d does not match index size %d", blob.Digest, hdr.Size, blob.Size)
+			}
+			status, err := importBlob(tr, modelsDir, blob, verifyExisting)
+			if err != nil {
+				return err
+			}
+			verified[blob.Digest] = true
+			fmt.Fprintf(out, "  %s %s (%.2f MB)\n", status, blob.Digest[:19]+"...", float64(blob.Size)/1024/1024)
+			continue
+		}
+
+		if model, ok := models[hdr.Name]; ok {
+			if err := importManifest(tr, modelsDir, model, verified); err != nil {
+				return err
+			}
+			imported++
+			fmt.Fprintf(out, "✅ Installed %s\n", model.Ref)
+			continue
+		}
+
+		fmt.Fprintf(out, "⚠️  Skipping unknown bundle entry: %s\n", hdr.Name)
+	}
+
+	if imported != len(index.Models) {
+		return fmt.Errorf("bundle is incomplete: installed %d of %d models; re-run the import with a complete bundle to resume", imported, len(index.Models))
+	}
+	fmt.Fprintf(out, "🎉 Imported %d models into %s\n", imported, modelsDir)
+	return nil
+}
+
+// importBlob installs one blob from the archive. Present blobs are skipped,
+// and a "-partial" file left by an interrupted import is resumed: its bytes
+// are re-hashed, the same number of bytes is skipped in the archive and the
+// rest is appended.
+func importBlob(r io.Reader, modelsDir string, blob BundleBlob, verifyExisting bool) (string, error) {
+	dest := blobPath(modelsDir, blob.Digest)
+
+	if info, err := os.Stat(dest); err == nil && info.Size() == blob.Size {
+		if !verifyExisting {
+			return "⏭️  present", nil
+		}
+		if sum, err := fileDigest(dest); err == nil && sum == blob.Digest {
+			return "⏭️  present (verified)", nil
+		}
+		// Corrupt copy on disk: fall through and replace it.
+		os.Remove(dest)
+	}
+
+	partial := dest + "-partial"
+	h := sha256.New()
+
+	var offset int64
+	if info, err := os.Stat(partial); err == nil && info.Size() <= blob.Size {
+		n, err := hashFile(h, partial)
+		if err != nil {
+			return "", err
+		}
+		offset = n
+	} else {
+		h.Reset()
+		os.Remove(partial)
+	}
+
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+			return "", fmt.Errorf("blob %s: %w", blob.Digest, err)
+		}
+	}
+
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return "", err
+	}
+	_, copyErr := io.CopyN(io.MultiWriter(f, h), r, blob.Size-offset)
+	closeErr := f.Close()
+	if copyErr != nil {
+		return "", fmt.Errorf("blob %s interrupted (re-run import to resume): %w", blob.Digest, copyErr)
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != blob.Digest {
+		os.Remove(partial)
+		return "", fmt.Errorf("blob %s failed verification (got %s)", blob.Digest, actual)
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return "", err
+	}
+
+	if offset > 0 {
+		return fmt.Sprintf("🔄 resumed at %.2f MB", float64(offset)/1024/1024), nil
+	}
+	return "📥 imported", nil
+}
+
+func importManifest(r io.Reader, modelsDir string, model BundleModel, verified map[string]bool) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxManifestSize))
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != model.ManifestDigest {
+		return fmt.Errorf("manifest for %s failed verification", model.Ref)
+	}
+	manifest, err := parseManifest(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", model.Ref, err)
+	}
+	for _, layer := range manifest.Blobs() {
+		if !verified[layer.Digest] {
+			return fmt.Errorf("%s: blob %s was not found in the bundle", model.Ref, layer.Digest)
+		}
+	}
+
+	ref, err := parseModelRef(model.Ref)
+	if err != nil {
+		return err
+	}
+	dest := ref.manifestPath(modelsDir)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(dest, data, 0644)
+}
+
+// hashFile feeds a file into h and returns the number of bytes read.
+func hashFile(h hash.Hash, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(h, f)
+}
+
+// fileDigest returns the "sha256:<hex>" digest of a file.
+func fileDigest(path string) (string, error) {
+	h := sha256.New()
+	if _, err := hashFile(h, path); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
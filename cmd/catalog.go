@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var catalogCmd = &cobra.Command{
+	Use:   "catalog",
+	Short: "Manage the published model catalog",
+	Long: `Review and curate which models the server publishes.
+
+Models found in the models directory start out pending and are hidden from
+clients until they are approved. Changes are written to the server's data
+directory and picked up by a running server without a restart.`,
+}
+
+var catalogListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List catalog entries and their status",
+	Args:  cobra.NoArgs,
+	RunE:  runCatalogList,
+}
+
+var catalogApproveCmd = &cobra.Command{
+	Use:   "approve MODEL:TAG...",
+	Short: "Publish models so clients can see and pull them",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setCatalogStatus(cmd, args, CatalogApproved)
+	},
+}
+
+var catalogRejectCmd = &cobra.Command{
+	Use:   "reject MODEL:TAG...",
+	Short: "Hide models from clients",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setCatalogStatus(cmd, args, CatalogRejected)
+	},
+}
+
+var catalogSetCmd = &cobra.Command{
+	Use:   "set MODEL:TAG",
+	Short: "Set the description, recommended use or deprecation of a model",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runCatalogSet,
+}
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	catalogCmd.AddCommand(catalogListCmd, catalogApproveCmd, catalogRejectCmd, catalogSetCmd)
+
+	catalogCmd.PersistentFlags().StringP("models-dir", "d", "", "Directory containing Ollama models (default: serve.models-dir or ~/.ollama/models)")
+	catalogCmd.PersistentFlags().String("data-dir", "", "Server data directory (default: serve.data-dir or ~/.ollama-lancache)")
+
+	catalogListCmd.Flags().String("status", "", "Only show entries with this status (pending, approved, rejected)")
+
+	catalogSetCmd.Flags().String("description", "", "Short description shown to users")
+	catalogSetCmd.Flags().String("recommended-use", "", "What the model is recommended for")
+	catalogSetCmd.Flags().Bool("deprecated", false, "Mark the model as deprecated (use --deprecated=false to clear)")
+	catalogSetCmd.Flags().String("deprecation-message", "", "Message shown to clients pulling a deprecated model")
+}
+
+// openCatalog opens the catalog store and syncs it with the models on disk,
+// resolving directories from flags first and then the serve.* config keys.
+func openCatalog(cmd *cobra.Command) (*CatalogStore, []localManifest, error) {
+	modelsDir, _ := cmd.Flags().GetString("models-dir")
+	if modelsDir == "" {
+		modelsDir = viper.GetString("serve.models-dir")
+	}
+	if modelsDir == "" {
+		dir, err := defaultModelsDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		modelsDir = dir
+	}
+
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	if dataDir == "" {
+		dataDir = viper.GetString("serve.data-dir")
+	}
+	if dataDir == "" {
+		dir, err := defaultDataDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		dataDir = dir
+	}
+
+	catalog, err := newCatalogStore(dataDir, viper.GetBool("serve.auto-approve"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifests, err := scanManifests(modelsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to scan models directory: %w", err)
+	}
+
+	refs := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		refs = append(refs, m.Ref.String())
+	}
+	if _, _, err := catalog.Sync(refs); err != nil {
+		return nil, nil, err
+	}
+
+	return catalog, manifests, nil
+}
+
+func runCatalogList(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	status, _ := cmd.Flags().GetString("status")
+	if status != "" && !CatalogStatus(status).valid() {
+		return fmt.Errorf("invalid status %q (want pending, approved or rejected)", status)
+	}
+
+	catalog, manifests, err := openCatalog(cmd)
+	if err != nil {
+		return err
+	}
+
+	onDisk := make(map[string]bool, len(manifests))
+	for _, m := range manifests {
+		onDisk[m.Ref.String()] = true
+	}
+
+	entries, err := catalog.List()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tSTATUS\tDEPRECATED\tON DISK\tDESCRIPTION")
+	for _, entry := range entries {
+		if status != "" && entry.Status != CatalogStatus(status) {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%t\t%t\t%s\n", entry.Ref, entry.Status, entry.Deprecated, onDisk[entry.Ref], entry.Description)
+	}
+	return tw.Flush()
+}
+
+func setCatalogStatus(cmd *cobra.Command, args []string, status CatalogStatus) error {
+	silenceRunErrors(cmd)
+
+	catalog, _, err := openCatalog(cmd)
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range args {
+		ref, err := parseModelRef(arg)
+		if err != nil {
+			return err
+		}
+		if _, err := catalog.Update(ref.String(), CatalogPatch{Status: &status}.apply); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", ref, status)
+	}
+	return nil
+}
+
+func runCatalogSet(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	ref, err := parseModelRef(args[0])
+	if err != nil {
+		return err
+	}
+
+	var patch CatalogPatch
+	flags := cmd.Flags()
+	if flags.Changed("description") {
+		v, _ := flags.GetString("description")
+		patch.Description = &v
+	}
+	if flags.Changed("recommended-use") {
+		v, _ := flags.GetString("recommended-use")
+		patch.RecommendedUse = &v
+	}
+	if flags.Changed("deprecated") {
+		v, _ := flags.GetBool("deprecated")
+		patch.Deprecated = &v
+	}
+	if flags.Changed("deprecation-message") {
+		v, _ := flags.GetString("deprecation-message")
+		patch.DeprecationMessage = &v
+	}
+
+	catalog, _, err := openCatalog(cmd)
+	if err != nil {
+		return err
+	}
+
+	entry, err := catalog.Update(ref.String(), patch.apply)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s: %s (deprecated: %t)\n", entry.Ref, entry.Status, entry.Deprecated)
+	return nil
+}
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CatalogStatus is the publication state of a model in the curated catalog.
+type CatalogStatus string
+
+const (
+	CatalogPending  CatalogStatus = "pending"
+	CatalogApproved CatalogStatus = "approved"
+	CatalogRejected CatalogStatus = "rejected"
+)
+
+func (s CatalogStatus) valid() bool {
+	switch s {
+	case CatalogPending, CatalogApproved, CatalogRejected:
+		return true
+	}
+	return false
+}
+
+// CatalogEntry holds the curation data for a single model reference.
+type CatalogEntry struct {
+	Ref                string        `json:"ref"`
+	Status             CatalogStatus `json:"status"`
+	Description        string        `json:"description,omitempty"`
+	RecommendedUse     string        `json:"recommended_use,omitempty"`
+	Deprecated         bool          `json:"deprecated"`
+	DeprecationMessage string        `json:"deprecation_message,omitempty"`
+	FirstSeen          time.Time     `json:"first_seen"`
+	UpdatedAt          time.Time     `json:"updated_at"`
+	RemovedAt          *time.Time    `json:"removed_at,omitempty"` // set while the model is not on disk
+}
+
+// CatalogStore persists catalog entries as JSON in the data directory. The
+// file is re-read whenever it changes on disk so that edits made with the
+// `catalog` subcommand are picked up by a running server.
+type CatalogStore struct {
+	path        string
+	autoApprove bool
+
+	mu      sync.Mutex
+	entries map[string]*CatalogEntry
+	modTime time.Time
+}
+
+func newCatalogStore(dataDir string, autoApprove bool) (*CatalogStore, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	c := &CatalogStore{
+		path:        filepath.Join(dataDir, "catalog.json"),
+		autoApprove: autoApprove,
+		entries:     make(map[string]*CatalogEntry),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reloadLocked re-reads the catalog file if it changed since the last read.
+func (c *CatalogStore) reloadLocked() error {
+	info, err := os.Stat(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat catalog: %w", err)
+	}
+	if info.ModTime().Equal(c.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	var entries []*CatalogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse catalog %s: %w", c.path, err)
+	}
+
+	c.entries = make(map[string]*CatalogEntry, len(entries))
+	for _, entry := range entries {
+		c.entries[entry.Ref] = entry
+	}
+	c.modTime = info.ModTime()
+	return nil
+}
+
+// saveLocked writes the catalog atomically via a temp file and rename.
+func (c *CatalogStore) saveLocked() error {
+	data, err := json.MarshalIndent(c.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write catalog: %w", err)
+	}
+	if info, err := os.Stat(c.path); err == nil {
+		c.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func (c *CatalogStore) listLocked() []CatalogEntry {
+	entries := make([]CatalogEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Ref < entries[j].Ref })
+	return entries
+}
+
+// Sync records any references not yet in the catalog. New entries start out
+// pending unless the store was created with auto-approval. Entries whose
+// model is no longer on disk are marked removed, keeping their curation data
+// in case it comes back. It returns the references that were added (or came
+// back) and those that were removed.
+func (c *CatalogStore) Sync(refs []string) (added, removed []string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.reloadLocked(); err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	present := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		present[ref] = true
+		if entry, ok := c.entries[ref]; ok {
+			if entry.RemovedAt != nil {
+				entry.RemovedAt = nil
+				added = append(added, ref)
+			}
+			continue
+		}
+		status := CatalogPending
+		if c.autoApprove {
+			status = CatalogApproved
+		}
+		c.entries[ref] = &CatalogEntry{
+			Ref:       ref,
+			Status:    status,
+			FirstSeen: now,
+			UpdatedAt: now,
+		}
+		added = append(added, ref)
+	}
+	for ref, entry := range c.entries {
+		if !present[ref] && entry.RemovedAt == nil {
+			removedAt := now
+			entry.RemovedAt = &removedAt
+			removed = append(removed, ref)
+		}
+	}
+	sort.Strings(removed)
+
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil, nil
+	}
+	return added, removed, c.saveLocked()
+}
+
+// Get returns the entry for a reference.
+func (c *CatalogStore) Get(ref string) (CatalogEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.reloadLocked(); err != nil {
+		return CatalogEntry{}, false
+	}
+	entry, ok := c.entries[ref]
+	if !ok {
+		return CatalogEntry{}, false
+	}
+	return *entry, true
+}
+
+// List returns every entry sorted by reference.
+func (c *CatalogStore) List() ([]CatalogEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return c.listLocked(), nil
+}
+
+// Update applies fn to the entry for ref, creating a pending entry first if
+// the reference is unknown, and persists the result.
+func (c *CatalogStore) Update(ref string, fn func(*CatalogEntry) error) (CatalogEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.reloadLocked(); err != nil {
+		return CatalogEntry{}, err
+	}
+
+	now := time.Now()
+	entry, ok := c.entries[ref]
+	if !ok {
+		entry = &CatalogEntry{Ref: ref, Status: CatalogPending, FirstSeen: now}
+	}
+
+	updated := *entry
+	if err := fn(&updated); err != nil {
+		return CatalogEntry{}, err
+	}
+	if !updated.Status.valid() {
+		return CatalogEntry{}, fmt.Errorf("invalid status %q (want pending, approved or rejected)", updated.Status)
+	}
+	updated.UpdatedAt = now
+
+	c.entries[ref] = &updated
+	if err := c.saveLocked(); err != nil {
+		return CatalogEntry{}, err
+	}
+	return updated, nil
+}
+
+// CatalogPatch is a partial update to a catalog entry; nil fields are left
+// unchanged.
+type CatalogPatch struct {
+	Status             *CatalogStatus `json:"status,omitempty"`
+	Description        *string        `json:"description,omitempty"`
+	RecommendedUse     *string        `json:"recommended_use,omitempty"`
+	Deprecated         *bool          `json:"deprecated,omitempty"`
+	DeprecationMessage *string        `json:"deprecation_message,omitempty"`
+}
+
+func (p CatalogPatch) apply(entry *CatalogEntry) error {
+	if p.Status != nil {
+		entry.Status = *p.Status
+	}
+	if p.Description != nil {
+		entry.Description = *p.Description
+	}
+	if p.RecommendedUse != nil {
+		entry.RecommendedUse = *p.RecommendedUse
+	}
+	if p.Deprecated != nil {
+		entry.Deprecated = *p.Deprecated
+	}
+	if p.DeprecationMessage != nil {
+		entry.DeprecationMessage = *p.DeprecationMessage
+	}
+	return nil
+}
+
+// deprecationNotice returns the message shown to clients pulling a deprecated
+// model, falling back to a generic notice.
+func deprecationNotice(message string) string {
+	if message == "" {
+		return "This model is deprecated and may be removed from the catalog"
+	}
+	return message
+}
+
+// writeFileAtomic writes data to a temp file next to path and renames it into
+// place so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,353 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Change types reported by the catalog change feed.
+const (
+	ChangeAdd    = "add"
+	ChangeUpdate = "update"
+	ChangeRemove = "remove"
+)
+
+const (
+	changeFeedSize         = 10000 // changes kept; older cursors get a full snapshot
+	changeFeedPageSize     = 1000
+	changeFeedMaxWait      = 60 * time.Second
+	changeFeedScanInterval = 2 * time.Second
+)
+
+// CatalogChange is one entry of the change feed.
+type CatalogChange struct {
+	Seq            int64     `json:"seq"`
+	Type           string    `json:"type"` // add, update or remove
+	Model          string    `json:"model"`
+	Digest         string    `json:"digest,omitempty"` // manifest digest; empty for removals
+	PreviousDigest string    `json:"previous_digest,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+// publishedModel is what the feed remembers about a published model to tell
+// whether it changed. Pull counts and sizes derived from the manifest are
+// left out, so only new content or new curation data is an update.
+type publishedModel struct {
+	Digest      string `json:"digest"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+func newPublishedModel(m ModelInfo) publishedModel {
+	curation, _ := json.Marshal([]interface{}{
+		m.Description, m.RecommendedUse, m.Deprecated, m.DeprecationMessage, m.AliasOf,
+	})
+	sum := sha256.Sum256(curation)
+	return publishedModel{Digest: m.Digest, Fingerprint: hex.EncodeToString(sum[:8])}
+}
+
+// changeFeedState is what changes.json holds. The epoch changes whenever
+// the feed starts over, so cursors from another data directory are not
+// mistaken for ours.
+type changeFeedState struct {
+	Epoch   string                    `json:"epoch"`
+	Seq     int64                     `json:"seq"`
+	Models  map[string]publishedModel `json:"models"`
+	Changes []CatalogChange           `json:"changes"`
+}
+
+// ChangeFeed records how the published catalog changes over time, so
+// mirrors can sync incrementally instead of diffing /api/models. Changes
+// are found by comparing each catalog listing with the previous one.
+type ChangeFeed struct {
+	path string
+
+	mu     sync.Mutex
+	state  changeFeedState
+	notify chan struct{} // closed and replaced when changes are recorded
+
+	// Listings are stamped with a generation when their scan starts, so one
+	// that finishes after a newer listing was recorded is dropped instead
+	// of reverting the feed.
+	started  int64
+	recorded int64
+
+	scanMu   sync.Mutex
+	lastScan time.Time
+}
+
+func newChangeFeed(dataDir string) (*ChangeFeed, error) {
+	f := &ChangeFeed{
+		path:   filepath.Join(dataDir, "changes.json"),
+		notify: make(chan struct{}),
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read change feed: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &f.state); err != nil {
+			return nil, fmt.Errorf("failed to parse change feed %s: %w", f.path, err)
+		}
+	}
+	if f.state.Epoch == "" {
+		f.state = changeFeedState{Epoch: newRequestID()}
+	}
+	if f.state.Models == nil {
+		f.state.Models = make(map[string]publishedModel)
+	}
+	return f, nil
+}
+
+// Begin returns the generation to pass to Record for a catalog listing that
+// is about to be built.
+func (f *ChangeFeed) Begin() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.started++
+	return f.started
+}
+
+// Record compares the published catalog with the last one seen and appends
+// a change for every model that was added, updated or removed. Listings
+// older than the last one recorded are ignored.
+func (f *ChangeFeed) Record(gen int64, models []ModelInfo) error {
+	current := make(map[string]publishedModel, len(models))
+	for _, m := range models {
+		current[m.Name+":"+m.Tag] = newPublishedModel(m)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if gen <= f.recorded {
+		return nil
+	}
+	f.recorded = gen
+
+	refs := make([]string, 0, len(current)+len(f.state.Models))
+	for ref := range current {
+		refs = append(refs, ref)
+	}
+	for ref := range f.state.Models {
+		if _, ok := current[ref]; !ok {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Strings(refs)
+
+	now := time.Now().UTC()
+	changed := false
+	for _, ref := range refs {
+		before, existed := f.state.Models[ref]
+		after, exists := current[ref]
+		change := CatalogChange{Model: ref, Digest: after.Digest, Time: now}
+		switch {
+		case !existed:
+			change.Type = ChangeAdd
+		case !exists:
+			change.Type = ChangeRemove
+			change.PreviousDigest = before.Digest
+		case before != after:
+			change.Type = ChangeUpdate
+			if before.Digest != after.Digest {
+				change.PreviousDigest = before.Digest
+			}
+		default:
+			continue
+		}
+		f.state.Seq++
+		change.Seq = f.state.Seq
+		f.state.Changes = append(f.state.Changes, change)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	if len(f.state.Changes) > changeFeedSize {
+		f.state.Changes = append([]CatalogChange(nil), f.state.Changes[len(f.state.Changes)-changeFeedSize:]...)
+	}
+	f.state.Models = current
+	close(f.notify)
+	f.notify = make(chan struct{})
+
+	data, err := json.MarshalIndent(f.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, data, 0644)
+}
+
+// ChangePage is the response of /api/catalog/changes.
+type ChangePage struct {
+	Cursor  string          `json:"cursor"` // pass as ?since= to get the next changes
+	Reset   bool            `json:"reset"`  // changes is the whole catalog; drop anything not in it
+	More    bool            `json:"more"`   // more changes are ready; ask again right away
+	Changes []CatalogChange `json:"changes"`
+}
+
+// Since returns the changes after cursor, or a snapshot of the whole
+// catalog as add changes when the cursor is empty, from another epoch or
+// older than the changes kept. The channel is closed when new changes are
+// recorded.
+func (f *ChangeFeed) Since(cursor string, limit int) (ChangePage, <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	page := ChangePage{Cursor: f.cursor(f.state.Seq), Changes: []CatalogChange{}}
+
+	seq, ok, err := f.parseCursor(cursor)
+	if err != nil {
+		return ChangePage{}, nil, err
+	}
+	oldest := f.state.Seq - int64(len(f.state.Changes))
+	if !ok || seq < oldest || seq > f.state.Seq {
+		page.Reset = true
+		refs := make([]string, 0, len(f.state.Models))
+		for ref := range f.state.Models {
+			refs = append(refs, ref)
+		}
+		sort.Strings(refs)
+		for _, ref := range refs {
+			page.Changes = append(page.Changes, CatalogChange{
+				Seq:    f.state.Seq,
+				Type:   ChangeAdd,
+				Model:  ref,
+				Digest: f.state.Models[ref].Digest,
+				Time:   time.Now().UTC(),
+			})
+		}
+		return page, f.notify, nil
+	}
+
+	// Changes are numbered consecutively, so the first one after seq is at
+	// a known index
+	pending := f.state.Changes[seq-oldest:]
+	if len(pending) > limit {
+		pending = pending[:limit]
+		page.More = true
+	}
+	page.Changes = append(page.Changes, pending...)
+	if len(pending) > 0 {
+		page.Cursor = f.cursor(pending[len(pending)-1].Seq)
+	}
+	return page, f.notify, nil
+}
+
+// Cursors look like "<epoch>-<seq>".
+func (f *ChangeFeed) cursor(seq int64) string {
+	return f.state.Epoch + "-" + strconv.FormatInt(seq, 10)
+}
+
+// parseCursor returns the sequence number of a cursor and whether it
+// belongs to this feed.
+func (f *ChangeFeed) parseCursor(cursor string) (int64, bool, error) {
+	if cursor == "" {
+		return 0, false, nil
+	}
+	epoch, seqStr, ok := strings.Cut(cursor, "-")
+	seq, err := strconv.ParseInt(seqStr, 10, 64)
+	if !ok || epoch == "" || err != nil || seq < 0 {
+		return 0, false, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return seq, epoch == f.state.Epoch, nil
+}
+
+// claimScan reports whether the caller should rescan the catalog, so that
+// many waiting clients cause one scan per interval between them.
+func (f *ChangeFeed) claimScan(interval time.Duration) bool {
+	f.scanMu.Lock()
+	defer f.scanMu.Unlock()
+
+	if time.Since(f.lastScan) < interval {
+		return false
+	}
+	f.lastScan = time.Now()
+	return true
+}
+
+// refreshChanges lists the catalog, which records any changes made on disk
+// or through the admin API since the last listing. Waiting clients tick
+// every changeFeedScanInterval; half of it is enough slack for their
+// tickers to never skip a scan.
+func (s *ModelServer) refreshChanges(r *http.Request) {
+	if s.changes.claimScan(changeFeedScanInterval / 2) {
+		s.getAvailableModels(r.Context())
+	}
+}
+
+// handleCatalogChanges serves the change feed:
+//
+//	GET /api/catalog/changes                 - a snapshot of the catalog and a cursor
+//	GET /api/catalog/changes?since=<cursor>  - changes after the cursor
+//
+// With ?wait=30s the request is held until a change happens or the wait
+// runs out. ?limit= caps the changes returned (at most 1000).
+func (s *ModelServer) handleCatalogChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	limit := changeFeedPageSize
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit "+strconv.Quote(v))
+			return
+		}
+		limit = min(n, changeFeedPageSize)
+	}
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			secs, convErr := strconv.Atoi(v)
+			if convErr != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid wait "+strconv.Quote(v)+" (use seconds or a duration such as 30s)")
+				return
+			}
+			d = time.Duration(secs) * time.Second
+		}
+		wait = min(max(d, 0), changeFeedMaxWait)
+	}
+
+	s.refreshChanges(r)
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(changeFeedScanInterval)
+	defer ticker.Stop()
+
+	for {
+		page, notify, err := s.changes.Since(query.Get("since"), limit)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(page.Changes) > 0 || page.Reset || wait == 0 {
+			writeJSON(w, http.StatusOK, page)
+			return
+		}
+
+		select {
+		case <-notify:
+		case <-ticker.C:
+			s.refreshChanges(r)
+		case <-deadline.C:
+			wait = 0 // answer with the empty page
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sumsFile is the name SHA256SUMS is served under in every directory of
+// the downloads area, in the format of sha256sum, so a downloaded folder can
+// be checked with `sha256sum -c SHA256SUMS`.
+const sumsFile = "SHA256SUMS"
+
+// checksumRetryAfter is the Retry-After, in seconds, sent with SHA256SUMS
+// while files in the folder are still being hashed.
+const checksumRetryAfter = "30"
+
+// reservedName reports whether a name belongs to the checksum files the
+// server generates in every directory, SHA256SUMS and SHA256SUMS.sig. Files
+// with these names cannot be uploaded and are hidden if put there by hand,
+// so whatever is served under them comes from the server. <file>.sha256 is
+// only generated while no real file has that name; see shadowsChecksum.
+func reservedName(name string) bool {
+	lower := strings.ToLower(name)
+	return lower == strings.ToLower(sumsFile) || lower == strings.ToLower(sumsFile+".sig")
+}
+
+// shadowsChecksum reports whether creating name in dir would replace the
+// generated <file>.sha256 of a file that exists there. A .sha256 file that is
+// already on disk, such as one shipped by a vendor, is served as it is.
+func shadowsChecksum(dir, name string) bool {
+	if !strings.HasSuffix(name, ".sha256") {
+		return false
+	}
+	if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(name, ".sha256")))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// cachedSum is the checksum of a file as of its size and modification time.
+type cachedSum struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// loadChecksums reads the checksum cache, checksums.json in the data
+// directory, so large files are not hashed again after a restart.
+func (d *Downloads) loadChecksums() error {
+	data, err := os.ReadFile(d.sumsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checksum cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &d.sums); err != nil {
+		return fmt.Errorf("failed to parse checksum cache %s: %w", d.sumsPath, err)
+	}
+	return nil
+}
+
+func (d *Downloads) saveChecksumsLocked() {
+	if !d.sumsDirty {
+		return
+	}
+	data, err := json.MarshalIndent(d.sums, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(d.sumsPath, data, 0644)
+	}
+	if err != nil {
+		slog.Warn("Could not save checksum cache", "path", d.sumsPath, "error", err)
+		return
+	}
+	d.sumsDirty = false
+}
+
+// rememberSum records the checksum of a file that was just written.
+func (d *Downloads) rememberSum(real string, sum string) {
+	info, err := os.Stat(real)
+	if err != nil {
+		return
+	}
+	key, err := filepath.Rel(d.root, real)
+	if err != nil {
+		return
+	}
+	d.sumMu.Lock()
+	defer d.sumMu.Unlock()
+	d.sums[filepath.ToSlash(key)] = cachedSum{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+	d.sumsDirty = true
+	d.saveChecksumsLocked()
+}
+
+// checksum returns the SHA-256 of a resolved file, hashing it only when its
+// size or modification time changed since it was last hashed.
+func (d *Downloads) checksum(real string, info fs.FileInfo) (string, error) {
+	rel, err := filepath.Rel(d.root, real)
+	if err != nil {
+		return "", err
+	}
+	key := filepath.ToSlash(rel)
+
+	d.sumMu.Lock()
+	cached, ok := d.sums[key]
+	d.sumMu.Unlock()
+	if ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) {
+		return cached.SHA256, nil
+	}
+
+	f, err := os.Open(real)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	d.sumMu.Lock()
+	defer d.sumMu.Unlock()
+	d.sums[key] = cachedSum{Size: info.Size(), ModTime: info.ModTime().UTC(), SHA256: sum}
+	d.sumsDirty = true
+	return sum, nil
+}
+
+// cachedChecksum returns the checksum of a resolved file if it is known and
+// current, without hashing it.
+func (d *Downloads) cachedChecksum(real string, info fs.FileInfo) string {
+	rel, err := filepath.Rel(d.root, real)
+	if err != nil {
+		return ""
+	}
+	d.sumMu.Lock()
+	defer d.sumMu.Unlock()
+	if cached, ok := d.sums[filepath.ToSlash(rel)]; ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) {
+		return cached.SHA256
+	}
+	return ""
+}
+
+// walkFiles calls fn for every visible regular file below the directory rel,
+// in name order, with its path relative to rel. Symlinked directories are
+// followed once, so links back up the tree cannot loop.
+func (d *Downloads) walkFiles(rel string, fn func(sub, real string, info fs.FileInfo) error) error {
+	seen := make(map[string]bool)
+	var walk func(rel, sub string) error
+	walk = func(rel, sub string) error {
+		dir, _, err := d.resolve(rel)
+		if err != nil {
+			return err
+		}
+		if seen[dir] {
+			return nil
+		}
+		seen[dir] = true
+
+		items, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if hiddenName(item.Name()) {
+				continue
+			}
+			childRel := path.Join(rel, item.Name())
+			real, info, err := d.resolve(childRel)
+			if err != nil {
+				continue
+			}
+			switch {
+			case info.IsDir():
+				if err := walk(childRel, sub+item.Name()+"/"); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				if err := fn(sub+item.Name(), real, info); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(rel, "")
+}
+
+// sumsFor renders SHA256SUMS for the directory rel and everything below it
+// from the checksum cache. It reports false without hashing anything if a
+// file's checksum is not cached yet.
+func (d *Downloads) sumsFor(rel string) ([]byte, bool, error) {
+	var buf bytes.Buffer
+	complete := true
+	err := d.walkFiles(rel, func(sub, real string, info fs.FileInfo) error {
+		if strings.ContainsAny(sub, "\n\\") {
+			return nil
+		}
+		sum := d.cachedChecksum(real, info)
+		if sum == "" {
+			complete = false
+			return fs.SkipAll
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", sum, sub)
+		return nil
+	})
+	if err == fs.SkipAll {
+		err = nil
+	}
+	return buf.Bytes(), complete, err
+}
+
+// refreshChecksums hashes new and changed files and forgets files that are
+// gone, so SHA256SUMS is quick to serve. It returns at once if a refresh is
+// already running.
+func (d *Downloads) refreshChecksums() {
+	if !d.refreshing.CompareAndSwap(false, true) {
+		return
+	}
+	defer d.refreshing.Store(false)
+
+	present := make(map[string]bool)
+	err := d.walkFiles("", func(sub, real string, info fs.FileInfo) error {
+		if _, err := d.checksum(real, info); err != nil {
+			slog.Warn("Could not checksum download", "path", sub, "error", err)
+		}
+		if rel, err := filepath.Rel(d.root, real); err == nil {
+			present[filepath.ToSlash(rel)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("Could not checksum downloads", "error", err)
+		return
+	}
+
+	d.sumMu.Lock()
+	defer d.sumMu.Unlock()
+	for key := range d.sums {
+		if !present[key] {
+			delete(d.sums, key)
+			d.sumsDirty = true
+		}
+	}
+	d.saveChecksumsLocked()
+}
+
+// serveGeneratedChecksum answers requests for SHA256SUMS, SHA256SUMS.sig and
+// <file>.sha256, which never resolve to real files. It reports whether the
+// request was one of those.
+func (s *ModelServer) serveGeneratedChecksum(w http.ResponseWriter, r *http.Request, rel string) bool {
+	dir, base := path.Split(rel)
+	switch {
+	case base == sumsFile || base == sumsFile+".sig":
+		if _, info, err := s.downloads.resolve(dir); err != nil || !info.IsDir() {
+			return false
+		}
+		if base == sumsFile+".sig" && s.signer == nil {
+			http.Error(w, errSigningDisabled.Error(), http.StatusNotFound)
+			return true
+		}
+		sums, complete, err := s.downloads.sumsFor(dir)
+		if err != nil {
+			requestLog(r).Error("Could not compute checksums", "path", dir, "error", err)
+			http.Error(w, "Could not compute checksums", http.StatusInternalServerError)
+			return true
+		}
+		if !complete {
+			go s.downloads.refreshChecksums()
+			w.Header().Set("Retry-After", checksumRetryAfter)
+			http.Error(w, "Checksums are still being computed", http.StatusServiceUnavailable)
+			return true
+		}
+		if base == sumsFile+".sig" {
+			s.signer.writeSignature(w, signContextSums, sums)
+			return true
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(sums)
+		return true
+
+	case strings.HasSuffix(base, ".sha256"):
+		target := strings.TrimSuffix(rel, ".sha256")
+		real, info, err := s.downloads.resolve(target)
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+		sum, err := s.downloads.checksum(real, info)
+		if err != nil {
+			requestLog(r).Error("Could not compute checksum", "path", target, "error", err)
+			http.Error(w, "Could not compute checksum", http.StatusInternalServerError)
+			return true
+		}
+		s.downloads.sumMu.Lock()
+		s.downloads.saveChecksumsLocked()
+		s.downloads.sumMu.Unlock()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprintf(w, "%s  %s\n", sum, path.Base(target))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies is the set of networks whose forwarding headers and PROXY
+// protocol headers are believed. An empty set trusts nobody.
+type TrustedProxies []netip.Prefix
+
+// parseTrustedProxies accepts CIDRs and bare IP addresses.
+func parseTrustedProxies(values []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if strings.Contains(field, "/") {
+				prefix, err := netip.ParsePrefix(field)
+				if err != nil {
+					return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+				}
+				proxies = append(proxies, prefix.Masked())
+				continue
+			}
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	return proxies, nil
+}
+
+// Contains reports whether addr belongs to a trusted network.
+func (t TrustedProxies) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range t {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwarding headers a trusted proxy may report the client address in. Only
+// the configured one is read: a proxy that sets X-Forwarded-For passes a
+// client's own Forwarded header through untouched.
+const (
+	headerXForwardedFor = "x-forwarded-for"
+	headerForwarded     = "forwarded"
+	headerXRealIP       = "x-real-ip"
+)
+
+// parseTrustedHeader checks a serve.trusted-header value.
+func parseTrustedHeader(value string) (string, error) {
+	header := strings.ToLower(strings.TrimSpace(value))
+	switch header {
+	case "":
+		return headerXForwardedFor, nil
+	case headerXForwardedFor, headerForwarded, headerXRealIP:
+		return header, nil
+	}
+	return "", fmt.Errorf("invalid trusted header %q (use x-forwarded-for, forwarded or x-real-ip)", value)
+}
+
+// ClientAddr works out the real client address of a request. Starting from
+// the connected peer, the entries of the trusted header are walked right to
+// left for as long as the hop that added an entry is trusted; the first
+// untrusted hop is the client.
+func (t TrustedProxies) ClientAddr(r *http.Request, header string) netip.Addr {
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	if !ok || !t.Contains(peer) {
+		return peer
+	}
+
+	var hops []string
+	switch header {
+	case headerForwarded:
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	case headerXForwardedFor:
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	case headerXRealIP:
+		if value := strings.TrimSpace(r.Header.Get("X-Real-IP")); value != "" {
+			hops = []string{value}
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHostAddr(hops[i])
+		if !ok {
+			// "unknown", obfuscated identifiers and garbage end the chain at
+			// the last proxy we could identify
+			break
+		}
+		client = addr
+		if !t.Contains(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// Scheme returns "https" when the request came in over TLS, either directly
+// or at a trusted proxy that says so: in Forwarded when that is the trusted
+// header, otherwise in X-Forwarded-Proto.
+func (t TrustedProxies) Scheme(r *http.Request, header string) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	peer, ok := parseHostAddr(r.RemoteAddr)
+	if !ok || !t.Contains(peer) {
+		return "http"
+	}
+
+	// The first entry was added by the proxy the client connected to
+	var proto string
+	if header == headerForwarded {
+		if values := r.Header.Values("Forwarded"); len(values) > 0 {
+			first, _, _ := strings.Cut(values[0], ",")
+			for _, pair := range strings.Split(first, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "proto") {
+					proto = strings.Trim(val, `"`)
+				}
+			}
+		}
+	} else {
+		proto, _, _ = strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	}
+	if strings.EqualFold(strings.TrimSpace(proto), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+// forwardedFor returns the for= parameters of Forwarded header values in
+// order, e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHostAddr parses an IP address with an optional port, as found in
+// RemoteAddr and forwarding headers ("192.0.2.1", "192.0.2.1:80",
+// "2001:db8::1", "[2001:db8::1]:80").
+func parseHostAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+type clientIPKey struct{}
+
+// withClientIP resolves the client address once per request so sessions,
+// logs and access checks all agree on it.
+func withClientIP(next http.Handler, proxies TrustedProxies, header string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if addr := proxies.ClientAddr(r, header); addr.IsValid() {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, addr.String()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getClientIP returns the client address resolved by withClientIP, or the
+// connected peer when the request did not pass through it.
+func getClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	if addr, ok := parseHostAddr(r.RemoteAddr); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Read-only endpoints in the shape of Ollama's and OpenAI's model APIs, so
+// tools such as Open WebUI and LiteLLM can browse the catalog directly.
+
+// ollamaDetails is the "details" object of Ollama's /api/tags and /api/show.
+type ollamaDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+type ollamaModel struct {
+	Name       string        `json:"name"`
+	Model      string        `json:"model"`
+	ModifiedAt time.Time     `json:"modified_at"`
+	Size       int64         `json:"size"`
+	Digest     string        `json:"digest"`
+	Details    ollamaDetails `json:"details"`
+}
+
+type ollamaShowResponse struct {
+	License    string                 `json:"license,omitempty"`
+	Modelfile  string                 `json:"modelfile"`
+	Parameters string                 `json:"parameters,omitempty"`
+	Template   string                 `json:"template,omitempty"`
+	System     string                 `json:"system,omitempty"`
+	Details    ollamaDetails          `json:"details"`
+	Messages   []ModelMessage         `json:"messages,omitempty"`
+	ModelInfo  map[string]interface{} `json:"model_info,omitempty"`
+	ModifiedAt time.Time              `json:"modified_at"`
+}
+
+type openAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+func toOllamaDetails(d ModelDetails) ollamaDetails {
+	families := d.Families
+	if families == nil && d.Family != "" {
+		families = []string{d.Family}
+	}
+	return ollamaDetails{
+		Format:            d.Format,
+		Family:            d.Family,
+		Families:          families,
+		ParameterSize:     d.ParameterSize,
+		QuantizationLevel: d.QuantizationLevel,
+	}
+}
+
+func toOpenAIModel(m ModelInfo) openAIModel {
+	owner := defaultNamespace
+	if ref, err := parseModelRef(m.Name); err == nil {
+		owner = ref.Namespace
+	}
+	return openAIModel{
+		ID:      m.Name + ":" + m.Tag,
+		Object:  "model",
+		Created: m.Modified.Unix(),
+		OwnedBy: owner,
+	}
+}
+
+// sortedModels returns the published catalog sorted by name.
+func (s *ModelServer) sortedModels(ctx context.Context) ([]ModelInfo, error) {
+	models, err := s.getAvailableModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	query, _ := parseModelQuery(nil)
+	models, _, _ = query.Apply(models)
+	return models, nil
+}
+
+// handleOllamaTags serves Ollama's GET /api/tags.
+func (s *ModelServer) handleOllamaTags(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	models, err := s.sortedModels(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to list models")
+		return
+	}
+
+	tags := []ollamaModel{}
+	for _, m := range models {
+		name := m.Name + ":" + m.Tag
+		digest := ""
+		if ref, err := parseModelRef(name); err == nil {
+			target, _ := s.resolveRef(ref)
+			if d, err := manifestDigest(target.manifestPath(s.modelsDir)); err == nil {
+				digest = strings.TrimPrefix(d, "sha256:")
+			}
+		}
+		tags = append(tags, ollamaModel{
+			Name:       name,
+			Model:      name,
+			ModifiedAt: m.Modified,
+			Size:       m.Size,
+			Digest:     digest,
+			Details:    toOllamaDetails(m.Details),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"models": tags})
+	requestLog(r).Info("Listed models", "api", "ollama", "count", len(tags))
+}
+
+// handleOllamaShow serves Ollama's POST /api/show.
+func (s *ModelServer) handleOllamaShow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"` // used by older clients
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Model == "" {
+		req.Model = req.Name
+	}
+	if req.Model == "" {
+		writeJSONError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+	ref, err := parseModelRef(req.Model)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	detail, status, err := s.modelDetail(r.Context(), ref)
+	if err != nil {
+		if status == http.StatusNotFound {
+			writeJSONError(w, status, "model '"+req.Model+"' not found")
+		} else {
+			writeJSONError(w, status, err.Error())
+		}
+		return
+	}
+	s.inspectWeights(r.Context(), detail)
+
+	resp := ollamaShowResponse{
+		License:    strings.Join(detail.License, "\n"),
+		Modelfile:  detail.Modelfile(),
+		Parameters: strings.Join(detail.parameterLines(), "\n"),
+		Template:   detail.Template,
+		System:     detail.System,
+		Details:    toOllamaDetails(detail.Details),
+		Messages:   detail.Messages,
+		ModifiedAt: detail.Modified,
+	}
+	if detail.GGUF != nil {
+		// Like Ollama without "verbose", large arrays such as the token
+		// list are returned empty
+		resp.ModelInfo = map[string]interface{}{"general.parameter_count": detail.GGUF.ParameterCount}
+		for key, value := range detail.GGUF.Metadata {
+			if _, ok := value.(GGUFArray); ok {
+				value = []interface{}{}
+			}
+			resp.ModelInfo[key] = value
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+	requestLog(r).Info("Model shown", "api", "ollama", logModel, ref.String())
+}
+
+// handleOpenAIModels serves OpenAI's GET /v1/models and /v1/models/{id}.
+func (s *ModelServer) handleOpenAIModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed", "invalid_request_error")
+		return
+	}
+	models, err := s.sortedModels(r.Context())
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "failed to list models", "server_error")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/models"), "/")
+	if id != "" {
+		ref, err := parseModelRef(id)
+		if err == nil {
+			for _, m := range models {
+				if m.Name == ref.Name() && m.Tag == ref.Tag {
+					writeJSON(w, http.StatusOK, toOpenAIModel(m))
+					return
+				}
+			}
+		}
+		writeOpenAIError(w, http.StatusNotFound, "The model '"+id+"' does not exist", "invalid_request_error")
+		return
+	}
+
+	data := []openAIModel{}
+	for _, m := range models {
+		data = append(data, toOpenAIModel(m))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+	requestLog(r).Info("Listed models", "api", "openai", "count", len(data))
+}
+
+// writeOpenAIError writes an error in the OpenAI API's format.
+func writeOpenAIError(w http.ResponseWriter, status int, msg, errType string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{"message": msg, "type": errType, "param": nil, "code": nil},
+	})
+}
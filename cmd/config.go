@@ -0,0 +1,608 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to config keys to form environment variable names:
+// serve.access-log.max-size is OLLAMA_LANCACHE_SERVE_ACCESS_LOG_MAX_SIZE.
+const envPrefix = "OLLAMA_LANCACHE"
+
+// appConfig is the whole configuration, merged by viper from flags,
+// OLLAMA_LANCACHE_* environment variables, the config file and defaults, in
+// that order. Defaults come from the flag definitions.
+type appConfig struct {
+	Log    logConfig    `mapstructure:"log"`
+	Serve  serveConfig  `mapstructure:"serve"`
+	Client clientConfig `mapstructure:"client"`
+}
+
+type logConfig struct {
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+}
+
+type serveConfig struct {
+	Port           int                 `mapstructure:"port"`
+	Bind           string              `mapstructure:"bind"`
+	ModelsDir      string              `mapstructure:"models-dir"`
+	DataDir        string              `mapstructure:"data-dir"`
+	AdminToken     string              `mapstructure:"admin-token"`
+	AutoApprove    bool                `mapstructure:"auto-approve"`
+	PublicURL      string              `mapstructure:"public-url"`
+	Push           pushConfig          `mapstructure:"push"`
+	AccessLog      accessLogConfig     `mapstructure:"access-log"`
+	Quota          clientQuotaConfig   `mapstructure:"quota"`
+	Quotas         []quotaConfig       `mapstructure:"quotas"`
+	TrustedProxies []string            `mapstructure:"trusted-proxies"`
+	TrustedHeader  string              `mapstructure:"trusted-header"`
+	ProxyProtocol  bool                `mapstructure:"proxy-protocol"`
+	Webhook        webhookFlagConfig   `mapstructure:"webhook"`
+	Webhooks       []webhookConfig     `mapstructure:"webhooks"`
+	Tracing        tracingConfig       `mapstructure:"tracing"`
+	Aliases        []aliasConfig       `mapstructure:"aliases"`
+	Profiles       []profileConfig     `mapstructure:"profiles"`
+	Scripts        installScriptConfig `mapstructure:"scripts"`
+	Downloads      downloadsConfig     `mapstructure:"downloads"`
+	SigningKey     string              `mapstructure:"signing-key"`
+}
+
+type pushConfig struct {
+	Tokens         []string `mapstructure:"tokens"`
+	AuthorizedKeys string   `mapstructure:"authorized-keys"`
+}
+
+// clientQuotaConfig is the quota every client IP gets (--quota-per-client).
+type clientQuotaConfig struct {
+	PerClient string `mapstructure:"per-client"`
+	Window    string `mapstructure:"window"`
+}
+
+// webhookFlagConfig holds the --webhook targets, which share one secret.
+type webhookFlagConfig struct {
+	URLs   []string `mapstructure:"urls"`
+	Secret string   `mapstructure:"secret"`
+}
+
+// clientConfig is used by the client-side subcommands such as sync.
+type clientConfig struct {
+	Server string `mapstructure:"server"`
+	Token  string `mapstructure:"token"`
+}
+
+// secretKeys are masked by `config show`, including inside list entries.
+var secretKeys = map[string]bool{"admin-token": true, "tokens": true, "upload-tokens": true, "token": true, "secret": true}
+
+// loadConfig decodes the merged configuration.
+func loadConfig() (*appConfig, error) {
+	var cfg appConfig
+	if err := viper.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &cfg, nil
+}
+
+// checkServeConfig loads the configuration for serve. Unknown keys in the
+// config file are logged as warnings; anything invalid is fatal.
+func checkServeConfig() *appConfig {
+	if configReadErr != nil {
+		fatal("Could not read config file", "path", viper.ConfigFileUsed(), "error", configReadErr)
+	}
+
+	if path := viper.ConfigFileUsed(); path != "" {
+		_, problems, err := checkConfigFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			fatal("Could not read config file", "path", path, "error", err)
+		}
+		invalid := false
+		for _, p := range problems {
+			if p.Unknown {
+				slog.Warn("Ignoring unknown config setting", "path", path, "line", p.Line, "key", p.Key, "problem", p.Msg)
+				continue
+			}
+			slog.Error("Invalid config setting", "path", path, "line", p.Line, "key", p.Key, "error", p.Msg)
+			invalid = true
+		}
+		if invalid {
+			fatal("Invalid config file", "path", path)
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal("Could not load configuration", "error", err)
+	}
+	if problems := cfg.Validate(); len(problems) > 0 {
+		for _, p := range problems {
+			slog.Error("Invalid setting", "key", p.Key, "error", p.Msg)
+		}
+		fatal("Invalid configuration; run 'ollama-lancache config validate' for details")
+	}
+	return cfg
+}
+
+// configKey is a setting as shown by `config show`: a scalar, a list of
+// scalars or a list of tables such as serve.profiles.
+type configKey struct {
+	Name string
+	Type reflect.Type
+}
+
+// configKeys lists every setting in struct order.
+func configKeys() []configKey {
+	var keys []configKey
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			tag := t.Field(i).Tag.Get("mapstructure")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			name := prefix + tag
+			if ft := t.Field(i).Type; ft.Kind() == reflect.Struct {
+				walk(ft, name+".")
+			} else {
+				keys = append(keys, configKey{Name: name, Type: ft})
+			}
+		}
+	}
+	walk(reflect.TypeOf(appConfig{}), "")
+	return keys
+}
+
+// tableList reports whether a key holds a list of tables, which can only be
+// set in the config file.
+func (k configKey) tableList() bool {
+	return k.Type.Kind() == reflect.Slice && k.Type.Elem().Kind() == reflect.Struct
+}
+
+// envNames returns the environment variables read for a key, highest
+// precedence first.
+func envNames(key string) []string {
+	name := envPrefix + "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	switch key {
+	case "log.level":
+		return []string{name, "LOG_LEVEL"}
+	case "log.format":
+		return []string{name, "LOG_FORMAT"}
+	}
+	return []string{name}
+}
+
+// bindConfigEnv binds every scalar and list-of-scalars key to its
+// environment variables. Lists are comma separated.
+func bindConfigEnv() {
+	for _, key := range configKeys() {
+		if !key.tableList() {
+			viper.BindEnv(append([]string{key.Name}, envNames(key.Name)...)...)
+		}
+	}
+}
+
+// configProblem is one finding of config validation.
+type configProblem struct {
+	Key     string // e.g. serve.profiles[1]
+	Line    int    // line in the config file, 0 when unknown
+	Msg     string
+	Unknown bool // the key is not a setting; only a warning when serving
+}
+
+func (p configProblem) String() string {
+	return p.Key + ": " + p.Msg
+}
+
+// Validate checks the values that every source can set. The problems carry
+// no line numbers; see configFileIndex.locate.
+func (c *appConfig) Validate() []configProblem {
+	var problems []configProblem
+	add := func(key string, err error) {
+		if err != nil {
+			problems = append(problems, configProblem{Key: key, Msg: err.Error()})
+		}
+	}
+
+	_, err := parseLogLevel(c.Log.Level)
+	add("log.level", err)
+	add("log.format", checkLogFormat(c.Log.Format))
+
+	s := c.Serve
+	if s.Port < 1 || s.Port > 65535 {
+		add("serve.port", fmt.Errorf("%d is not a port number (use 1 to 65535)", s.Port))
+	}
+	// Resolved the way the listener will resolve it, so host names such as
+	// localhost are accepted.
+	if _, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(s.Bind, "0")); err != nil {
+		add("serve.bind", fmt.Errorf("%q is not an address to listen on: %w", s.Bind, err))
+	}
+	if s.ModelsDir != "" {
+		if info, err := os.Stat(s.ModelsDir); err != nil || !info.IsDir() {
+			add("serve.models-dir", fmt.Errorf("directory %s does not exist", s.ModelsDir))
+		}
+	}
+	if s.Downloads.Dir != "" {
+		if info, err := os.Stat(s.Downloads.Dir); err == nil && !info.IsDir() {
+			add("serve.downloads.dir", fmt.Errorf("%s is not a directory", s.Downloads.Dir))
+		}
+	}
+	_, err = parseUploadLimits(s.Downloads)
+	add("serve.downloads", err)
+	// A missing key is created when serving, so only an existing one is read
+	if _, statErr := os.Stat(s.SigningKey); s.SigningKey != "" && statErr == nil {
+		_, err := loadSigner(s.SigningKey)
+		add("serve.signing-key", err)
+	}
+	_, err = newPushAuth(s.Push.Tokens, s.AdminToken, s.Push.AuthorizedKeys)
+	add("serve.push.authorized-keys", err)
+	if s.AccessLog.Path != "" {
+		_, err := s.AccessLog.rotation()
+		add("serve.access-log", err)
+	}
+
+	if s.Quota.PerClient != "" {
+		_, err := newQuotaRule(quotaConfig{Name: "per-client", Client: "*", Limit: s.Quota.PerClient, Window: s.Quota.Window})
+		add("serve.quota", err)
+	}
+	for i, q := range s.Quotas {
+		_, err := newQuotaRule(q)
+		add(fmt.Sprintf("serve.quotas[%d]", i), err)
+	}
+
+	proxies, err := parseTrustedProxies(s.TrustedProxies)
+	add("serve.trusted-proxies", err)
+	_, headerErr := parseTrustedHeader(s.TrustedHeader)
+	add("serve.trusted-header", headerErr)
+	if s.ProxyProtocol && len(proxies) == 0 && err == nil {
+		add("serve.proxy-protocol", errors.New("requires serve.trusted-proxies for the load balancers that send it"))
+	}
+
+	for i, u := range s.Webhook.URLs {
+		_, err := parseWebhookTargets([]webhookConfig{{URL: u}})
+		add(fmt.Sprintf("serve.webhook.urls[%d]", i), err)
+	}
+	listOK := true
+	for i, w := range s.Webhooks {
+		if _, err := parseWebhookTargets([]webhookConfig{w}); err != nil {
+			add(fmt.Sprintf("serve.webhooks[%d]", i), err)
+			listOK = false
+		}
+	}
+	if listOK {
+		_, err := parseWebhookTargets(s.Webhooks)
+		add("serve.webhooks", err)
+	}
+
+	switch strings.ToLower(s.Tracing.Exporter) {
+	case "", "none", "otlp", "file":
+	default:
+		add("serve.tracing.exporter", fmt.Errorf("invalid exporter %q (use none, otlp or file)", s.Tracing.Exporter))
+	}
+	if s.Tracing.SampleRatio < 0 || s.Tracing.SampleRatio > 1 {
+		add("serve.tracing.sample-ratio", fmt.Errorf("%v is not between 0 and 1", s.Tracing.SampleRatio))
+	}
+
+	for i, a := range s.Aliases {
+		if _, err := parseModelRef(a.Name); err != nil {
+			add(fmt.Sprintf("serve.aliases[%d].name", i), err)
+		}
+		if _, err := parseModelRef(a.Target); err != nil {
+			add(fmt.Sprintf("serve.aliases[%d].target", i), err)
+		}
+	}
+
+	listOK = true
+	for i, p := range s.Profiles {
+		if _, err := newProfiles([]profileConfig{p}); err != nil {
+			add(fmt.Sprintf("serve.profiles[%d]", i), err)
+			listOK = false
+		}
+	}
+	profiles := &Profiles{byName: map[string]int{}}
+	if listOK {
+		if p, err := newProfiles(s.Profiles); err != nil {
+			add("serve.profiles", err)
+		} else {
+			profiles = p
+		}
+	}
+
+	_, err = newInstallScripts(installScriptConfig{PublicURL: s.PublicURL}, profiles)
+	add("serve.public-url", err)
+	scripts := s.Scripts
+	scripts.PublicURL = ""
+	_, err = newInstallScripts(scripts, profiles)
+	add("serve.scripts", err)
+
+	if c.Client.Server != "" {
+		if u, err := url.Parse(c.Client.Server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("client.server", fmt.Errorf("invalid server URL %q", c.Client.Server))
+		}
+	}
+	return problems
+}
+
+// configFileIndex is the config file parsed as YAML nodes, to check its
+// keys and types and to find the line of each setting.
+type configFileIndex struct {
+	Path  string
+	lines map[string]int // key (with [i] for list entries) -> line
+}
+
+// checkConfigFile parses a config file and reports unknown keys and values
+// of the wrong type, with line numbers. A syntax error is returned as err.
+func checkConfigFile(path string) (*configFileIndex, []configProblem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	idx := &configFileIndex{Path: path, lines: make(map[string]int)}
+	var problems []configProblem
+	if len(doc.Content) > 0 {
+		idx.walk(doc.Content[0], reflect.TypeOf(appConfig{}), "", &problems)
+	}
+	return idx, problems, nil
+}
+
+func (idx *configFileIndex) walk(node *yaml.Node, t reflect.Type, key string, problems *[]configProblem) {
+	problem := func(msg string) {
+		*problems = append(*problems, configProblem{Key: key, Line: node.Line, Msg: msg})
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Tag == "!!null" {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			problem("expected a table of settings")
+			return
+		}
+		fields := make(map[string]reflect.Type)
+		var names []string
+		for i := 0; i < t.NumField(); i++ {
+			if tag := t.Field(i).Tag.Get("mapstructure"); tag != "" && tag != "-" {
+				fields[tag] = t.Field(i).Type
+				names = append(names, tag)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k, v := node.Content[i], node.Content[i+1]
+			name := strings.ToLower(k.Value)
+			child := name
+			if key != "" {
+				child = key + "." + name
+			}
+			ft, ok := fields[name]
+			if !ok {
+				msg := "unknown setting"
+				if guess := closestKey(name, names); guess != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", guess)
+				}
+				*problems = append(*problems, configProblem{Key: child, Line: k.Line, Msg: msg, Unknown: true})
+				continue
+			}
+			idx.lines[child] = k.Line
+			idx.walk(v, ft, child, problems)
+		}
+
+	case reflect.Slice:
+		if node.Kind == yaml.ScalarNode && t.Elem().Kind() == reflect.String {
+			return // comma separated, as in environment variables
+		}
+		if node.Kind != yaml.SequenceNode {
+			problem("expected a list")
+			return
+		}
+		for i, item := range node.Content {
+			child := fmt.Sprintf("%s[%d]", key, i)
+			idx.lines[child] = item.Line
+			idx.walk(item, t.Elem(), child, problems)
+		}
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			problem("expected a single value")
+			return
+		}
+		var err error
+		switch t.Kind() {
+		case reflect.Int:
+			_, err = strconv.Atoi(node.Value)
+		case reflect.Float64:
+			_, err = strconv.ParseFloat(node.Value, 64)
+		case reflect.Bool:
+			_, err = strconv.ParseBool(node.Value)
+		}
+		if err != nil {
+			problem(fmt.Sprintf("%q is not a %s", node.Value, map[reflect.Kind]string{
+				reflect.Int: "whole number", reflect.Float64: "number", reflect.Bool: "boolean (true or false)",
+			}[t.Kind()]))
+		}
+	}
+}
+
+// locate returns the line of key, or of the closest enclosing setting that
+// is in the file.
+func (idx *configFileIndex) locate(key string) int {
+	if idx == nil {
+		return 0
+	}
+	for key != "" {
+		if line, ok := idx.lines[key]; ok {
+			return line
+		}
+		cut := strings.LastIndexAny(key, ".[")
+		if cut < 0 {
+			break
+		}
+		key = key[:cut]
+	}
+	return 0
+}
+
+// closestKey suggests the setting a misspelt key was probably meant to be.
+func closestKey(name string, candidates []string) string {
+	normalized := strings.ReplaceAll(name, "_", "-")
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if c == normalized {
+			return c
+		}
+		if d := editDistance(normalized, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// configValue formats the effective value of a key for `config show`.
+func configValue(key configKey, showSecrets bool) string {
+	name := key.Name[strings.LastIndex(key.Name, ".")+1:]
+	value := viper.Get(key.Name)
+	if !showSecrets {
+		value = maskSecrets(name, value)
+	}
+	switch v := value.(type) {
+	case nil:
+		if key.Type.Kind() == reflect.Slice {
+			return "[]"
+		}
+		return `""`
+	case string:
+		// Environment variables arrive as strings whatever the type
+		if key.Type.Kind() != reflect.String && key.Type.Kind() != reflect.Slice {
+			return v
+		}
+		return strconv.Quote(v)
+	case []string:
+		if len(v) == 0 {
+			return "[]"
+		}
+	}
+	return formatJSON(value)
+}
+
+// configEntries formats each entry of a list of tables on its own, since
+// they are too long to share a line.
+func configEntries(key configKey, showSecrets bool) []string {
+	value := viper.Get(key.Name)
+	if !showSecrets {
+		value = maskSecrets("", value)
+	}
+	list, _ := value.([]interface{})
+	entries := make([]string, len(list))
+	for i, item := range list {
+		entries[i] = formatJSON(item)
+	}
+	return entries
+}
+
+func formatJSON(value interface{}) string {
+	data, err := json.Marshal(normalizeYAML(value))
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// maskSecrets replaces the values of secret settings, also inside lists of
+// tables.
+func maskSecrets(name string, value interface{}) interface{} {
+	if secretKeys[name] {
+		if s := fmt.Sprint(value); s == "" || s == "[]" {
+			return value
+		}
+		return "********"
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = maskSecrets("", item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = maskSecrets(k, item)
+		}
+		return out
+	}
+	return value
+}
+
+// normalizeYAML turns map[interface{}]interface{} from nested YAML into
+// something encoding/json accepts.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeYAML(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[fmt.Sprint(k)] = normalizeYAML(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = normalizeYAML(item)
+		}
+		return out
+	}
+	return value
+}
+
+// sortProblems orders problems by line, then key.
+func sortProblems(problems []configProblem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Key < problems[j].Key
+	})
+}
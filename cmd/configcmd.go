@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect, check and create the config file",
+	Long: `Inspect, check and create the config file.
+
+Settings are read from command line flags, OLLAMA_LANCACHE_* environment
+variables, the config file (--config, default ~/.ollama-lancache.yaml) and
+built-in defaults, in that order. The environment variable for a key is
+OLLAMA_LANCACHE_ followed by the key in upper case with dots and dashes
+replaced by underscores, e.g. OLLAMA_LANCACHE_SERVE_ACCESS_LOG_MAX_SIZE for
+serve.access-log.max-size. Lists are comma separated; lists of tables such as
+serve.profiles can only be set in the file.`,
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration and where each value comes from",
+	Args:  cobra.NoArgs,
+	RunE:  runConfigShow,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [FILE]",
+	Short: "Check a config file for unknown keys and invalid values",
+	Long: `Check a config file for unknown keys, values of the wrong type and invalid
+settings, reporting each problem with its line number. Without FILE the file
+from --config or ~/.ollama-lancache.yaml is checked. Environment variables
+are applied as they would be when serving.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigValidate,
+}
+
+var configInitCmd = &cobra.Command{
+	Use:   "init [FILE]",
+	Short: "Write a commented starter config file",
+	Long: `Write a commented starter config file listing every setting. Without FILE
+it goes to --config or ~/.ollama-lancache.yaml; "-" writes to stdout.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigInit,
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd, configValidateCmd, configInitCmd)
+
+	configShowCmd.Flags().Bool("show-secrets", false, "Print tokens and secrets instead of masking them")
+	configInitCmd.Flags().Bool("force", false, "Overwrite an existing file")
+}
+
+// configSource describes where the effective value of key comes from.
+func configSource(cmd *cobra.Command, key string, idx *configFileIndex) string {
+	for flagName, flagKey := range map[string]string{"log-level": "log.level", "log-format": "log.format"} {
+		if flagKey == key {
+			if f := cmd.Flags().Lookup(flagName); f != nil && f.Changed {
+				return "flag --" + flagName
+			}
+		}
+	}
+	for _, env := range envNames(key) {
+		if _, ok := os.LookupEnv(env); ok {
+			return "env " + env
+		}
+	}
+	if viper.InConfig(key) {
+		if line := idx.locate(key); line > 0 {
+			return fmt.Sprintf("file, line %d", line)
+		}
+		return "file"
+	}
+	return "default"
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	if configReadErr != nil {
+		return fmt.Errorf("could not read config file: %w", configReadErr)
+	}
+	showSecrets, _ := cmd.Flags().GetBool("show-secrets")
+	out := cmd.OutOrStdout()
+
+	var idx *configFileIndex
+	if path := viper.ConfigFileUsed(); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			fmt.Fprintf(out, "# Config file: %s\n", path)
+			idx, _, _ = checkConfigFile(path)
+		} else {
+			fmt.Fprintln(out, "# Config file: none")
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, key := range configKeys() {
+		if key.tableList() {
+			entries := configEntries(key, showSecrets)
+			fmt.Fprintf(w, "%s\t(%d entries)\t# %s\n", key.Name, len(entries), configSource(cmd, key.Name, idx))
+			for _, entry := range entries {
+				// No tabs, so long entries do not widen the columns
+				fmt.Fprintf(w, "  - %s\n", entry)
+			}
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t# %s\n", key.Name, configValue(key, showSecrets), configSource(cmd, key.Name, idx))
+	}
+	return w.Flush()
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	path := viper.ConfigFileUsed()
+	if len(args) == 1 {
+		path = args[0]
+		viper.SetConfigFile(path)
+		configReadErr = viper.ReadInConfig()
+	}
+	if path == "" {
+		return errors.New("no config file to validate; give a path or use --config")
+	}
+	out := cmd.OutOrStdout()
+
+	idx, problems, err := checkConfigFile(path)
+	if err != nil {
+		// YAML syntax errors already name the line
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if configReadErr != nil {
+		return fmt.Errorf("%s: %w", path, configReadErr)
+	}
+
+	// Values are only checked once the file decodes
+	if len(problems) == 0 || onlyUnknown(problems) {
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		for _, p := range cfg.Validate() {
+			p.Line = idx.locate(p.Key)
+			problems = append(problems, p)
+		}
+	}
+	sortProblems(problems)
+
+	for _, p := range problems {
+		location := path
+		if p.Line > 0 {
+			location = fmt.Sprintf("%s:%d", path, p.Line)
+		} else {
+			location += " (" + configSource(cmd, p.Key, idx) + ")"
+		}
+		fmt.Fprintf(out, "%s: %s\n", location, p)
+	}
+	if len(problems) > 0 {
+		return &ExitError{Code: 1, Err: fmt.Errorf("%d problem(s) found", len(problems))}
+	}
+	fmt.Fprintf(out, "✅ %s is valid\n", path)
+	return nil
+}
+
+func onlyUnknown(problems []configProblem) bool {
+	for _, p := range problems {
+		if !p.Unknown {
+			return false
+		}
+	}
+	return true
+}
+
+func runConfigInit(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	path := cfgFile
+	if len(args) == 1 {
+		path = args[0]
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, ".ollama-lancache.yaml")
+	}
+	if path == "-" {
+		_, err := io.WriteString(cmd.OutOrStdout(), starterConfig)
+		return err
+	}
+
+	force, _ := cmd.Flags().GetBool("force")
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists (use --force to overwrite it)", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, starterConfig); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "✅ Wrote %s\n", path)
+	return nil
+}
+
+// starterConfig is written by `config init`. It must pass `config validate`.
+const starterConfig = `# ollama-lancache configuration
+#
+# Every setting can also come from an environment variable: OLLAMA_LANCACHE_
+# plus the key in upper case with dots and dashes turned into underscores,
+# e.g. OLLAMA_LANCACHE_SERVE_PORT=9090. Command line flags override both.
+# Check this file with: ollama-lancache config validate
+
+log:
+  level: info                 # debug, info, warn or error
+  format: text                # text or json
+
+serve:
+  port: 8080
+  bind: 0.0.0.0
+  # models-dir: /srv/ollama/models          # default: ~/.ollama/models
+  # data-dir: /var/lib/ollama-lancache      # default: ~/.ollama-lancache
+  # admin-token: change-me                  # enables the admin API
+  # auto-approve: false                     # publish new models without approval
+  # public-url: https://models.example.com  # URL used in the install scripts
+
+  # Who may publish models with ollama push
+  # push:
+  #   tokens: [change-me]
+  #   authorized-keys: /etc/ollama-lancache/authorized_keys
+
+  # access-log:
+  #   path: /var/log/ollama-lancache/access.log   # "-" for stdout
+  #   format: combined                            # common, combined or json
+  #   max-size: 100MB
+  #   max-age: 7d
+  #   max-backups: 10
+  #   compress: true
+
+  # Download quotas over a rolling window: daily, weekly or a duration
+  # quota:
+  #   per-client: 200GB
+  #   window: daily
+  # quotas:
+  #   - name: lab
+  #     subnet: 10.20.0.0/16
+  #     limit: 1TB
+  #     window: weekly
+
+  # Reverse proxies whose forwarding headers are believed
+  # trusted-proxies: [10.0.0.5]
+  # trusted-header: x-forwarded-for   # or forwarded, x-real-ip
+  # proxy-protocol: false
+
+  # webhooks:
+  #   - name: chat
+  #     url: https://hooks.example.com/lancache
+  #     secret: change-me
+  #     events: ["session.*", model.added]
+
+  # tracing:
+  #   exporter: none                # none, otlp or file
+  #   endpoint: http://collector:4318
+  #   sample-ratio: 1
+
+  # aliases:
+  #   - name: coder:stable
+  #     target: qwen2.5-coder:7b
+
+  # profiles:
+  #   - name: support
+  #     description: Small chat models
+  #     models: [llama3.2:3b, qwen2.5:1.5b]
+  #     clients: [10.20.0.0/16]   # machines that get this profile by default
+
+  # ed25519 key (PEM) that signs SHA256SUMS and the install scripts; the
+  # public half is served at /signing-key.pem. Created if missing.
+  # signing-key: /etc/ollama-lancache/signing-key.pem
+
+  # Install scripts served at /install.sh and /install.ps1
+  # scripts:
+  #   default-profile: support      # or default-model
+  #   ca-cert: /etc/ollama-lancache/ca.pem
+  #   dir: /etc/ollama-lancache/scripts
+
+  # Files served at /downloads/
+  # downloads:
+  #   dir: /srv/lancache/downloads   # default: <data-dir>/downloads
+  #   readme: true                   # write README.txt when creating it
+  #   upload-tokens: [change-me]     # allow PUT /downloads/<path> (the admin token also works)
+  #   max-upload-size: 4GB
+  #   default-expiry: 30d            # delete uploads after this long; empty keeps them
+
+# Defaults for the sync subcommand on client machines
+# client:
+#   server: http://192.168.1.100:8080
+#   token: change-me
+`
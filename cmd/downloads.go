@@ -0,0 +1,501 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// downloadsConfig configures the /downloads/ file server.
+type downloadsConfig struct {
+	Dir           string   `mapstructure:"dir"`             // default: <data-dir>/downloads
+	Readme        bool     `mapstructure:"readme"`          // write README.txt when the directory is created
+	UploadTokens  []string `mapstructure:"upload-tokens"`   // bearer tokens allowed to upload, besides the admin token
+	MaxUploadSize string   `mapstructure:"max-upload-size"` // largest accepted file, e.g. 4GB
+	DefaultExpiry string   `mapstructure:"default-expiry"`  // age after which uploads are deleted; empty keeps them
+}
+
+// Downloads serves the files below a root directory at /downloads/,
+// including subdirectories. Hidden files and anything a symlink leads to
+// outside the root are treated as if they did not exist.
+type Downloads struct {
+	root string // absolute, with symlinks resolved
+
+	// Uploads; see uploads.go
+	tokens        []string
+	maxUpload     int64
+	defaultExpiry time.Duration
+	uploadsPath   string
+
+	mu      sync.Mutex
+	uploads map[string]*uploadRecord // key: path relative to the root
+
+	// Checksums; see checksums.go
+	sumsPath   string
+	sumMu      sync.Mutex
+	sums       map[string]cachedSum // key: path relative to the root
+	sumsDirty  bool
+	refreshing atomic.Bool // refreshChecksums is running
+}
+
+// downloadEntry is a file or directory in a downloads listing.
+type downloadEntry struct {
+	Name    string
+	Path    string // slash separated, relative to the root
+	Dir     bool
+	Size    int64
+	ModTime time.Time
+	Expires *time.Time // when an upload with an expiry is deleted
+
+	real string // resolved absolute path
+}
+
+func newDownloads(cfg downloadsConfig, dataDir, adminToken string) (*Downloads, error) {
+	uploads, err := parseUploadLimits(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := filepath.Abs(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	created := false
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create downloads directory: %w", err)
+		}
+		created = true
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("downloads path %s is not a directory", cfg.Dir)
+	}
+
+	if created {
+		slog.Info("Created downloads directory", "path", root)
+		if cfg.Readme {
+			readmePath := filepath.Join(root, "README.txt")
+			if err := os.WriteFile(readmePath, []byte(downloadsReadme), 0644); err != nil {
+				slog.Warn("Could not create downloads README", "path", readmePath, "error", err)
+			} else {
+				slog.Info("Created downloads README", "path", readmePath)
+			}
+		}
+	}
+	d := &Downloads{
+		root:          root,
+		tokens:        append([]string{adminToken}, cfg.UploadTokens...),
+		maxUpload:     uploads.maxUpload,
+		defaultExpiry: uploads.defaultExpiry,
+		uploadsPath:   filepath.Join(dataDir, "uploads.json"),
+		uploads:       make(map[string]*uploadRecord),
+		sumsPath:      filepath.Join(dataDir, "checksums.json"),
+		sums:          make(map[string]cachedSum),
+	}
+	if err := d.loadUploads(); err != nil {
+		return nil, err
+	}
+	if err := d.loadChecksums(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// hiddenName reports whether a path element is hidden. This includes "."
+// and "..", so a path made only of visible names cannot climb out.
+func hiddenName(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// visible reports whether an absolute, symlink-free path lies within the
+// root without passing through a hidden file or directory.
+func (d *Downloads) visible(path string) bool {
+	rel, err := filepath.Rel(d.root, path)
+	if err != nil {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if hiddenName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve maps a slash separated path below /downloads/ to the file or
+// directory it names, following symlinks as long as they stay within the
+// root. Hidden, reserved and escaping paths, and expired uploads, give
+// fs.ErrNotExist.
+func (d *Downloads) resolve(rel string) (string, fs.FileInfo, error) {
+	path := d.root
+	for _, name := range strings.Split(rel, "/") {
+		if name == "" {
+			continue
+		}
+		if hiddenName(name) || reservedName(name) {
+			return "", nil, fs.ErrNotExist
+		}
+		path = filepath.Join(path, name)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !d.visible(real) || d.expired(real) {
+		return "", nil, fs.ErrNotExist
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", nil, err
+	}
+	return real, info, nil
+}
+
+// list returns the visible entries of a directory below the root. Broken
+// symlinks and symlinks leading out of the root are left out.
+func (d *Downloads) list(rel string) ([]downloadEntry, error) {
+	dir, info, err := d.resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", rel)
+	}
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(rel, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	entries := []downloadEntry{}
+	for _, item := range items {
+		if hiddenName(item.Name()) || reservedName(item.Name()) {
+			continue
+		}
+		real, info, err := d.resolve(prefix + item.Name())
+		if err != nil {
+			continue
+		}
+		entries = append(entries, downloadEntry{
+			Name:    item.Name(),
+			Path:    prefix + item.Name(),
+			Dir:     info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			Expires: d.expiry(real),
+			real:    real,
+		})
+	}
+	return entries, nil
+}
+
+// sortDownloads orders entries by name, size or modified time, always with
+// directories first.
+func sortDownloads(entries []downloadEntry, by string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		if desc {
+			a, b = b, a
+		}
+		switch by {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "modified":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
+
+// downloadURL is the URL path of a file or directory below the root.
+func downloadURL(rel string, dir bool) string {
+	var parts []string
+	for _, name := range strings.Split(rel, "/") {
+		if name != "" {
+			parts = append(parts, url.PathEscape(name))
+		}
+	}
+	u := "/downloads/" + strings.Join(parts, "/")
+	if dir && len(parts) > 0 {
+		u += "/"
+	}
+	return u
+}
+
+func (s *ModelServer) handleDownloadsServer(w http.ResponseWriter, r *http.Request) {
+	rel := strings.TrimPrefix(r.URL.Path, "/downloads/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		s.handleDownloadPut(w, r, rel)
+		return
+	case http.MethodPost:
+		s.handleDownloadForm(w, r, rel)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path, info, err := s.downloads.resolve(rel)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !s.serveGeneratedChecksum(w, r, rel) {
+			http.Error(w, "File not found", http.StatusNotFound)
+		}
+		return
+	}
+	if err != nil {
+		requestLog(r).Error("Could not resolve download", "path", rel, "error", err)
+		http.Error(w, "Could not read downloads directory", http.StatusInternalServerError)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := downloadURL(rel, true)
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		s.handleDownloadsListing(w, r, rel)
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		requestLog(r).Error("Could not open download", "path", rel, "error", err)
+		http.Error(w, "Could not read file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if sum := s.downloads.cachedChecksum(path, info); sum != "" {
+		w.Header().Set("X-Checksum-Sha256", sum)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+
+	requestLog(r).Info("File downloaded", "path", rel, "bytes", info.Size())
+}
+
+func (s *ModelServer) handleDownloadsListing(w http.ResponseWriter, r *http.Request, rel string) {
+	entries, err := s.downloads.list(rel)
+	if err != nil {
+		requestLog(r).Error("Could not list downloads", "path", rel, "error", err)
+		http.Error(w, "Could not read downloads directory", http.StatusInternalServerError)
+		return
+	}
+
+	by := r.URL.Query().Get("sort")
+	switch by {
+	case "name", "size", "modified":
+	default:
+		by = "name"
+	}
+	desc := r.URL.Query().Get("order") == "desc"
+	sortDownloads(entries, by, desc)
+
+	rel = strings.Trim(rel, "/")
+	title := "Downloads"
+	if rel != "" {
+		title += " - " + rel
+	}
+
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>` + htmlEscape(title) + ` - ollama-lancache</title>
+    <style>
+        body { font-family: Arial, sans-serif; margin: 40px; }
+        .header { color: #333; }
+        .crumbs { margin: 10px 0 20px; }
+        .crumbs a, .sort a { color: #0066cc; text-decoration: none; }
+        .sort { color: #666; font-size: 0.9em; margin-bottom: 10px; }
+        .sort .active { font-weight: bold; }
+        .file { padding: 10px; border: 1px solid #ddd; margin: 5px 0; border-radius: 5px; }
+        .file a { text-decoration: none; color: #0066cc; }
+        .file a:hover { text-decoration: underline; }
+        .size { color: #666; font-size: 0.9em; }
+        .empty { color: #999; font-style: italic; padding: 20px; text-align: center; }
+        .upload { padding: 10px; border: 1px dashed #bbb; margin: 10px 0 20px; border-radius: 5px; }
+        .upload input { margin-right: 8px; }
+    </style>
+</head>
+<body>
+    <h1 class="header">📁 Downloads</h1>
+    <p><a href="/">← Back to ollama-lancache</a></p>
+    <div class="crumbs">`)
+
+	// Breadcrumbs: Downloads / a / b, each linking to its directory
+	b.WriteString(`<a href="/downloads/">downloads</a>`)
+	if rel != "" {
+		names := strings.Split(rel, "/")
+		for i, name := range names {
+			if i == len(names)-1 {
+				fmt.Fprintf(&b, ` / <strong>%s</strong>`, htmlEscape(name))
+				continue
+			}
+			fmt.Fprintf(&b, ` / <a href="%s">%s</a>`, htmlEscape(downloadURL(strings.Join(names[:i+1], "/"), true)), htmlEscape(name))
+		}
+	}
+	b.WriteString(`</div>`)
+	if s.downloads.uploadsEnabled() {
+		// Fields come before the files so the token is known when they arrive
+		b.WriteString(`
+    <form class="upload" method="post" enctype="multipart/form-data">
+        <input type="password" name="token" placeholder="Upload token">
+        <input type="text" name="expires" placeholder="Expires in, e.g. 7d" size="16">
+        <label><input type="checkbox" name="overwrite" value="true">Replace existing</label>
+        <input type="file" name="file" multiple required>
+        <button type="submit">⬆️ Upload</button>
+    </form>`)
+	}
+	b.WriteString(`
+    <div class="sort">Verify: <a href="SHA256SUMS">SHA256SUMS</a>`)
+	if s.signer != nil {
+		b.WriteString(` (<a href="SHA256SUMS.sig">signature</a>, <a href="/signing-key.pem">public key</a>)`)
+	}
+	b.WriteString(`</div>
+    <div class="sort">Sort by:`)
+	for _, key := range []string{"name", "size", "modified"} {
+		order, arrow, class := "asc", "", ""
+		if key == by {
+			class = ` class="active"`
+			arrow = " ▲"
+			if desc {
+				arrow = " ▼"
+			} else {
+				order = "desc"
+			}
+		}
+		fmt.Fprintf(&b, ` <a%s href="?sort=%s&amp;order=%s">%s%s</a>`, class, key, order, key, arrow)
+	}
+	b.WriteString(`</div>
+    <div class="files">`)
+
+	if rel != "" {
+		parent := ""
+		if i := strings.LastIndex(rel, "/"); i >= 0 {
+			parent = rel[:i]
+		}
+		fmt.Fprintf(&b, `
+        <div class="file"><a href="%s">⬆️ ..</a></div>`, htmlEscape(downloadURL(parent, true)))
+	}
+	if len(entries) == 0 {
+		b.WriteString(`<div class="empty">No files available for download</div>`)
+	}
+	for _, e := range entries {
+		if e.Dir {
+			fmt.Fprintf(&b, `
+        <div class="file">
+            <a href="%s">📁 %s/</a>
+            <div class="size">Modified: %s</div>
+        </div>`, htmlEscape(downloadURL(e.Path, true)), htmlEscape(e.Name), e.ModTime.Format("2006-01-02 15:04:05"))
+			continue
+		}
+		expires := ""
+		if e.Expires != nil {
+			expires = " - Expires: " + e.Expires.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(&b, `
+        <div class="file">
+            <a href="%s">📄 %s</a>
+            <div class="size">%s - Modified: %s%s</div>
+        </div>`, htmlEscape(downloadURL(e.Path, false)), htmlEscape(e.Name), formatBytes(e.Size), e.ModTime.Format("2006-01-02 15:04:05"), expires)
+	}
+
+	b.WriteString(`
+    </div>
+</body>
+</html>`)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(b.String()))
+
+	requestLog(r).Info("Downloads directory listing requested", "path", rel)
+}
+
+// downloadsReadme is written to a newly created downloads directory when
+// serve.downloads.readme is set.
+var downloadsReadme = `# Downloads Directory
+
+This directory serves as a file sharing location for your ollama-lancache server.
+
+## What is this for?
+
+You can place any files here that you want to make available for download to clients on your network:
+
+### File Types Supported:
+- 📦 **Executable files**: .exe, .msi, .deb, .rpm, .dmg
+- 🗜️ **Archive files**: .zip, .tar.gz, .7z, .rar
+- 📄 **Documentation**: .pdf, .txt, .md, .docx
+- ⚙️ **Configuration files**: .json, .yaml, .conf, .ini
+- 📝 **Scripts**: .ps1, .sh, .bat, .py
+
+### How to Use:
+
+1. **Add files**: Copy files or whole folders to this directory
+   ` + "`" + `cp my-app.exe /path/to/downloads/` + "`" + `
+
+2. **Share URLs**: Files are available at:
+   - Browse all files: http://your-server:8080/downloads/
+   - Direct download: http://your-server:8080/downloads/tools/filename.exe
+
+3. **Web interface**: Visit the downloads page for a file browser with sorting
+
+### Security Notes:
+- Hidden files and folders (names starting with ".") are never served
+- Symlinks are followed only while they stay inside this directory
+- Files are served with appropriate content-type headers
+
+### Examples:
+- Share Ollama installers for different platforms
+- Distribute documentation and setup guides
+- Provide configuration templates
+- Share utility scripts and tools
+
+This directory was created by ollama-lancache (disable with --downloads-readme=false).
+You can safely delete this README.txt file if you don't need it.
+
+---
+Generated by ollama-lancache v` + version + `
+`
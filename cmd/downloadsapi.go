@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadInfo is a file or folder as listed by /api/downloads.
+type downloadInfo struct {
+	Name          string     `json:"name"`
+	Path          string     `json:"path"` // relative to the downloads root
+	Type          string     `json:"type"` // "file" or "dir"
+	Size          int64      `json:"size"` // 0 for folders
+	Modified      time.Time  `json:"modified"`
+	ContentType   string     `json:"content_type,omitempty"`
+	SHA256        string     `json:"sha256,omitempty"`
+	SHA256Pending bool       `json:"sha256_pending,omitempty"` // not hashed yet; hashed in the background
+	DownloadURL   string     `json:"download_url"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// DownloadQuery filters and sorts the downloads listing. It is parsed from
+// the query string of /api/downloads.
+type DownloadQuery struct {
+	Path          string // folder to list, relative to the root
+	Recursive     bool
+	Name          string // substring, or a glob when it contains * ? or [
+	Type          string // file or dir; empty for both
+	MinSize       int64
+	MaxSize       int64
+	ModifiedSince time.Time
+	Sort          string // path, name, size or modified
+	Desc          bool
+}
+
+func parseDownloadQuery(values url.Values) (*DownloadQuery, error) {
+	q := &DownloadQuery{
+		Path: strings.Trim(values.Get("path"), "/"),
+		Name: strings.TrimSpace(values.Get("q")),
+		Type: values.Get("type"),
+		Sort: values.Get("sort"),
+	}
+
+	var err error
+	if v := values.Get("recursive"); v != "" {
+		if q.Recursive, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid recursive %q (use true or false)", v)
+		}
+	}
+	switch q.Type {
+	case "", "file", "dir":
+	default:
+		return nil, fmt.Errorf("invalid type %q (use file or dir)", q.Type)
+	}
+	if v := values.Get("min_size"); v != "" {
+		if q.MinSize, err = parseByteSize(v); err != nil {
+			return nil, fmt.Errorf("invalid min_size: %w", err)
+		}
+	}
+	if v := values.Get("max_size"); v != "" {
+		if q.MaxSize, err = parseByteSize(v); err != nil {
+			return nil, fmt.Errorf("invalid max_size: %w", err)
+		}
+	}
+	if v := values.Get("modified_since"); v != "" {
+		if q.ModifiedSince, err = parseSince(v); err != nil {
+			return nil, fmt.Errorf("invalid modified_since: %w", err)
+		}
+	}
+	if strings.ContainsAny(q.Name, "*?[") {
+		if _, err := path.Match(q.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %w", err)
+		}
+	}
+
+	// Paths and names sort A-Z by default; sizes and dates largest first
+	switch q.Sort {
+	case "":
+		q.Sort = "path"
+	case "path", "name", "size", "modified":
+	default:
+		return nil, fmt.Errorf("invalid sort %q (use path, name, size or modified)", q.Sort)
+	}
+	switch values.Get("order") {
+	case "":
+		q.Desc = q.Sort == "size" || q.Sort == "modified"
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %q (use asc or desc)", values.Get("order"))
+	}
+	return q, nil
+}
+
+func (q *DownloadQuery) matches(e downloadEntry) bool {
+	if q.Name != "" {
+		if strings.ContainsAny(q.Name, "*?[") {
+			nameMatch, _ := path.Match(q.Name, e.Name)
+			pathMatch, _ := path.Match(q.Name, e.Path)
+			if !nameMatch && !pathMatch {
+				return false
+			}
+		} else if !strings.Contains(strings.ToLower(e.Path), strings.ToLower(q.Name)) {
+			return false
+		}
+	}
+	if (q.Type == "file" && e.Dir) || (q.Type == "dir" && !e.Dir) {
+		return false
+	}
+	// Size limits only make sense for files
+	if (q.MinSize > 0 || q.MaxSize > 0) && e.Dir {
+		return false
+	}
+	if q.MinSize > 0 && e.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && e.Size > q.MaxSize {
+		return false
+	}
+	if !q.ModifiedSince.IsZero() && e.ModTime.Before(q.ModifiedSince) {
+		return false
+	}
+	return true
+}
+
+func (q *DownloadQuery) sort(entries []downloadEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if q.Desc {
+			a, b = b, a
+		}
+		switch q.Sort {
+		case "name":
+			if !strings.EqualFold(a.Name, b.Name) {
+				return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+			}
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "modified":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return a.Path < b.Path
+	})
+}
+
+// listTree returns the entries of a folder and, recursively, of the folders
+// below it. Like walkFiles, a symlinked folder is only entered once.
+func (d *Downloads) listTree(rel string) ([]downloadEntry, error) {
+	root, _, err := d.resolve(rel)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{root: true}
+	var all []downloadEntry
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		entries, err := d.list(rel)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			all = append(all, e)
+			if e.Dir && !seen[e.real] {
+				seen[e.real] = true
+				if err := walk(e.Path); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(rel); err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// handleDownloadsAPI lists the downloads area as JSON, with the same
+// visibility rules as the HTML browser. The folder comes from the URL
+// (/api/downloads/tools) or ?path=; the response is a plain array with the
+// number of entries in X-Total-Count.
+func (s *ModelServer) handleDownloadsAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query, err := parseDownloadQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if rel := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/downloads"), "/"); rel != "" {
+		query.Path = rel
+	}
+
+	_, info, err := s.downloads.resolve(query.Path)
+	if err == nil && !info.IsDir() {
+		err = fs.ErrNotExist
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("folder %q not found", query.Path))
+		return
+	}
+	if err != nil {
+		requestLog(r).Error("Could not resolve downloads folder", "path", query.Path, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not read downloads directory")
+		return
+	}
+
+	var entries []downloadEntry
+	if query.Recursive {
+		entries, err = s.downloads.listTree(query.Path)
+	} else {
+		entries, err = s.downloads.list(query.Path)
+	}
+	if err != nil {
+		requestLog(r).Error("Could not list downloads", "path", query.Path, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "could not read downloads directory")
+		return
+	}
+
+	matched := []downloadEntry{}
+	for _, e := range entries {
+		if query.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+	query.sort(matched)
+
+	infos := make([]downloadInfo, 0, len(matched))
+	pending := false
+	for _, e := range matched {
+		info := downloadInfo{
+			Name:        e.Name,
+			Path:        e.Path,
+			Type:        "dir",
+			Modified:    e.ModTime,
+			DownloadURL: downloadURL(e.Path, e.Dir),
+			ExpiresAt:   e.Expires,
+		}
+		if !e.Dir {
+			info.Type = "file"
+			info.Size = e.Size
+			info.ContentType = mime.TypeByExtension(path.Ext(e.Name))
+			if info.ContentType == "" {
+				info.ContentType = "application/octet-stream"
+			}
+			// Hashing a large tree here would hold up the response for
+			// minutes, so only cached checksums are listed
+			if fi, err := os.Stat(e.real); err == nil {
+				info.SHA256 = s.downloads.cachedChecksum(e.real, fi)
+			}
+			if info.SHA256 == "" {
+				info.SHA256Pending = true
+				pending = true
+			}
+		}
+		infos = append(infos, info)
+	}
+	if pending {
+		go s.downloads.refreshChecksums()
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(infos)))
+	writeJSON(w, http.StatusOK, infos)
+
+	requestLog(r).Info("Listed downloads", "path", query.Path, "recursive", query.Recursive, "count", len(infos))
+}
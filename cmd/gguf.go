@@ -0,0 +1,504 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	ggufMagic            = "GGUF"
+	ggufDefaultAlignment = 32
+	maxGGUFStringLength  = 16 << 20 // chat templates are the longest strings in practice
+	maxGGUFCount         = 1 << 24  // sanity limit for array lengths and tensor/key counts
+	maxGGUFDimensions    = 8
+	maxGGUFArrayDepth    = 4 // arrays of arrays; real files nest at most once
+)
+
+var (
+	errNotGGUF       = errors.New("not a GGUF file")
+	errGGUFTruncated = errors.New("GGUF header is truncated")
+)
+
+// GGUF metadata value types.
+const (
+	ggufTypeUint8 uint32 = iota
+	ggufTypeInt8
+	ggufTypeUint16
+	ggufTypeInt16
+	ggufTypeUint32
+	ggufTypeInt32
+	ggufTypeFloat32
+	ggufTypeBool
+	ggufTypeString
+	ggufTypeArray
+	ggufTypeUint64
+	ggufTypeInt64
+	ggufTypeFloat64
+)
+
+var ggufTypeNames = map[uint32]string{
+	ggufTypeUint8: "uint8", ggufTypeInt8: "int8", ggufTypeUint16: "uint16", ggufTypeInt16: "int16",
+	ggufTypeUint32: "uint32", ggufTypeInt32: "int32", ggufTypeFloat32: "float32", ggufTypeBool: "bool",
+	ggufTypeString: "string", ggufTypeArray: "array", ggufTypeUint64: "uint64", ggufTypeInt64: "int64",
+	ggufTypeFloat64: "float64",
+}
+
+// ggmlTypeNames maps tensor data types to their llama.cpp names.
+var ggmlTypeNames = map[uint32]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 6: "Q5_0", 7: "Q5_1", 8: "Q8_0", 9: "Q8_1",
+	10: "Q2_K", 11: "Q3_K", 12: "Q4_K", 13: "Q5_K", 14: "Q6_K", 15: "Q8_K",
+	16: "IQ2_XXS", 17: "IQ2_XS", 18: "IQ3_XXS", 19: "IQ1_S", 20: "IQ4_NL", 21: "IQ3_S",
+	22: "IQ2_S", 23: "IQ4_XS", 24: "I8", 25: "I16", 26: "I32", 27: "I64", 28: "F64",
+	29: "IQ1_M", 30: "BF16", 34: "TQ1_0", 35: "TQ2_0",
+}
+
+// ggufFileTypeNames maps general.file_type to the quantization name Ollama shows.
+var ggufFileTypeNames = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0", 37: "TQ2_0",
+}
+
+// GGUFArray summarises an array value; token lists run to hundreds of
+// thousands of entries, so only their type and length are kept.
+type GGUFArray struct {
+	Type   string `json:"type"`
+	Length uint64 `json:"length"`
+}
+
+// GGUFTensorType is the share of a model's tensors stored in one data type.
+type GGUFTensorType struct {
+	Tensors    int    `json:"tensors"`
+	Parameters uint64 `json:"parameters"`
+	Bytes      int64  `json:"bytes"`
+}
+
+// GGUFInfo is what the server reports from a GGUF header.
+type GGUFInfo struct {
+	Version         uint32                    `json:"version"`
+	Architecture    string                    `json:"architecture,omitempty"`
+	Name            string                    `json:"name,omitempty"`
+	FileType        string                    `json:"file_type,omitempty"`
+	ContextLength   uint64                    `json:"context_length,omitempty"`
+	EmbeddingLength uint64                    `json:"embedding_length,omitempty"`
+	BlockCount      uint64                    `json:"block_count,omitempty"`
+	HeadCount       uint64                    `json:"head_count,omitempty"`
+	HeadCountKV     uint64                    `json:"head_count_kv,omitempty"`
+	Tokenizer       string                    `json:"tokenizer,omitempty"`
+	VocabSize       uint64                    `json:"vocab_size,omitempty"`
+	ParameterCount  uint64                    `json:"parameter_count"`
+	TensorCount     uint64                    `json:"tensor_count"`
+	TensorTypes     map[string]GGUFTensorType `json:"tensor_types"`
+	HeaderSize      int64                     `json:"header_size"`
+	Metadata        map[string]interface{}    `json:"metadata"`
+}
+
+// ggufReader reads little-endian GGUF values and counts the bytes consumed.
+type ggufReader struct {
+	r       *bufio.Reader
+	version uint32
+	offset  int64
+	depth   int // arrays currently being read, to bound nesting
+}
+
+func (g *ggufReader) read(v interface{}) error {
+	if err := binary.Read(g.r, binary.LittleEndian, v); err != nil {
+		return ggufReadError(err)
+	}
+	g.offset += int64(binary.Size(v))
+	return nil
+}
+
+func (g *ggufReader) skip(n int64) error {
+	skipped, err := g.r.Discard(int(n))
+	g.offset += int64(skipped)
+	return ggufReadError(err)
+}
+
+// count reads a length or count, which is 32-bit in GGUF v1 and 64-bit later.
+func (g *ggufReader) count() (uint64, error) {
+	if g.version == 1 {
+		var n uint32
+		err := g.read(&n)
+		return uint64(n), err
+	}
+	var n uint64
+	err := g.read(&n)
+	return n, err
+}
+
+func (g *ggufReader) string() (string, error) {
+	n, err := g.count()
+	if err != nil {
+		return "", err
+	}
+	if n > maxGGUFStringLength {
+		return "", fmt.Errorf("GGUF string of %d bytes exceeds limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(g.r, buf); err != nil {
+		return "", ggufReadError(err)
+	}
+	g.offset += int64(n)
+	return string(buf), nil
+}
+
+func ggufReadError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errGGUFTruncated
+	}
+	return err
+}
+
+// ggufScalarSize returns the encoded size of fixed-width value types.
+func ggufScalarSize(t uint32) int64 {
+	switch t {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		return 1
+	case ggufTypeUint16, ggufTypeInt16:
+		return 2
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		return 4
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		return 8
+	}
+	return 0
+}
+
+func (g *ggufReader) value(t uint32) (interface{}, error) {
+	switch t {
+	case ggufTypeUint8:
+		var v uint8
+		return v, g.read(&v)
+	case ggufTypeInt8:
+		var v int8
+		return v, g.read(&v)
+	case ggufTypeUint16:
+		var v uint16
+		return v, g.read(&v)
+	case ggufTypeInt16:
+		var v int16
+		return v, g.read(&v)
+	case ggufTypeUint32:
+		var v uint32
+		return v, g.read(&v)
+	case ggufTypeInt32:
+		var v int32
+		return v, g.read(&v)
+	case ggufTypeFloat32:
+		var v float32
+		return v, g.read(&v)
+	case ggufTypeBool:
+		var v uint8
+		err := g.read(&v)
+		return v != 0, err
+	case ggufTypeString:
+		return g.string()
+	case ggufTypeUint64:
+		var v uint64
+		return v, g.read(&v)
+	case ggufTypeInt64:
+		var v int64
+		return v, g.read(&v)
+	case ggufTypeFloat64:
+		var v float64
+		return v, g.read(&v)
+	case ggufTypeArray:
+		return g.array()
+	}
+	return nil, fmt.Errorf("unknown GGUF value type %d", t)
+}
+
+// array skips over an array's elements and returns its summary.
+func (g *ggufReader) array() (GGUFArray, error) {
+	var elemType uint32
+	if err := g.read(&elemType); err != nil {
+		return GGUFArray{}, err
+	}
+	n, err := g.count()
+	if err != nil {
+		return GGUFArray{}, err
+	}
+	if n > maxGGUFCount {
+		return GGUFArray{}, fmt.Errorf("GGUF array of %d elements exceeds limit", n)
+	}
+	summary := GGUFArray{Type: ggufTypeNames[elemType], Length: n}
+
+	if elemType == ggufTypeArray {
+		if g.depth >= maxGGUFArrayDepth {
+			return summary, fmt.Errorf("GGUF arrays nested deeper than %d levels", maxGGUFArrayDepth)
+		}
+		g.depth++
+		defer func() { g.depth-- }()
+	}
+	if size := ggufScalarSize(elemType); size > 0 {
+		return summary, g.skip(size * int64(n))
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := g.value(elemType); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
+
+// ggufUint converts any integer metadata value to uint64.
+func ggufUint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint8:
+		return uint64(n), true
+	case uint16:
+		return uint64(n), true
+	case uint32:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	case int8:
+		return uint64(n), n >= 0
+	case int16:
+		return uint64(n), n >= 0
+	case int32:
+		return uint64(n), n >= 0
+	case int64:
+		return uint64(n), n >= 0
+	}
+	return 0, false
+}
+
+type ggufTensor struct {
+	dtype    uint32
+	elements uint64
+	offset   uint64
+}
+
+// parseGGUF reads the header, metadata and tensor infos of a GGUF file of
+// fileSize bytes. Tensor data is never read.
+func parseGGUF(r io.Reader, fileSize int64) (*GGUFInfo, error) {
+	g := &ggufReader{r: bufio.NewReaderSize(r, 1<<16)}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(g.r, magic); err != nil || string(magic) != ggufMagic {
+		return nil, errNotGGUF
+	}
+	g.offset = 4
+	if err := g.read(&g.version); err != nil {
+		return nil, err
+	}
+	if g.version < 1 || g.version > 3 {
+		// A byte-swapped version means a big-endian file, which Ollama doesn't use
+		return nil, fmt.Errorf("unsupported GGUF version %d", g.version)
+	}
+
+	tensorCount, err := g.count()
+	if err != nil {
+		return nil, err
+	}
+	kvCount, err := g.count()
+	if err != nil {
+		return nil, err
+	}
+	if tensorCount > maxGGUFCount || kvCount > maxGGUFCount {
+		return nil, fmt.Errorf("implausible GGUF header (%d tensors, %d keys)", tensorCount, kvCount)
+	}
+
+	info := &GGUFInfo{
+		Version:     g.version,
+		TensorCount: tensorCount,
+		TensorTypes: make(map[string]GGUFTensorType),
+		Metadata:    make(map[string]interface{}),
+	}
+	for i := uint64(0); i < kvCount; i++ {
+		key, err := g.string()
+		if err != nil {
+			return nil, err
+		}
+		var valueType uint32
+		if err := g.read(&valueType); err != nil {
+			return nil, err
+		}
+		value, err := g.value(valueType)
+		if err != nil {
+			return nil, err
+		}
+		info.Metadata[key] = value
+	}
+
+	tensors := make([]ggufTensor, 0, tensorCount)
+	for i := uint64(0); i < tensorCount; i++ {
+		if _, err := g.string(); err != nil {
+			return nil, err
+		}
+		var dims uint32
+		if err := g.read(&dims); err != nil {
+			return nil, err
+		}
+		if dims > maxGGUFDimensions {
+			return nil, fmt.Errorf("tensor with %d dimensions", dims)
+		}
+		t := ggufTensor{elements: 1}
+		for d := uint32(0); d < dims; d++ {
+			n, err := g.count()
+			if err != nil {
+				return nil, err
+			}
+			t.elements *= n
+		}
+		if err := g.read(&t.dtype); err != nil {
+			return nil, err
+		}
+		if err := g.read(&t.offset); err != nil {
+			return nil, err
+		}
+		tensors = append(tensors, t)
+	}
+	info.HeaderSize = g.offset
+
+	info.summarize(tensors, fileSize)
+	return info, nil
+}
+
+// summarize fills the well-known fields from the metadata and totals tensors
+// by data type. Tensor byte sizes come from the gaps between data offsets.
+func (info *GGUFInfo) summarize(tensors []ggufTensor, fileSize int64) {
+	str := func(key string) string {
+		s, _ := info.Metadata[key].(string)
+		return s
+	}
+	num := func(key string) uint64 {
+		n, _ := ggufUint(info.Metadata[key])
+		return n
+	}
+
+	arch := str("general.architecture")
+	info.Architecture = arch
+	info.Name = str("general.name")
+	if ft, ok := ggufUint(info.Metadata["general.file_type"]); ok {
+		info.FileType = ggufFileTypeNames[ft]
+	}
+	info.ContextLength = num(arch + ".context_length")
+	info.EmbeddingLength = num(arch + ".embedding_length")
+	info.BlockCount = num(arch + ".block_count")
+	info.HeadCount = num(arch + ".attention.head_count")
+	info.HeadCountKV = num(arch + ".attention.head_count_kv")
+	info.Tokenizer = str("tokenizer.ggml.model")
+	if tokens, ok := info.Metadata["tokenizer.ggml.tokens"].(GGUFArray); ok {
+		info.VocabSize = tokens.Length
+	}
+
+	alignment := num("general.alignment")
+	if alignment == 0 {
+		alignment = ggufDefaultAlignment
+	}
+	dataStart := (uint64(info.HeaderSize) + alignment - 1) / alignment * alignment
+	dataSize := int64(0)
+	if fileSize > int64(dataStart) {
+		dataSize = fileSize - int64(dataStart)
+	}
+
+	sorted := make([]ggufTensor, len(tensors))
+	copy(sorted, tensors)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].offset < sorted[j].offset })
+	for i, t := range sorted {
+		end := dataSize
+		if i+1 < len(sorted) {
+			end = int64(sorted[i+1].offset)
+		}
+		name, ok := ggmlTypeNames[t.dtype]
+		if !ok {
+			name = fmt.Sprintf("type_%d", t.dtype)
+		}
+		summary := info.TensorTypes[name]
+		summary.Tensors++
+		summary.Parameters += t.elements
+		if size := end - int64(t.offset); size > 0 {
+			summary.Bytes += size
+		}
+		info.TensorTypes[name] = summary
+		info.ParameterCount += t.elements
+	}
+
+	// Drop long strings (chat templates) and NaN floats so the metadata
+	// stays small and JSON-encodable; the template is served with the model.
+	for key, value := range info.Metadata {
+		switch v := value.(type) {
+		case string:
+			if len(v) > 1024 || strings.HasPrefix(key, "tokenizer.chat_template") {
+				delete(info.Metadata, key)
+			}
+		case float32:
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				delete(info.Metadata, key)
+			}
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				delete(info.Metadata, key)
+			}
+		}
+	}
+}
+
+// GGUFCache remembers parsed headers by blob digest. Blobs are content
+// addressed, but the file size and mtime are checked too so a blob that was
+// still being written when it was first inspected is parsed again.
+type GGUFCache struct {
+	mu      sync.Mutex
+	entries map[string]ggufCacheEntry
+}
+
+type ggufCacheEntry struct {
+	size    int64
+	modTime time.Time
+	info    *GGUFInfo
+	err     error
+}
+
+func newGGUFCache() *GGUFCache {
+	return &GGUFCache{entries: make(map[string]ggufCacheEntry)}
+}
+
+// Inspect returns the parsed header of a blob, reading it only on a cache miss.
+func (c *GGUFCache) Inspect(ctx context.Context, modelsDir, digest string) (info *GGUFInfo, err error) {
+	_, span := startSpan(ctx, "gguf.inspect", attribute.String(logDigest, digest))
+	defer func() { endSpan(span, err) }()
+
+	path := blobPath(modelsDir, digest)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[digest]
+	c.mu.Unlock()
+	cached := ok && entry.size == stat.Size() && entry.modTime.Equal(stat.ModTime())
+	span.SetAttributes(attribute.Bool("cached", cached))
+	if cached {
+		return entry.info, entry.err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err = parseGGUF(f, stat.Size())
+
+	c.mu.Lock()
+	c.entries[digest] = ggufCacheEntry{size: stat.Size(), modTime: stat.ModTime(), info: info, err: err}
+	c.mu.Unlock()
+	return info, err
+}
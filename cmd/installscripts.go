@@ -0,0 +1,316 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/jjasghar/ollama-lancache/scripts"
+)
+
+// installScriptConfig configures how /install.sh and /install.ps1 are
+// rendered.
+type installScriptConfig struct {
+	Dir            string `mapstructure:"dir"`           // install.sh and install.ps1 here replace the built-in scripts
+	PublicURL      string `mapstructure:"-"`             // serve.public-url: URL clients reach the server at (default: taken from the request)
+	CACert         string `mapstructure:"ca-cert"`       // PEM file of the CA clients should trust for an https PublicURL
+	DefaultModel   string `mapstructure:"default-model"` // installed when the script is run without --model or --profile
+	DefaultProfile string `mapstructure:"default-profile"`
+}
+
+// InstallScripts renders the client install scripts. The scripts are
+// text/template files, built in from the scripts package or read from an
+// override directory on every request so they can be edited without a
+// restart.
+type InstallScripts struct {
+	dir            string
+	publicURL      string
+	caPEM          []byte
+	caFingerprint  string
+	defaultModel   string
+	defaultProfile string
+}
+
+// installScriptData is what the script templates are rendered with.
+type installScriptData struct {
+	ServerURL      string
+	CAFingerprint  string // SHA-256 of the CA certificate (DER), lowercase hex; empty without a CA
+	Token          string // bearer token the script sends, from ?token=
+	DefaultModel   string
+	DefaultProfile string
+	Models         []string // published models
+}
+
+var installScriptFuncs = template.FuncMap{
+	"sh":      shellQuote,
+	"shArray": shellArray,
+	"ps":      powershellQuote,
+	"psArray": powershellArray,
+}
+
+func newInstallScripts(cfg installScriptConfig, profiles *Profiles) (*InstallScripts, error) {
+	s := &InstallScripts{dir: cfg.Dir}
+
+	if cfg.Dir != "" {
+		info, err := os.Stat(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("script directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("script directory %s is not a directory", cfg.Dir)
+		}
+	}
+
+	if cfg.PublicURL != "" {
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid public URL %q (use http://host:port or https://host)", cfg.PublicURL)
+		}
+		s.publicURL = strings.TrimRight(cfg.PublicURL, "/")
+	}
+
+	if cfg.CACert != "" {
+		data, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s does not start with a PEM certificate", cfg.CACert)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid CA certificate %s: %w", cfg.CACert, err)
+		}
+		sum := sha256.Sum256(block.Bytes)
+		s.caPEM = pem.EncodeToMemory(block)
+		s.caFingerprint = hex.EncodeToString(sum[:])
+	}
+
+	if cfg.DefaultModel != "" && cfg.DefaultProfile != "" {
+		return nil, fmt.Errorf("set either a default model or a default profile for the install scripts, not both")
+	}
+	if cfg.DefaultModel != "" {
+		ref, err := parseModelRef(cfg.DefaultModel)
+		if err != nil {
+			return nil, fmt.Errorf("install script default model: %w", err)
+		}
+		s.defaultModel = ref.String()
+	}
+	if cfg.DefaultProfile != "" {
+		profile, ok := profiles.Get(cfg.DefaultProfile)
+		if !ok {
+			return nil, fmt.Errorf("install script default profile %q is not defined in serve.profiles", cfg.DefaultProfile)
+		}
+		s.defaultProfile = profile.Name
+	}
+
+	// Catch broken templates at startup rather than on the first download
+	for _, name := range []string{"install.sh", "install.ps1"} {
+		if _, err := s.template(name); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// template loads and parses a script, preferring the override directory.
+func (s *InstallScripts) template(name string) (*template.Template, error) {
+	var text []byte
+	var err error
+	if s.dir != "" {
+		text, err = os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+	if text == nil {
+		if text, err = fs.ReadFile(scripts.FS, name); err != nil {
+			return nil, fmt.Errorf("failed to read built-in %s: %w", name, err)
+		}
+	}
+	tmpl, err := template.New(name).Funcs(installScriptFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("invalid script template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// serverURL is the base URL the scripts talk to.
+func (s *InstallScripts) serverURL(r *http.Request, proxies TrustedProxies, header string) string {
+	if s.publicURL != "" {
+		return s.publicURL
+	}
+	return proxies.Scheme(r, header) + "://" + r.Host
+}
+
+// handleInstallScript renders /install.sh or /install.ps1 for the caller.
+// ?model= or ?profile= make the script install that by default, and
+// ?token= bakes in a bearer token, so a single URL can be handed out:
+//
+//	curl -fsSL "http://server:8080/install.sh?profile=support" | bash
+//
+// Without them the configured default applies, then the caller's default
+// profile.
+func (s *ModelServer) handleInstallScript(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	script, data, ok := s.renderInstallScript(w, r, name)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	// Rendered per client and possibly carrying a token
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(script)
+
+	requestLog(r).Info("Install script downloaded", "script", name, "default_model", data.DefaultModel, "default_profile", data.DefaultProfile)
+}
+
+// handleInstallScriptSignature serves /install.sh.sig and /install.ps1.sig,
+// the signature of the plain script. Only that rendering is signed, and
+// only with serve.public-url set: otherwise the server URL inside the
+// script would come from the request's Host header, and query parameters
+// would let the caller choose what else goes into signed output.
+func (s *ModelServer) handleInstallScriptSignature(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		http.Error(w, errSigningDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	if s.installScripts.publicURL == "" {
+		http.Error(w, "install scripts are only signed when the server has a public URL (--public-url)", http.StatusNotFound)
+		return
+	}
+	if r.URL.RawQuery != "" {
+		http.Error(w, "only the install script without query parameters is signed; pass options to the script instead", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSuffix(path.Base(r.URL.Path), ".sig")
+	script, _, ok := s.renderInstallScript(w, r, name)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	s.signer.writeSignature(w, signContextScript, script)
+}
+
+// renderInstallScript renders a script for the request, writing an error
+// response when that fails.
+func (s *ModelServer) renderInstallScript(w http.ResponseWriter, r *http.Request, name string) ([]byte, installScriptData, bool) {
+	tmpl, err := s.installScripts.template(name)
+	if err != nil {
+		requestLog(r).Error("Could not load install script", "script", name, "error", err)
+		http.Error(w, "Install script unavailable", http.StatusInternalServerError)
+		return nil, installScriptData{}, false
+	}
+
+	query := r.URL.Query()
+	data := installScriptData{
+		ServerURL:     s.installScripts.serverURL(r, s.trustedProxies, s.trustedHeader),
+		CAFingerprint: s.installScripts.caFingerprint,
+		Token:         query.Get("token"),
+		Models:        []string{},
+	}
+	switch {
+	case query.Get("model") != "" || query.Get("profile") != "":
+		if v := query.Get("model"); v != "" {
+			ref, err := parseModelRef(v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return nil, data, false
+			}
+			data.DefaultModel = ref.String()
+		}
+		if v := query.Get("profile"); v != "" {
+			profile, ok := s.profiles.Get(v)
+			if !ok {
+				http.Error(w, fmt.Sprintf("profile %q not found", v), http.StatusBadRequest)
+				return nil, data, false
+			}
+			data.DefaultProfile = profile.Name
+		}
+	case s.installScripts.defaultModel != "" || s.installScripts.defaultProfile != "":
+		data.DefaultModel = s.installScripts.defaultModel
+		data.DefaultProfile = s.installScripts.defaultProfile
+	default:
+		client, _ := parseHostAddr(getClientIP(r))
+		if profile, ok := s.profiles.Default(client); ok {
+			data.DefaultProfile = profile.Name
+		}
+	}
+	if models, _, err := s.getCatalog(r.Context()); err == nil {
+		for _, m := range models {
+			data.Models = append(data.Models, m.Name+":"+m.Tag)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		requestLog(r).Error("Could not render install script", "script", name, "error", err)
+		http.Error(w, "Install script unavailable", http.StatusInternalServerError)
+		return nil, data, false
+	}
+	return buf.Bytes(), data, true
+}
+
+// handleCACert serves the CA certificate pinned in the install scripts.
+func (s *ModelServer) handleCACert(w http.ResponseWriter, r *http.Request) {
+	if s.installScripts.caPEM == nil {
+		http.Error(w, "No CA certificate configured", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("X-Lancache-CA-Fingerprint", "sha256:"+s.installScripts.caFingerprint)
+	w.Write(s.installScripts.caPEM)
+}
+
+// shellQuote quotes s as a single bash word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellArray renders a bash array literal.
+func shellArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = shellQuote(v)
+	}
+	return "(" + strings.Join(quoted, " ") + ")"
+}
+
+// powershellQuote quotes s as a PowerShell verbatim string. PowerShell also
+// treats the typographic single quotes as quote characters, so they are
+// doubled too.
+func powershellQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+	for _, r := range s {
+		b.WriteRune(r)
+		switch r {
+		case '\'', '‘', '’', '‚', '‛':
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// powershellArray renders a PowerShell array literal.
+func powershellArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = powershellQuote(v)
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
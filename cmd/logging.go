@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log field names shared by every event, so log shippers can index them.
+const (
+	logClient    = "client"
+	logModel     = "model"
+	logDigest    = "digest"
+	logBytes     = "bytes"
+	logDuration  = "duration"
+	logRequestID = "request_id"
+	logTraceID   = "trace_id"
+)
+
+// setupLogging installs the default slog logger. The standard log package is
+// routed through it too, at info level.
+func setupLogging(w io.Writer, level, format string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	if err := checkLogFormat(format); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		// Durations as seconds rather than nanoseconds
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindDuration {
+				return slog.Float64(a.Key, a.Value.Duration().Seconds())
+			}
+			return a
+		}
+		handler = slog.NewJSONHandler(w, opts)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q (use debug, info, warn or error)", level)
+}
+
+func checkLogFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "", "text", "json":
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q (use text or json)", format)
+}
+
+// fatal logs an error and exits, replacing log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestLogKey struct{}
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestLog gives every request an ID (reusing a sane incoming
+// X-Request-ID) and a logger carrying it, the client address and, when the
+// request is traced, the trace ID.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		clientIP := getClientIP(r)
+		logger := slog.Default().With(logRequestID, id, logClient, clientIP)
+		if span := trace.SpanFromContext(r.Context()); span.SpanContext().IsValid() {
+			span.SetAttributes(attribute.String(logRequestID, id), attribute.String(logClient, clientIP))
+			logger = logger.With(logTraceID, span.SpanContext().TraceID().String())
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, logger)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLog returns the logger for a request.
+func requestLog(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(requestLogKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default().With(logClient, getClientIP(r))
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	mediaTypeManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// Layer is a blob reference inside a manifest.
+type Layer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// Manifest is an Ollama (Docker v2 schema 2) image manifest.
+type Manifest struct {
+	SchemaVersion int     `json:"schemaVersion"`
+	MediaType     string  `json:"mediaType"`
+	Config        Layer   `json:"config"`
+	Layers        []Layer `json:"layers"`
+}
+
+// Blobs returns the config blob followed by every layer.
+func (m *Manifest) Blobs() []Layer {
+	blobs := make([]Layer, 0, len(m.Layers)+1)
+	if m.Config.Digest != "" {
+		blobs = append(blobs, m.Config)
+	}
+	return append(blobs, m.Layers...)
+}
+
+func readManifest(ctx context.Context, path string) (m *Manifest, err error) {
+	_, span := startSpan(ctx, "manifest.parse", attribute.String("path", path))
+	defer func() { endSpan(span, err) }()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if m, err = parseManifest(data); err == nil {
+		span.SetAttributes(attribute.Int("layers", len(m.Layers)))
+	}
+	return m, err
+}
+
+func parseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.SchemaVersion != 2 {
+		return nil, fmt.Errorf("unsupported manifest schema version %d", m.SchemaVersion)
+	}
+	for _, layer := range m.Blobs() {
+		if !validDigest(layer.Digest) {
+			return nil, fmt.Errorf("invalid digest in manifest: %q", layer.Digest)
+		}
+	}
+	return &m, nil
+}
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// validDigest reports whether d is a well-formed "sha256:<hex>" digest.
+func validDigest(d string) bool {
+	return digestPattern.MatchString(d)
+}
+
+// blobPath returns where a digest is stored in a models directory. Ollama
+// stores blobs as sha256-<hex> because colons are not allowed on Windows.
+func blobPath(modelsDir, digest string) string {
+	return filepath.Join(modelsDir, "blobs", strings.ReplaceAll(digest, ":", "-"))
+}
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	mediaTypeModel     = mediaTypeOllamaPrefix + "model"
+	mediaTypeTemplate  = mediaTypeOllamaPrefix + "template"
+	mediaTypeSystem    = mediaTypeOllamaPrefix + "system"
+	mediaTypeParams    = mediaTypeOllamaPrefix + "params"
+	mediaTypeLicense   = mediaTypeOllamaPrefix + "license"
+	mediaTypeMessages  = mediaTypeOllamaPrefix + "messages"
+	mediaTypeAdapter   = mediaTypeOllamaPrefix + "adapter"
+	mediaTypeProjector = mediaTypeOllamaPrefix + "projector"
+
+	maxTextLayerSize = 1 << 20
+)
+
+// DetailLayer is one layer of a model with the published models sharing it.
+type DetailLayer struct {
+	Digest     string   `json:"digest"`
+	MediaType  string   `json:"media_type"`
+	Type       string   `json:"type"`
+	Size       int64    `json:"size"`
+	SharedWith []string `json:"shared_with"`
+}
+
+// ModelMessage is an entry of a messages layer (MESSAGE in a Modelfile).
+type ModelMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ModelDetail is the /api/models/{ref} response.
+type ModelDetail struct {
+	ModelInfo
+	Layers     []DetailLayer          `json:"layers"`
+	Template   string                 `json:"template,omitempty"`
+	System     string                 `json:"system,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	License    []string               `json:"license,omitempty"`
+	Messages   []ModelMessage         `json:"messages,omitempty"`
+	GGUF       *GGUFInfo              `json:"gguf,omitempty"`
+	GGUFError  string                 `json:"gguf_error,omitempty"`
+
+	target ModelRef
+	layers []Layer
+}
+
+// handleModelDetail serves /api/models/{ref} and /api/models/{ref}/modelfile
+// for published models and aliases.
+func (s *ModelServer) handleModelDetail(w http.ResponseWriter, r *http.Request) {
+	refStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/models/"), "/")
+	refStr, wantModelfile := strings.CutSuffix(refStr, "/modelfile")
+	ref, err := parseModelRef(refStr)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	detail, status, err := s.modelDetail(r.Context(), ref)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+
+	if wantModelfile {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, detail.Modelfile())
+		requestLog(r).Info("Modelfile requested", logModel, ref.String())
+		return
+	}
+
+	s.inspectWeights(r.Context(), detail)
+	writeJSON(w, http.StatusOK, detail)
+	requestLog(r).Info("Model detail requested", logModel, ref.String())
+}
+
+// modelDetail collects a published model's layers and the contents of its
+// text layers. On failure it returns the HTTP status to report.
+func (s *ModelServer) modelDetail(ctx context.Context, ref ModelRef) (*ModelDetail, int, error) {
+	models, idx, err := s.getCatalog(ctx)
+	if err != nil && models == nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to list models")
+	}
+	detail := &ModelDetail{}
+	found := false
+	for _, m := range models {
+		if m.Name == ref.Name() && m.Tag == ref.Tag {
+			detail.ModelInfo = m
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, http.StatusNotFound, fmt.Errorf("model not found")
+	}
+
+	detail.target, _ = s.resolveRef(ref)
+	manifest, err := readManifest(ctx, detail.target.manifestPath(s.modelsDir))
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("failed to read manifest")
+	}
+	detail.layers = manifest.Layers
+
+	detail.Layers = []DetailLayer{}
+	for _, layer := range manifest.Layers {
+		detail.Layers = append(detail.Layers, DetailLayer{
+			Digest:     layer.Digest,
+			MediaType:  layer.MediaType,
+			Type:       layerKind(layer.MediaType),
+			Size:       layer.Size,
+			SharedWith: s.sharedWith(idx, layer.Digest, detail.target.String()),
+		})
+
+		switch layer.MediaType {
+		case mediaTypeTemplate, mediaTypeSystem, mediaTypeLicense, mediaTypeParams, mediaTypeMessages:
+		default:
+			continue
+		}
+		text, err := s.readTextLayer(layer)
+		if err != nil {
+			slog.Warn("Cannot read layer", logModel, ref.String(), logDigest, layer.Digest, "type", layerKind(layer.MediaType), "error", err)
+			continue
+		}
+		switch layer.MediaType {
+		case mediaTypeTemplate:
+			detail.Template = text
+		case mediaTypeSystem:
+			detail.System = text
+		case mediaTypeLicense:
+			detail.License = append(detail.License, text)
+		case mediaTypeParams:
+			if err := json.Unmarshal([]byte(text), &detail.Parameters); err != nil {
+				slog.Warn("Invalid params layer", logModel, ref.String(), logDigest, layer.Digest, "error", err)
+			}
+		case mediaTypeMessages:
+			if err := json.Unmarshal([]byte(text), &detail.Messages); err != nil {
+				slog.Warn("Invalid messages layer", logModel, ref.String(), logDigest, layer.Digest, "error", err)
+			}
+		}
+	}
+	return detail, http.StatusOK, nil
+}
+
+// inspectWeights fills in the GGUF header of the model's weights layer.
+func (s *ModelServer) inspectWeights(ctx context.Context, detail *ModelDetail) {
+	for _, layer := range detail.layers {
+		if layer.MediaType != mediaTypeModel {
+			continue
+		}
+		info, err := s.gguf.Inspect(ctx, s.modelsDir, layer.Digest)
+		if err != nil {
+			detail.GGUFError = err.Error()
+			slog.Warn("Cannot inspect weights", logModel, detail.target.String(), logDigest, layer.Digest, "error", err)
+		} else {
+			detail.GGUF = info
+		}
+		return
+	}
+	detail.GGUFError = "model has no weights layer"
+}
+
+// sharedWith lists the other published models that use a blob.
+func (s *ModelServer) sharedWith(idx *BlobIndex, digest, self string) []string {
+	shared := []string{}
+	usage, ok := idx.Blob(digest)
+	if !ok {
+		return shared
+	}
+	for _, ref := range usage.ReferencedBy {
+		if ref == self {
+			continue
+		}
+		if entry, ok := s.catalog.Get(ref); ok && entry.Status == CatalogApproved {
+			shared = append(shared, ref)
+		}
+	}
+	return shared
+}
+
+func (s *ModelServer) readTextLayer(layer Layer) (string, error) {
+	if layer.Size > maxTextLayerSize {
+		return "", fmt.Errorf("layer too large (%d bytes)", layer.Size)
+	}
+	data, err := os.ReadFile(blobPath(s.modelsDir, layer.Digest))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// clientBlobDir is where the blobs of a Modelfile are expected, the default
+// Ollama models directory; `ollama create` expands the ~.
+const clientBlobDir = "~/.ollama/models/blobs/"
+
+// Modelfile reconstructs a Modelfile equivalent to the model's layers, in
+// the layout of `ollama show --modelfile`: weights and adapters are referred
+// to by blob path, as they are found once the model has been pulled, with the
+// model name in a comment for building a new model on top of it.
+func (d *ModelDetail) Modelfile() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Modelfile generated by ollama-lancache\n")
+	fmt.Fprintf(&b, "# To build a new Modelfile based on this one, replace FROM with:\n")
+	fmt.Fprintf(&b, "# FROM %s:%s\n\n", d.Name, d.Tag)
+	for _, layer := range d.layers {
+		if layer.MediaType == mediaTypeModel {
+			fmt.Fprintf(&b, "FROM %s\n", clientBlobPath(layer.Digest))
+		}
+	}
+	for _, layer := range d.layers {
+		switch layer.MediaType {
+		case mediaTypeAdapter:
+			fmt.Fprintf(&b, "ADAPTER %s\n", clientBlobPath(layer.Digest))
+		case mediaTypeProjector:
+			fmt.Fprintf(&b, "# projector: %s\n", layer.Digest)
+		}
+	}
+	writeModelfileText(&b, "TEMPLATE", d.Template)
+	writeModelfileText(&b, "SYSTEM", d.System)
+
+	for _, param := range d.parameterLines() {
+		fmt.Fprintf(&b, "PARAMETER %s\n", param)
+	}
+
+	for _, msg := range d.Messages {
+		writeModelfileText(&b, "MESSAGE "+msg.Role, msg.Content)
+	}
+	for _, license := range d.License {
+		writeModelfileText(&b, "LICENSE", license)
+	}
+	return b.String()
+}
+
+// clientBlobPath is the path of a blob in the default client models directory.
+func clientBlobPath(digest string) string {
+	return clientBlobDir + strings.ReplaceAll(digest, ":", "-")
+}
+
+// writeModelfileText writes an instruction with a quoted text argument. Text
+// that cannot be quoted is left out with a comment saying so.
+func writeModelfileText(b *strings.Builder, instruction, text string) {
+	if text == "" {
+		return
+	}
+	quoted, ok := modelfileQuote(text)
+	if !ok {
+		fmt.Fprintf(b, "# %s left out: it contains \"\"\" and cannot be quoted in a Modelfile\n", instruction)
+		return
+	}
+	fmt.Fprintf(b, "%s %s\n", instruction, quoted)
+}
+
+// parameterLines formats the parameters as "key value" lines sorted by key,
+// with one line per value of list parameters such as stop.
+func (d *ModelDetail) parameterLines() []string {
+	keys := make([]string, 0, len(d.Parameters))
+	for key := range d.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		values, ok := d.Parameters[key].([]interface{})
+		if !ok {
+			values = []interface{}{d.Parameters[key]}
+		}
+		for _, value := range values {
+			lines = append(lines, key+" "+modelfileValue(value))
+		}
+	}
+	return lines
+}
+
+// modelfileQuote wraps text in triple quotes, as `ollama show --modelfile`
+// does. A triple-quoted value ends at the first """, so text containing one
+// (or ending in a quote) is put in single quotes instead, which Ollama reads
+// to the end of the line. That only works for a single line that does not
+// itself start like a triple quote; ok is false for anything else.
+func modelfileQuote(s string) (quoted string, ok bool) {
+	if strings.Index(s+`"""`, `"""`) == len(s) {
+		return `"""` + s + `"""`, true
+	}
+	if strings.ContainsAny(s, "\r\n") || strings.HasPrefix(s, `""`) {
+		return "", false
+	}
+	return `"` + s + `"`, true
+}
+
+// modelfileValue formats a parameter value; strings are quoted so stop
+// sequences containing spaces survive a round trip.
+func modelfileValue(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return strconv.Quote(value)
+	case float64:
+		return fmt.Sprintf("%v", value)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
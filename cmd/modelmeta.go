@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	mediaTypeOllamaPrefix = "application/vnd.ollama.image."
+	maxConfigSize         = 1 << 20
+)
+
+// ModelConfig is the subset of an Ollama config blob the server reports.
+type ModelConfig struct {
+	ModelFormat   string   `json:"model_format"`
+	ModelFamily   string   `json:"model_family"`
+	ModelFamilies []string `json:"model_families"`
+	ModelType     string   `json:"model_type"` // parameter size, e.g. "8.0B"
+	FileType      string   `json:"file_type"`  // quantization level, e.g. "Q4_K_M"
+}
+
+// ModelDetails is what /api/models reports about a model's weights. Field
+// names follow the "details" object of Ollama's own API.
+type ModelDetails struct {
+	Format            string   `json:"format,omitempty"`
+	Family            string   `json:"family,omitempty"`
+	Families          []string `json:"families,omitempty"`
+	ParameterSize     string   `json:"parameter_size,omitempty"`
+	QuantizationLevel string   `json:"quantization_level,omitempty"`
+}
+
+func readModelConfig(path string) (*ModelConfig, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxConfigSize {
+		return nil, fmt.Errorf("config blob too large (%d bytes)", info.Size())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config ModelConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid config blob: %w", err)
+	}
+	return &config, nil
+}
+
+// Details converts the config blob fields to their API names.
+func (c *ModelConfig) Details() ModelDetails {
+	return ModelDetails{
+		Format:            c.ModelFormat,
+		Family:            c.ModelFamily,
+		Families:          c.ModelFamilies,
+		ParameterSize:     c.ModelType,
+		QuantizationLevel: c.FileType,
+	}
+}
+
+// Size returns the total size of the manifest's layers, excluding the config.
+func (m *Manifest) Size() int64 {
+	var total int64
+	for _, layer := range m.Layers {
+		total += layer.Size
+	}
+	return total
+}
+
+// LayerTypes returns the short kind of each distinct layer media type in
+// manifest order: model, template, system, params, license, projector, ...
+func (m *Manifest) LayerTypes() []string {
+	types := []string{}
+	seen := make(map[string]bool)
+	for _, layer := range m.Layers {
+		kind := layerKind(layer.MediaType)
+		if !seen[kind] {
+			seen[kind] = true
+			types = append(types, kind)
+		}
+	}
+	return types
+}
+
+// layerKind shortens "application/vnd.ollama.image.template" to "template".
+// Media types from other vendors are returned unchanged.
+func layerKind(mediaType string) string {
+	return strings.TrimPrefix(mediaType, mediaTypeOllamaPrefix)
+}
+
+// ModelMetadata is everything reported about a model that is read from its
+// manifest and config blob.
+type ModelMetadata struct {
+	Digest     string // of the manifest itself
+	Size       int64
+	Details    ModelDetails
+	LayerTypes []string
+}
+
+// modelMetadata reads a model's manifest and config blob. A missing or
+// unreadable config blob only leaves Details empty.
+func (s *ModelServer) modelMetadata(ctx context.Context, ref ModelRef) (ModelMetadata, error) {
+	manifest, err := readManifest(ctx, ref.manifestPath(s.modelsDir))
+	if err != nil {
+		return ModelMetadata{LayerTypes: []string{}}, err
+	}
+
+	digest, _ := manifestDigest(ref.manifestPath(s.modelsDir))
+	meta := ModelMetadata{
+		Digest:     digest,
+		Size:       manifest.Size(),
+		LayerTypes: manifest.LayerTypes(),
+	}
+	if manifest.Config.Digest != "" {
+		if config, err := readModelConfig(blobPath(s.modelsDir, manifest.Config.Digest)); err == nil {
+			meta.Details = config.Details()
+		}
+	}
+	return meta, nil
+}
+
+// Summary formats the details for display, e.g. "llama · 8.0B · Q4_K_M · gguf".
+func (d ModelDetails) Summary() string {
+	var parts []string
+	for _, part := range []string{d.Family, d.ParameterSize, d.QuantizationLevel, d.Format} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " · ")
+}
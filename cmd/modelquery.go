@@ -0,0 +1,304 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxModelsPageSize = 500
+
+// ModelQuery filters, sorts and pages the catalog. It is parsed from the
+// query string of /api/models and of the web page's search form.
+type ModelQuery struct {
+	Name          string // substring, or a glob when it contains * ? or [
+	Namespace     string
+	Family        string
+	Quantization  string
+	MinSize       int64
+	MaxSize       int64
+	ModifiedSince time.Time
+	Sort          string // name, size, modified or popularity
+	Desc          bool
+	Limit         int
+	After         *modelCursor
+}
+
+// modelCursor marks the last model of a page. Pages continue after the
+// position it would sort at, so models added or removed between requests
+// don't shift later pages.
+type modelCursor struct {
+	Sort     string `json:"s"`
+	Desc     bool   `json:"d,omitempty"`
+	Name     string `json:"n"`
+	Tag      string `json:"t"`
+	Size     int64  `json:"z,omitempty"`
+	Modified int64  `json:"m,omitempty"` // Unix seconds
+	Pulls    int64  `json:"p,omitempty"`
+}
+
+func parseModelQuery(values url.Values) (*ModelQuery, error) {
+	q := &ModelQuery{
+		Name:         strings.TrimSpace(values.Get("q")),
+		Namespace:    values.Get("namespace"),
+		Family:       values.Get("family"),
+		Quantization: values.Get("quantization"),
+		Sort:         values.Get("sort"),
+	}
+
+	var err error
+	if v := values.Get("min_size"); v != "" {
+		if q.MinSize, err = parseByteSize(v); err != nil {
+			return nil, fmt.Errorf("invalid min_size: %w", err)
+		}
+	}
+	if v := values.Get("max_size"); v != "" {
+		if q.MaxSize, err = parseByteSize(v); err != nil {
+			return nil, fmt.Errorf("invalid max_size: %w", err)
+		}
+	}
+	if v := values.Get("modified_since"); v != "" {
+		if q.ModifiedSince, err = parseSince(v); err != nil {
+			return nil, fmt.Errorf("invalid modified_since: %w", err)
+		}
+	}
+	if strings.ContainsAny(q.Name, "*?[") {
+		if _, err := path.Match(q.Name, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %w", err)
+		}
+	}
+
+	// Names sort A-Z by default; sizes, dates and popularity largest first
+	switch q.Sort {
+	case "":
+		q.Sort = "name"
+	case "name", "size", "modified", "popularity":
+	default:
+		return nil, fmt.Errorf("invalid sort %q (use name, size, modified or popularity)", q.Sort)
+	}
+	switch values.Get("order") {
+	case "":
+		q.Desc = q.Sort != "name"
+	case "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, fmt.Errorf("invalid order %q (use asc or desc)", values.Get("order"))
+	}
+
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return nil, fmt.Errorf("invalid limit %q", v)
+		}
+		if q.Limit > maxModelsPageSize {
+			q.Limit = maxModelsPageSize
+		}
+	}
+	if v := values.Get("cursor"); v != "" {
+		data, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		var c modelCursor
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid cursor")
+		}
+		if c.Sort != q.Sort || c.Desc != q.Desc {
+			return nil, fmt.Errorf("cursor was issued for a different sort order")
+		}
+		q.After = &c
+	}
+	return q, nil
+}
+
+// Apply returns the page of matching models selected by the cursor and
+// limit, how many models matched in total, and the cursor for the next page
+// ("" on the last page).
+func (q *ModelQuery) Apply(models []ModelInfo) ([]ModelInfo, int, string) {
+	matched := []ModelInfo{}
+	for _, m := range models {
+		if q.matches(m) {
+			matched = append(matched, m)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return q.less(cursorFor(q, matched[i]), cursorFor(q, matched[j]))
+	})
+
+	total := len(matched)
+	if q.After != nil {
+		start := sort.Search(len(matched), func(i int) bool {
+			return q.less(*q.After, cursorFor(q, matched[i]))
+		})
+		matched = matched[start:]
+	}
+	if q.Limit == 0 || len(matched) <= q.Limit {
+		return matched, total, ""
+	}
+
+	page := matched[:q.Limit]
+	data, _ := json.Marshal(cursorFor(q, page[len(page)-1]))
+	return page, total, base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q *ModelQuery) matches(m ModelInfo) bool {
+	if q.Name != "" {
+		full := m.Name + ":" + m.Tag
+		if strings.ContainsAny(q.Name, "*?[") {
+			nameMatch, _ := path.Match(q.Name, m.Name)
+			fullMatch, _ := path.Match(q.Name, full)
+			if !nameMatch && !fullMatch {
+				return false
+			}
+		} else if !strings.Contains(strings.ToLower(full), strings.ToLower(q.Name)) {
+			return false
+		}
+	}
+	if q.Namespace != "" {
+		ref, err := parseModelRef(m.Name)
+		if err != nil || !strings.EqualFold(ref.Namespace, q.Namespace) {
+			return false
+		}
+	}
+	if q.Family != "" && !hasFamily(m.Details, q.Family) {
+		return false
+	}
+	if q.Quantization != "" && !strings.EqualFold(m.Details.QuantizationLevel, q.Quantization) {
+		return false
+	}
+	if q.MinSize > 0 && m.Size < q.MinSize {
+		return false
+	}
+	if q.MaxSize > 0 && m.Size > q.MaxSize {
+		return false
+	}
+	if !q.ModifiedSince.IsZero() && m.Modified.Before(q.ModifiedSince) {
+		return false
+	}
+	return true
+}
+
+func hasFamily(d ModelDetails, family string) bool {
+	if strings.EqualFold(d.Family, family) {
+		return true
+	}
+	for _, f := range d.Families {
+		if strings.EqualFold(f, family) {
+			return true
+		}
+	}
+	return false
+}
+
+func cursorFor(q *ModelQuery, m ModelInfo) modelCursor {
+	c := modelCursor{Sort: q.Sort, Desc: q.Desc, Name: m.Name, Tag: m.Tag}
+	switch q.Sort {
+	case "size":
+		c.Size = m.Size
+	case "modified":
+		c.Modified = m.Modified.Unix()
+	case "popularity":
+		c.Pulls = m.Pulls
+	}
+	return c
+}
+
+// less orders by the sort key, then by name and tag so the order is total
+// and cursors are unambiguous.
+func (q *ModelQuery) less(a, b modelCursor) bool {
+	cmp := 0
+	switch q.Sort {
+	case "size":
+		cmp = compareInt64(a.Size, b.Size)
+	case "modified":
+		cmp = compareInt64(a.Modified, b.Modified)
+	case "popularity":
+		cmp = compareInt64(a.Pulls, b.Pulls)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.Name, b.Name)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.Tag, b.Tag)
+	}
+	if q.Desc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// parseByteSize parses a byte count with an optional unit: "500", "512MB",
+// "4.5GB", "2GiB". Decimal and binary units are both treated as powers of 1024,
+// as Ollama and the rest of this server report sizes.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	units := []struct {
+		suffix string
+		mult   float64
+	}{
+		{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+	}
+	mult := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return int64(n * mult), nil
+}
+
+// formatBytes formats a size with binary units, the inverse of parseByteSize.
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size, i := float64(n), 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.2f %s", size, units[i])
+}
+
+// parseSince accepts an RFC 3339 time, a date, or an age such as "7d" or "36h".
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time, date or age", s)
+}
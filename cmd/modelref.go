@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRegistry  = "registry.ollama.ai"
+	defaultNamespace = "library"
+	defaultTag       = "latest"
+)
+
+// ModelRef identifies a model the same way Ollama does: an optional registry
+// host, an optional namespace, the model name and a tag.
+type ModelRef struct {
+	Host      string
+	Namespace string
+	Model     string
+	Tag       string
+}
+
+// parseModelRef parses references such as "llama3", "granite3.3:8b",
+// "team/custom-model:latest" or "host:8080/team/custom-model:v1".
+func parseModelRef(s string) (ModelRef, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return ModelRef{}, fmt.Errorf("empty model reference")
+	}
+
+	ref := ModelRef{Host: defaultRegistry, Namespace: defaultNamespace, Tag: defaultTag}
+
+	name := s
+	// The tag separator is the last colon after the last slash, so a
+	// registry port ("host:8080/...") is not mistaken for a tag.
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		name, ref.Tag = s[:i], s[i+1:]
+	}
+
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		ref.Model = parts[0]
+	case 2:
+		ref.Namespace, ref.Model = parts[0], parts[1]
+	case 3:
+		ref.Host, ref.Namespace, ref.Model = parts[0], parts[1], parts[2]
+	default:
+		return ModelRef{}, fmt.Errorf("invalid model reference: %s", s)
+	}
+
+	for _, part := range []string{ref.Host, ref.Namespace, ref.Model, ref.Tag} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			return ModelRef{}, fmt.Errorf("invalid model reference: %s", s)
+		}
+	}
+
+	return ref, nil
+}
+
+// Name returns the short display name used throughout the catalog: the
+// default registry and "library" namespace are omitted.
+func (r ModelRef) Name() string {
+	switch {
+	case r.Host != defaultRegistry:
+		return r.Host + "/" + r.Namespace + "/" + r.Model
+	case r.Namespace != defaultNamespace:
+		return r.Namespace + "/" + r.Model
+	default:
+		return r.Model
+	}
+}
+
+// String returns the short "name:tag" form of the reference.
+func (r ModelRef) String() string {
+	return r.Name() + ":" + r.Tag
+}
+
+// manifestPath returns where Ollama keeps the manifest for this reference
+// inside a models directory.
+func (r ModelRef) manifestPath(modelsDir string) string {
+	return filepath.Join(modelsDir, "manifests", r.Host, r.Namespace, r.Model, r.Tag)
+}
+
+// localManifest is a manifest file found while walking a models directory.
+type localManifest struct {
+	Ref      ModelRef
+	Path     string
+	Modified time.Time
+}
+
+// scanManifests walks the manifests tree of an Ollama models directory and
+// returns every manifest in it, published or not.
+func scanManifests(modelsDir string) ([]localManifest, error) {
+	var manifests []localManifest
+
+	manifestsDir := filepath.Join(modelsDir, "manifests")
+
+	err := filepath.Walk(manifestsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip errors
+		}
+
+		if info.IsDir() || strings.Contains(info.Name(), ".") {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(manifestsDir, path)
+		parts := strings.Split(relPath, string(filepath.Separator))
+		if len(parts) != 4 {
+			return nil
+		}
+
+		manifests = append(manifests, localManifest{
+			Ref: ModelRef{
+				Host:      parts[0],
+				Namespace: parts[1],
+				Model:     parts[2],
+				Tag:       parts[3],
+			},
+			Path:     path,
+			Modified: info.ModTime(),
+		})
+
+		return nil
+	})
+
+	return manifests, err
+}
+
+// defaultModelsDir returns ~/.ollama/models.
+func defaultModelsDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ollama", "models"), nil
+}
+
+// defaultDataDir returns ~/.ollama-lancache, where the server keeps its own
+// state (catalog approvals and the like) separate from the models directory.
+func defaultDataDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ollama-lancache"), nil
+}
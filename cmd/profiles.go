@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// profileConfig is one entry of the serve.profiles config list.
+type profileConfig struct {
+	Name        string   `mapstructure:"name"`
+	Description string   `mapstructure:"description"`
+	Models      []string `mapstructure:"models"`
+	Clients     []string `mapstructure:"clients"` // IPs or CIDRs this profile is the default for
+}
+
+// Profile is a named list of models that clients install or sync together,
+// e.g. one per team.
+type Profile struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Models      []string `json:"models"`
+	Clients     []string `json:"clients,omitempty"`
+
+	// Filled in per request by the API
+	Size    int64    `json:"size"`              // distinct blobs of the published models, shared layers counted once
+	Missing []string `json:"missing,omitempty"` // models that are not published
+	Default bool     `json:"default"`           // the default profile of the requesting client
+
+	clients TrustedProxies
+}
+
+// Profiles holds the profiles from the config file, in config order.
+type Profiles struct {
+	list   []Profile
+	byName map[string]int
+}
+
+func newProfiles(configs []profileConfig) (*Profiles, error) {
+	p := &Profiles{byName: make(map[string]int)}
+	for _, cfg := range configs {
+		name := strings.ToLower(strings.TrimSpace(cfg.Name))
+		if !profileNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid profile name %q (use lowercase letters, digits, '.', '-' and '_')", cfg.Name)
+		}
+		if _, dup := p.byName[name]; dup {
+			return nil, fmt.Errorf("duplicate profile %q", name)
+		}
+		if len(cfg.Models) == 0 {
+			return nil, fmt.Errorf("profile %q lists no models", name)
+		}
+
+		profile := Profile{Name: name, Description: cfg.Description, Models: []string{}}
+		seen := make(map[string]bool)
+		for _, model := range cfg.Models {
+			ref, err := parseModelRef(model)
+			if err != nil {
+				return nil, fmt.Errorf("profile %q: %w", name, err)
+			}
+			if !seen[ref.String()] {
+				seen[ref.String()] = true
+				profile.Models = append(profile.Models, ref.String())
+			}
+		}
+		clients, err := parseTrustedProxies(cfg.Clients)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+		profile.clients = clients
+		for _, prefix := range clients {
+			profile.Clients = append(profile.Clients, prefix.String())
+		}
+
+		p.byName[name] = len(p.list)
+		p.list = append(p.list, profile)
+	}
+	return p, nil
+}
+
+// Get returns the profile with the given name.
+func (p *Profiles) Get(name string) (Profile, bool) {
+	i, ok := p.byName[strings.ToLower(name)]
+	if !ok {
+		return Profile{}, false
+	}
+	return p.list[i], true
+}
+
+// List returns every profile.
+func (p *Profiles) List() []Profile {
+	return append([]Profile{}, p.list...)
+}
+
+// Default returns the profile whose clients most specifically match addr;
+// between equally specific matches the first one in the config wins.
+func (p *Profiles) Default(addr netip.Addr) (Profile, bool) {
+	best, bestBits := -1, -1
+	addr = addr.Unmap()
+	for i, profile := range p.list {
+		for _, prefix := range profile.clients {
+			if prefix.Contains(addr) && prefix.Bits() > bestBits {
+				best, bestBits = i, prefix.Bits()
+			}
+		}
+	}
+	if best < 0 {
+		return Profile{}, false
+	}
+	return p.list[best], true
+}
+
+// handleProfilesAPI serves the profiles defined in the config file:
+//
+//	GET /api/profiles          - every profile, the caller's default marked
+//	GET /api/profiles/default  - the caller's default profile, unless a profile is named "default"
+//	GET /api/profiles/{name}   - a single profile
+func (s *ModelServer) handleProfilesAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	client, _ := parseHostAddr(getClientIP(r))
+	fallback, hasDefault := s.profiles.Default(client)
+
+	// Sizes count each blob once across the profile's models, which is
+	// what installing the whole profile downloads
+	models, idx, _ := s.getCatalog(r.Context())
+	published := make(map[string]bool, len(models))
+	for _, m := range models {
+		published[m.Name+":"+m.Tag] = true
+	}
+	describe := func(profile Profile) Profile {
+		var targets []string
+		profile.Missing = nil
+		for _, model := range profile.Models {
+			if !published[model] {
+				profile.Missing = append(profile.Missing, model)
+				continue
+			}
+			ref, _ := parseModelRef(model)
+			target, _ := s.resolveRef(ref)
+			targets = append(targets, target.String())
+		}
+		if idx != nil {
+			profile.Size = idx.DedupedSize(targets)
+		}
+		profile.Default = hasDefault && profile.Name == fallback.Name
+		return profile
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/profiles"), "/")
+	if name == "" {
+		profiles := []Profile{}
+		for _, profile := range s.profiles.List() {
+			profiles = append(profiles, describe(profile))
+		}
+		writeJSON(w, http.StatusOK, profiles)
+		return
+	}
+
+	profile, ok := s.profiles.Get(name)
+	if !ok && name == "default" {
+		if !hasDefault {
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no default profile for %s", getClientIP(r)))
+			return
+		}
+		profile, ok = fallback, true
+	}
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("profile %q not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, describe(profile))
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAProxy PROXY protocol, versions 1 and 2:
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+
+const (
+	proxyHeaderTimeout = 10 * time.Second
+	proxyV1MaxLength   = 107
+	proxyV2MaxLength   = 2048 // addresses plus TLVs; HAProxy sends far less
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyListener reads a PROXY protocol header from connections made by
+// trusted proxies, so RemoteAddr reports the original client. Connections
+// from anyone else are left alone, and a header they send is just a bad
+// HTTP request. A trusted proxy may also connect without a header, e.g. for
+// health checks.
+type proxyListener struct {
+	net.Listener
+	trusted TrustedProxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if peer, ok := parseHostAddr(conn.RemoteAddr().String()); !ok || !l.trusted.Contains(peer) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads the header lazily, on the connection's own goroutine, so
+// a slow proxy cannot stall Accept.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	local  net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.local, c.err = readProxyHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			slog.Warn("Invalid PROXY protocol header", "peer", c.Conn.RemoteAddr().String(), "error", c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// ReadFrom keeps sendfile available for responses.
+func (c *proxyConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader consumes a v1 or v2 header and returns the addresses it
+// carries. Both are nil when the connection has no header, or the header
+// does not describe a TCP connection (v1 UNKNOWN, v2 LOCAL).
+func readProxyHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	if prefix, _ := r.Peek(len(proxyV2Signature)); bytes.Equal(prefix, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	if prefix, _ := r.Peek(len(proxyV1Prefix)); bytes.Equal(prefix, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	return nil, nil, nil
+}
+
+// readProxyV1 parses "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, nil, errors.New("v1 header too long")
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, errors.New("v1 header does not end with CRLF")
+	}
+
+	fields := strings.Split(header, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed v1 header %q", header)
+	}
+	src, err := proxyV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := proxyV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func proxyV1Addr(proto, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (proto == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid %s address %q", proto, host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyV2 parses the binary header: signature, version/command,
+// family/protocol, a big-endian length and then the addresses.
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("reading v2 header: %w", err)
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("unsupported v2 header version %d", version)
+	}
+	command := header[12] & 0x0f
+	family, proto := header[13]>>4, header[13]&0x0f
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if length > proxyV2MaxLength {
+		return nil, nil, fmt.Errorf("v2 header too long (%d bytes)", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("reading v2 addresses: %w", err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL: the proxy's own connection, e.g. a health check
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+	if proto != 0x1 { // only STREAM
+		return nil, nil, nil
+	}
+
+	var size int
+	switch family {
+	case 0x1: // INET
+		size = net.IPv4len
+	case 0x2: // INET6
+		size = net.IPv6len
+	default: // UNSPEC and UNIX carry no usable IP
+		return nil, nil, nil
+	}
+	if len(payload) < 2*size+4 {
+		return nil, nil, errors.New("v2 address block too short")
+	}
+	src := &net.TCPAddr{
+		IP:   net.IP(payload[:size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size:])),
+	}
+	dst := &net.TCPAddr{
+		IP:   net.IP(payload[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(payload[2*size+2:])),
+	}
+	return src, dst, nil
+}
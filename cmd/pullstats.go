@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// PullCount is how often a model has been pulled from this server.
+type PullCount struct {
+	Pulls      int64     `json:"pulls"`
+	LastPulled time.Time `json:"last_pulled"`
+}
+
+// pullStatsFlushInterval is how often changed pull counts are written out.
+const pullStatsFlushInterval = 30 * time.Second
+
+// PullStats counts manifest requests per model so the catalog can be sorted
+// by popularity. Counts survive restarts in pulls.json in the data directory;
+// they are written by Flush rather than on every pull, so a burst of pulls
+// does not rewrite the file each time.
+type PullStats struct {
+	path string
+
+	mu     sync.Mutex
+	counts map[string]*PullCount
+	dirty  bool
+}
+
+func newPullStats(dataDir string) (*PullStats, error) {
+	p := &PullStats{
+		path:   filepath.Join(dataDir, "pulls.json"),
+		counts: make(map[string]*PullCount),
+	}
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pull stats: %w", err)
+	}
+	if err := json.Unmarshal(data, &p.counts); err != nil {
+		return nil, fmt.Errorf("failed to parse pull stats %s: %w", p.path, err)
+	}
+	return p, nil
+}
+
+// Record counts one pull of ref.
+func (p *PullStats) Record(ref string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	count, ok := p.counts[ref]
+	if !ok {
+		count = &PullCount{}
+		p.counts[ref] = count
+	}
+	count.Pulls++
+	count.LastPulled = time.Now().UTC()
+	p.dirty = true
+}
+
+// Flush writes the counts to pulls.json if they changed since the last
+// flush.
+func (p *PullStats) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.counts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write pull stats: %w", err)
+	}
+	p.dirty = false
+	return nil
+}
+
+// Get returns the pull count of ref.
+func (p *PullStats) Get(ref string) PullCount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if count, ok := p.counts[ref]; ok {
+		return *count
+	}
+	return PullCount{}
+}
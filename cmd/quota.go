@@ -0,0 +1,474 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quotaConfig is one entry of the serve.quotas config list. Exactly one of
+// Client, Subnet and Token selects who the rule applies to.
+type quotaConfig struct {
+	Name   string `mapstructure:"name"`
+	Client string `mapstructure:"client"` // IP, CIDR or "*": every matching client has its own quota
+	Subnet string `mapstructure:"subnet"` // CIDR: matching clients share one quota
+	Token  string `mapstructure:"token"`  // bearer token sent by the client
+	Limit  string `mapstructure:"limit"`  // e.g. "200GB"
+	Window string `mapstructure:"window"` // daily, weekly or a duration such as 12h
+}
+
+// QuotaRule limits the bytes downloaded over a rolling window.
+type QuotaRule struct {
+	Name       string
+	Limit      int64
+	Window     time.Duration
+	WindowName string
+
+	client    netip.Prefix
+	anyClient bool
+	subnet    netip.Prefix
+	token     string
+}
+
+func newQuotaRule(cfg quotaConfig) (*QuotaRule, error) {
+	rule := &QuotaRule{Name: cfg.Name}
+
+	selectors := 0
+	for _, s := range []string{cfg.Client, cfg.Subnet, cfg.Token} {
+		if s != "" {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		return nil, fmt.Errorf("quota %q: set exactly one of client, subnet or token", cfg.Name)
+	}
+
+	switch {
+	case cfg.Client == "*":
+		rule.anyClient = true
+	case cfg.Client != "":
+		proxies, err := parseTrustedProxies([]string{cfg.Client})
+		if err != nil || len(proxies) != 1 {
+			return nil, fmt.Errorf("quota %q: invalid client %q", cfg.Name, cfg.Client)
+		}
+		rule.client = proxies[0]
+	case cfg.Subnet != "":
+		prefix, err := netip.ParsePrefix(cfg.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("quota %q: invalid subnet %q", cfg.Name, cfg.Subnet)
+		}
+		rule.subnet = prefix.Masked()
+	default:
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("token quotas need a name, which is shown instead of the token")
+		}
+		rule.token = cfg.Token
+	}
+	if rule.Name == "" {
+		rule.Name = "client:" + cfg.Client
+		if cfg.Subnet != "" {
+			rule.Name = "subnet:" + rule.subnet.String()
+		}
+	}
+
+	limit, err := parseByteSize(cfg.Limit)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("quota %q: invalid limit %q", rule.Name, cfg.Limit)
+	}
+	rule.Limit = limit
+
+	rule.WindowName = strings.ToLower(cfg.Window)
+	switch rule.WindowName {
+	case "", "daily":
+		rule.WindowName, rule.Window = "daily", 24*time.Hour
+	case "weekly":
+		rule.Window = 7 * 24 * time.Hour
+	default:
+		window, err := parseAge(cfg.Window)
+		if err != nil || window < time.Hour {
+			return nil, fmt.Errorf("quota %q: invalid window %q (use daily, weekly or a duration of at least 1h)", rule.Name, cfg.Window)
+		}
+		rule.Window = window
+	}
+	return rule, nil
+}
+
+// subject returns who usage is counted against for a request, or "" when
+// the rule does not apply to it.
+func (rule *QuotaRule) subject(client netip.Addr, token string) string {
+	switch {
+	case rule.token != "":
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(rule.token)) == 1 {
+			return "token"
+		}
+	case rule.subnet.IsValid():
+		if client.IsValid() && rule.subnet.Contains(client) {
+			return rule.subnet.String()
+		}
+	case rule.anyClient || rule.client.Contains(client):
+		if client.IsValid() {
+			return client.String()
+		}
+	}
+	return ""
+}
+
+// bucket is the granularity usage is recorded at: 1/24th of the window, so
+// a daily quota frees up hour by hour.
+func (rule *QuotaRule) bucket() time.Duration {
+	return (rule.Window / 24).Truncate(time.Minute)
+}
+
+// QuotaStatus is one quota as seen by a client.
+type QuotaStatus struct {
+	Rule      string    `json:"rule"`
+	Subject   string    `json:"subject"`
+	Window    string    `json:"window"`
+	Limit     int64     `json:"limit_bytes"`
+	Used      int64     `json:"used_bytes"`
+	Remaining int64     `json:"remaining_bytes"`
+	ResetAt   time.Time `json:"reset_at"` // when all current usage has left the window
+}
+
+// quotaUsage is the recorded usage of one rule by one subject.
+type quotaUsage struct {
+	Rule    string          `json:"rule"`
+	Subject string          `json:"subject"`
+	Buckets map[int64]int64 `json:"buckets"` // bucket start (Unix seconds) -> bytes
+}
+
+// Quotas enforces byte quotas on downloads. Usage survives restarts in
+// quotas.json in the data directory.
+type Quotas struct {
+	path  string
+	rules []*QuotaRule
+
+	mu       sync.Mutex
+	usage    map[string]*quotaUsage // key: rule + "|" + subject
+	reserved map[string]int64       // bytes of downloads in progress, same keys
+}
+
+func newQuotas(dataDir string, configs []quotaConfig) (*Quotas, error) {
+	q := &Quotas{
+		path:     filepath.Join(dataDir, "quotas.json"),
+		usage:    make(map[string]*quotaUsage),
+		reserved: make(map[string]int64),
+	}
+	names := make(map[string]bool)
+	for _, cfg := range configs {
+		rule, err := newQuotaRule(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate quota name %q", rule.Name)
+		}
+		names[rule.Name] = true
+		q.rules = append(q.rules, rule)
+	}
+
+	data, err := os.ReadFile(q.path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+	if err := json.Unmarshal(data, &q.usage); err != nil {
+		return nil, fmt.Errorf("failed to parse quota usage %s: %w", q.path, err)
+	}
+	// Forget usage of rules that are no longer configured
+	for key, u := range q.usage {
+		if !names[u.Rule] {
+			delete(q.usage, key)
+		}
+	}
+	return q, nil
+}
+
+// Check reports whether want more bytes fit in every quota that applies,
+// counting the bytes reserved by downloads still in progress. When they fit,
+// want is reserved until Charge settles it, so parallel requests cannot all
+// pass. When they do not, it returns the exhausted quota and when the request
+// would fit.
+func (q *Quotas) Check(client netip.Addr, token string, want int64) (QuotaStatus, time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for _, rule := range q.rules {
+		subject := rule.subject(client, token)
+		if subject == "" {
+			continue
+		}
+		key := rule.Name + "|" + subject
+		u := q.usage[key]
+		status := q.statusLocked(rule, subject, u, now)
+		used := status.Used + q.reserved[key]
+		if used >= rule.Limit || used+want > rule.Limit {
+			return status, q.fitsAtLocked(rule, u, want+q.reserved[key], now), false
+		}
+		keys = append(keys, key)
+	}
+	if want > 0 {
+		for _, key := range keys {
+			q.reserved[key] += want
+		}
+	}
+	return QuotaStatus{}, time.Time{}, true
+}
+
+// Charge releases the reserved bytes of a download Check let through and
+// records the n bytes actually sent against every quota that applies.
+func (q *Quotas) Charge(client netip.Addr, token string, reserved, n int64) error {
+	if reserved <= 0 && n <= 0 {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	charged := false
+	for _, rule := range q.rules {
+		subject := rule.subject(client, token)
+		if subject == "" {
+			continue
+		}
+		key := rule.Name + "|" + subject
+		if reserved > 0 {
+			q.reserved[key] -= reserved
+			if q.reserved[key] <= 0 {
+				delete(q.reserved, key)
+			}
+		}
+		if n <= 0 {
+			continue
+		}
+		u, ok := q.usage[key]
+		if !ok {
+			u = &quotaUsage{Rule: rule.Name, Subject: subject, Buckets: make(map[int64]int64)}
+			q.usage[key] = u
+		}
+		u.Buckets[now.Truncate(rule.bucket()).Unix()] += n
+		q.pruneLocked(rule, u, now)
+		charged = true
+	}
+	if !charged {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(q.usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, data, 0644)
+}
+
+// Status returns the quotas that apply to a client.
+func (q *Quotas) Status(client netip.Addr, token string) []QuotaStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	statuses := []QuotaStatus{}
+	for _, rule := range q.rules {
+		if subject := rule.subject(client, token); subject != "" {
+			statuses = append(statuses, q.statusLocked(rule, subject, q.usage[rule.Name+"|"+subject], now))
+		}
+	}
+	return statuses
+}
+
+// All returns the usage of every subject with bytes in a current window.
+func (q *Quotas) All() []QuotaStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	statuses := []QuotaStatus{}
+	for _, rule := range q.rules {
+		// Heaviest users first within each rule, rules in config order
+		var ruleStatuses []QuotaStatus
+		for _, u := range q.usage {
+			if u.Rule != rule.Name {
+				continue
+			}
+			if status := q.statusLocked(rule, u.Subject, u, now); status.Used > 0 {
+				ruleStatuses = append(ruleStatuses, status)
+			}
+		}
+		sort.Slice(ruleStatuses, func(i, j int) bool { return ruleStatuses[i].Used > ruleStatuses[j].Used })
+		statuses = append(statuses, ruleStatuses...)
+	}
+	return statuses
+}
+
+// expiry is when the bytes in a bucket leave the rolling window.
+func (rule *QuotaRule) expiry(start int64) time.Time {
+	return time.Unix(start, 0).Add(rule.bucket() + rule.Window)
+}
+
+func (q *Quotas) statusLocked(rule *QuotaRule, subject string, u *quotaUsage, now time.Time) QuotaStatus {
+	status := QuotaStatus{Rule: rule.Name, Subject: subject, Window: rule.WindowName, Limit: rule.Limit}
+	if u != nil {
+		for start, n := range u.Buckets {
+			if expiry := rule.expiry(start); expiry.After(now) {
+				status.Used += n
+				if expiry.After(status.ResetAt) {
+					status.ResetAt = expiry
+				}
+			}
+		}
+	}
+	if status.ResetAt.IsZero() {
+		status.ResetAt = now
+	}
+	status.Remaining = max(rule.Limit-status.Used, 0)
+	return status
+}
+
+// fitsAtLocked returns when enough usage will have expired for want more
+// bytes to fit, or when the window is empty if want exceeds the limit.
+func (q *Quotas) fitsAtLocked(rule *QuotaRule, u *quotaUsage, want int64, now time.Time) time.Time {
+	if u == nil {
+		return now
+	}
+	var starts []int64
+	used := int64(0)
+	for start, n := range u.Buckets {
+		if rule.expiry(start).After(now) {
+			starts = append(starts, start)
+			used += n
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	at := now
+	for _, start := range starts {
+		if used < rule.Limit && used+want <= rule.Limit {
+			break
+		}
+		used -= u.Buckets[start]
+		at = rule.expiry(start)
+	}
+	return at
+}
+
+func (q *Quotas) pruneLocked(rule *QuotaRule, u *quotaUsage, now time.Time) {
+	for start := range u.Buckets {
+		if !rule.expiry(start).After(now) {
+			delete(u.Buckets, start)
+		}
+	}
+}
+
+// requestedBytes estimates how much of a file of the given size a request
+// will transfer, honoring a single Range.
+func requestedBytes(r *http.Request, size int64) int64 {
+	if r.Method == http.MethodHead {
+		return 0
+	}
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return size
+	}
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return size
+	}
+	if startStr == "" { // suffix range: the last n bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil {
+			return size
+		}
+		return min(n, size)
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start >= size {
+		return size
+	}
+	end := size - 1
+	if endStr != "" {
+		if end, err = strconv.ParseInt(endStr, 10, 64); err != nil {
+			return size
+		}
+		end = min(end, size-1)
+	}
+	return max(end-start+1, 0)
+}
+
+// checkQuota writes a 429 and returns false when want more bytes would
+// exceed one of the client's quotas.
+func (s *ModelServer) checkQuota(w http.ResponseWriter, r *http.Request, want int64) bool {
+	client, _ := parseHostAddr(getClientIP(r))
+	status, retryAt, ok := s.quotas.Check(client, bearerToken(r), want)
+	if ok {
+		return true
+	}
+
+	retryAfter := int64(math.Ceil(time.Until(retryAt).Seconds()))
+	retryAfter = max(retryAfter, 1)
+	msg := fmt.Sprintf("download quota %q exceeded: %s of %s used in the %s window",
+		status.Rule, formatBytes(status.Used), formatBytes(status.Limit), status.Window)
+	if want > status.Limit {
+		msg = fmt.Sprintf("download of %s is larger than quota %q (%s %s)",
+			formatBytes(want), status.Rule, formatBytes(status.Limit), status.Window)
+	}
+
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error":           msg,
+		"requested_bytes": want,
+		"retry_at":        retryAt.UTC(),
+		"retry_after":     retryAfter,
+		"quota":           status,
+	})
+	requestLog(r).Warn("Download quota exceeded", "quota", status.Rule, "subject", status.Subject,
+		"used", status.Used, "limit", status.Limit, "requested", want, "path", r.URL.Path)
+	return false
+}
+
+// chargeQuota settles a download that checkQuota let through with reserved
+// bytes: the reservation is released and the n bytes sent are recorded.
+func (s *ModelServer) chargeQuota(r *http.Request, reserved, n int64) {
+	client, _ := parseHostAddr(getClientIP(r))
+	if err := s.quotas.Charge(client, bearerToken(r), reserved, n); err != nil {
+		requestLog(r).Warn("Could not record quota usage", "error", err)
+	}
+}
+
+// handleQuotaAPI serves GET /api/quota: the caller's own quotas.
+func (s *ModelServer) handleQuotaAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	client, _ := parseHostAddr(getClientIP(r))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"client": getClientIP(r),
+		"quotas": s.quotas.Status(client, bearerToken(r)),
+	})
+}
+
+// handleAdminQuotas serves GET /api/admin/quotas: usage of every client.
+func (s *ModelServer) handleAdminQuotas(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.quotas.All())
+}
@@ -0,0 +1,766 @@
+package cmd
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	maxManifestSize   = 4 << 20
+	pushTokenLifetime = time.Hour
+	uploadStaleAfter  = 24 * time.Hour
+)
+
+// uploadSession is an in-progress blob upload started with
+// POST /v2/<name>/blobs/uploads/.
+type uploadSession struct {
+	ID         string
+	Repo       string
+	Path       string
+	Size       int64
+	Started    time.Time
+	LastActive time.Time
+	mu         sync.Mutex
+
+	// Chunks may arrive out of order and in parallel, so the byte ranges
+	// written so far are kept (sorted and merged) along with the number of
+	// chunks still being written.
+	received [][2]int64 // [start, end)
+	writing  int
+}
+
+// addRange records that [start, end) has been written.
+func (u *uploadSession) addRange(start, end int64) {
+	if end <= start {
+		return
+	}
+	ranges := append(u.received, [2]int64{start, end})
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	u.received = merged
+	if end > u.Size {
+		u.Size = end
+	}
+}
+
+// missingRange returns the first gap in the received bytes, if any.
+func (u *uploadSession) missingRange() (start, end int64, ok bool) {
+	var next int64
+	for _, r := range u.received {
+		if r[0] > next {
+			return next, r[0], true
+		}
+		next = r[1]
+	}
+	return 0, 0, false
+}
+
+// PushAuth decides who may write to the registry. Clients authenticate with
+// a static push token (or the admin token) sent as a Bearer token, or with
+// the Ollama CLI's key-signed token exchange against an authorized key.
+type PushAuth struct {
+	tokens     []string
+	adminToken string
+	keys       map[string]ed25519.PublicKey // base64 wire-format key -> key
+
+	mu     sync.Mutex
+	issued map[string]time.Time // token -> expiry
+}
+
+func newPushAuth(tokens []string, adminToken, authorizedKeysFile string) (*PushAuth, error) {
+	p := &PushAuth{
+		tokens:     tokens,
+		adminToken: adminToken,
+		keys:       make(map[string]ed25519.PublicKey),
+		issued:     make(map[string]time.Time),
+	}
+
+	if authorizedKeysFile == "" {
+		return p, nil
+	}
+
+	f, err := os.Open(authorizedKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open authorized keys: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || fields[0] != "ssh-ed25519" {
+			return nil, fmt.Errorf("%s:%d: expected an ssh-ed25519 public key", authorizedKeysFile, line)
+		}
+		key, err := parseSSHEd25519Key(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", authorizedKeysFile, line, err)
+		}
+		p.keys[fields[1]] = key
+	}
+	return p, scanner.Err()
+}
+
+// enabled reports whether any push credential is configured.
+func (p *PushAuth) enabled() bool {
+	return len(p.tokens) > 0 || p.adminToken != "" || len(p.keys) > 0
+}
+
+// authorized reports whether the request carries a valid push credential.
+func (p *PushAuth) authorized(r *http.Request) bool {
+	token := bearerToken(r)
+	if token == "" {
+		return false
+	}
+
+	for _, t := range append([]string{p.adminToken}, p.tokens...) {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	expiry, ok := p.issued[token]
+	if ok && time.Now().After(expiry) {
+		delete(p.issued, token)
+		return false
+	}
+	return ok
+}
+
+// isAdmin reports whether the request carries the admin token.
+func (p *PushAuth) isAdmin(r *http.Request) bool {
+	token := bearerToken(r)
+	return p.adminToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(p.adminToken)) == 1
+}
+
+// issueToken exchanges an Ollama key signature for a short-lived push token.
+// The Ollama CLI signs "GET,<token URL>,<base64 of the hex sha256 of an empty
+// body>" with its ed25519 key and sends "<public key>:<signature>".
+func (p *PushAuth) issueToken(r *http.Request) (string, error) {
+	pubKey, sig, ok := strings.Cut(r.Header.Get("Authorization"), ":")
+	if !ok {
+		return "", fmt.Errorf("missing key signature")
+	}
+	key, ok := p.keys[pubKey]
+	if !ok {
+		return "", fmt.Errorf("key is not authorized to push")
+	}
+	sigBytes, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return "", fmt.Errorf("malformed signature")
+	}
+
+	ts, err := strconv.ParseInt(r.URL.Query().Get("ts"), 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > 5*time.Minute {
+		return "", fmt.Errorf("stale or missing timestamp")
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	emptySum := sha256.Sum256(nil)
+	signed := fmt.Sprintf("%s,%s://%s%s,%s", http.MethodGet, scheme, r.Host, r.URL.RequestURI(),
+		base64.StdEncoding.EncodeToString([]byte(hex.EncodeToString(emptySum[:]))))
+	if !ed25519.Verify(key, []byte(signed), sigBytes) {
+		return "", fmt.Errorf("signature verification failed")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for t, expiry := range p.issued {
+		if now.After(expiry) {
+			delete(p.issued, t)
+		}
+	}
+	p.issued[token] = now.Add(pushTokenLifetime)
+	return token, nil
+}
+
+// parseSSHEd25519Key decodes the base64 body of an "ssh-ed25519" public key.
+func parseSSHEd25519Key(b64 string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+
+	readString := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("malformed public key")
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint32(len(data)-4) < n {
+			return nil, fmt.Errorf("malformed public key")
+		}
+		s := data[4 : 4+n]
+		data = data[4+n:]
+		return s, nil
+	}
+
+	keyType, err := readString()
+	if err != nil || string(keyType) != "ssh-ed25519" {
+		return nil, fmt.Errorf("not an ssh-ed25519 public key")
+	}
+	key, err := readString()
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("malformed ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// writeRegistryError writes an error in the Docker registry v2 format.
+func writeRegistryError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+// repoRef maps a registry repository name ("model" or "namespace/model") to
+// the model it is stored as. Pushed models live under the default registry
+// host so they appear in the catalog as "namespace/model:tag".
+func repoRef(repo, tag string) (ModelRef, error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) > 2 {
+		return ModelRef{}, fmt.Errorf("invalid repository name: %s", repo)
+	}
+	return parseModelRef(repo + ":" + tag)
+}
+
+// handleRegistry implements the subset of the Docker registry v2 API that the
+// Ollama CLI uses for `ollama pull` and `ollama push`:
+//
+//	GET        /v2/                                 - API version check
+//	GET        /v2/token                            - key-signed token exchange
+//	GET, HEAD  /v2/<name>/manifests/<tag>           - pull a manifest
+//	PUT        /v2/<name>/manifests/<tag>           - push a manifest
+//	GET, HEAD  /v2/<name>/blobs/<digest>            - pull or check a blob
+//	POST       /v2/<name>/blobs/uploads/            - start a blob upload
+//	PATCH      /v2/<name>/blobs/uploads/<id>        - upload a chunk
+//	PUT        /v2/<name>/blobs/uploads/<id>?digest - finish and verify an upload
+//	GET        /v2/<name>/blobs/uploads/<id>        - upload status
+//	DELETE     /v2/<name>/blobs/uploads/<id>        - cancel an upload
+func (s *ModelServer) handleRegistry(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+
+	switch {
+	case path == "":
+		writeJSON(w, http.StatusOK, map[string]string{})
+
+	case path == "token":
+		s.handleRegistryToken(w, r)
+
+	case strings.Contains(path, "/blobs/uploads"):
+		i := strings.Index(path, "/blobs/uploads")
+		id := strings.Trim(path[i+len("/blobs/uploads"):], "/")
+		if !s.requirePush(w, r) {
+			return
+		}
+		s.handleBlobUpload(w, r, path[:i], id)
+
+	case strings.Contains(path, "/blobs/"):
+		i := strings.LastIndex(path, "/blobs/")
+		digest := path[i+len("/blobs/"):]
+		if !validDigest(digest) {
+			writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest")
+			return
+		}
+		if _, err := os.Stat(blobPath(s.modelsDir, digest)); err != nil {
+			writeRegistryError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", digest)
+		if r.Method == http.MethodHead {
+			// Existence checks (e.g. before a push) are not downloads.
+			info, _ := os.Stat(blobPath(s.modelsDir, digest))
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		s.serveBlob(w, r, digest)
+
+	case strings.Contains(path, "/manifests/"):
+		i := strings.LastIndex(path, "/manifests/")
+		repo := path[:i]
+		ref, err := repoRef(repo, path[i+len("/manifests/"):])
+		if err != nil {
+			writeRegistryError(w, http.StatusBadRequest, "NAME_INVALID", err.Error())
+			return
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			s.serveManifest(w, r, ref)
+		case http.MethodPut:
+			if !s.requirePush(w, r) {
+				return
+			}
+			s.handleManifestPush(w, r, repo, ref)
+		default:
+			writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+		}
+
+	default:
+		writeRegistryError(w, http.StatusNotFound, "NOT_FOUND", "not found")
+	}
+}
+
+// requirePush checks push credentials. Unauthenticated requests get a Bearer
+// challenge pointing at /v2/token, which the Ollama CLI answers by signing
+// the token request with its key.
+func (s *ModelServer) requirePush(w http.ResponseWriter, r *http.Request) bool {
+	if !s.pushAuth.enabled() {
+		writeRegistryError(w, http.StatusForbidden, "DENIED", "push is disabled on this server (no push tokens or keys configured)")
+		return false
+	}
+	if s.pushAuth.authorized(r) {
+		return true
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s://%s/v2/token",service="ollama-lancache",scope="repository:*:push"`, scheme, r.Host))
+	writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	requestLog(r).Warn("Rejected unauthenticated push request", "method", r.Method, "path", r.URL.Path)
+	return false
+}
+
+func (s *ModelServer) handleRegistryToken(w http.ResponseWriter, r *http.Request) {
+	token, err := s.pushAuth.issueToken(r)
+	if err != nil {
+		requestLog(r).Warn("Push token request denied", "error", err)
+		writeRegistryError(w, http.StatusUnauthorized, "UNAUTHORIZED", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_in": int(pushTokenLifetime.Seconds()),
+		"issued_at":  time.Now().UTC().Format(time.RFC3339),
+	})
+	requestLog(r).Info("Issued push token")
+}
+
+func (s *ModelServer) uploadsDir() string {
+	// Uploads are staged inside the models directory so that the final
+	// rename into blobs/ stays on the same filesystem.
+	return filepath.Join(s.modelsDir, ".lancache-uploads")
+}
+
+func (s *ModelServer) handleBlobUpload(w http.ResponseWriter, r *http.Request, repo, id string) {
+	uploadURL := func(u *uploadSession) string {
+		return fmt.Sprintf("/v2/%s/blobs/uploads/%s", u.Repo, u.ID)
+	}
+
+	if id == "" {
+		if r.Method != http.MethodPost {
+			writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+			return
+		}
+
+		// Cross-repository mount: every repository shares one blob store, so
+		// the blob only needs to exist.
+		if mount := r.URL.Query().Get("mount"); mount != "" && validDigest(mount) {
+			if _, err := os.Stat(blobPath(s.modelsDir, mount)); err == nil {
+				w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, mount))
+				w.Header().Set("Docker-Content-Digest", mount)
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+
+		upload, err := s.startUpload(repo)
+		if err != nil {
+			requestLog(r).Error("Could not start upload", "error", err)
+			writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not start upload")
+			return
+		}
+
+		// Monolithic upload: POST with ?digest= and the whole blob as body.
+		if digest := r.URL.Query().Get("digest"); digest != "" {
+			s.finishUpload(w, r, upload, digest)
+			return
+		}
+
+		w.Header().Set("Location", uploadURL(upload))
+		w.Header().Set("Docker-Upload-UUID", upload.ID)
+		w.Header().Set("Range", "0-0")
+		w.WriteHeader(http.StatusAccepted)
+		requestLog(r).Info("Blob upload started", "upload", upload.ID, "repository", repo)
+		return
+	}
+
+	s.uploadMu.Lock()
+	upload, ok := s.uploads[id]
+	s.uploadMu.Unlock()
+	if !ok || upload.Repo != repo {
+		writeRegistryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "upload unknown to registry")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		upload.mu.Lock()
+		size := upload.Size
+		upload.mu.Unlock()
+		w.Header().Set("Location", uploadURL(upload))
+		w.Header().Set("Docker-Upload-UUID", upload.ID)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodPatch:
+		if err := s.writeUploadChunk(upload, r); err != nil {
+			writeRegistryError(w, http.StatusRequestedRangeNotSatisfiable, "BLOB_UPLOAD_INVALID", err.Error())
+			return
+		}
+		upload.mu.Lock()
+		size := upload.Size
+		upload.mu.Unlock()
+		w.Header().Set("Location", uploadURL(upload))
+		w.Header().Set("Docker-Upload-UUID", upload.ID)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+		w.WriteHeader(http.StatusAccepted)
+
+	case http.MethodPut:
+		s.finishUpload(w, r, upload, r.URL.Query().Get("digest"))
+
+	case http.MethodDelete:
+		s.removeUpload(upload)
+		w.WriteHeader(http.StatusNoContent)
+		requestLog(r).Info("Blob upload cancelled", "upload", upload.ID)
+
+	default:
+		writeRegistryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "method not allowed")
+	}
+}
+
+func (s *ModelServer) startUpload(repo string) (*uploadSession, error) {
+	if err := os.MkdirAll(s.uploadsDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(buf)
+
+	upload := &uploadSession{
+		ID:         id,
+		Repo:       repo,
+		Path:       filepath.Join(s.uploadsDir(), id),
+		Started:    time.Now(),
+		LastActive: time.Now(),
+	}
+	f, err := os.Create(upload.Path)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	s.uploadMu.Lock()
+	s.uploads[id] = upload
+	s.uploadMu.Unlock()
+	return upload, nil
+}
+
+// writeUploadChunk writes a request body into the upload file. Chunks carry
+// a "Content-Range: <start>-<end>" header (with or without a "bytes" unit)
+// and may arrive in any order, as Ollama sends parts in parallel; without
+// the header the body is appended. Gaps are only checked when the upload is
+// finished.
+func (s *ModelServer) writeUploadChunk(upload *uploadSession, r *http.Request) (err error) {
+	_, span := startSpan(r.Context(), "blob.upload",
+		attribute.String("upload_id", upload.ID),
+		attribute.String(logClient, getClientIP(r)))
+	defer func() { endSpan(span, err) }()
+
+	offset, want, ranged := int64(0), int64(-1), false
+	if cr := r.Header.Get("Content-Range"); cr != "" {
+		cr = strings.TrimSpace(strings.TrimPrefix(cr, "bytes"))
+		cr = strings.TrimPrefix(cr, "=")
+		startStr, endStr, _ := strings.Cut(strings.TrimSpace(cr), "-")
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return fmt.Errorf("invalid Content-Range %q", r.Header.Get("Content-Range"))
+		}
+		if end, err := strconv.ParseInt(strings.TrimSpace(endStr), 10, 64); err == nil {
+			if end < start {
+				return fmt.Errorf("invalid Content-Range %q", r.Header.Get("Content-Range"))
+			}
+			want = end - start + 1
+		}
+		offset, ranged = start, true
+	}
+
+	f, err := os.OpenFile(upload.Path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// An append goes after everything received so far, which is only known
+	// once no other chunk is still being written.
+	upload.mu.Lock()
+	if !ranged {
+		if upload.writing > 0 {
+			upload.mu.Unlock()
+			return fmt.Errorf("chunks without Content-Range must be sent one at a time")
+		}
+		offset = upload.Size
+	}
+	upload.writing++
+	upload.mu.Unlock()
+
+	n, err := io.Copy(io.NewOffsetWriter(f, offset), r.Body)
+	span.SetAttributes(attribute.Int64(logBytes, n), attribute.Int64("offset", offset))
+
+	upload.mu.Lock()
+	upload.writing--
+	upload.addRange(offset, offset+n)
+	upload.LastActive = time.Now()
+	upload.mu.Unlock()
+
+	if err == nil && want >= 0 && n != want {
+		err = fmt.Errorf("chunk at %d should be %d bytes but %d were received", offset, want, n)
+	}
+	return err
+}
+
+// finishUpload writes any final body, verifies the digest and moves the blob
+// into place.
+func (s *ModelServer) finishUpload(w http.ResponseWriter, r *http.Request, upload *uploadSession, digest string) {
+
+	if !validDigest(digest) {
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "missing or invalid digest")
+		return
+	}
+
+	if r.ContentLength != 0 {
+		if err := s.writeUploadChunk(upload, r); err != nil {
+			writeRegistryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+			return
+		}
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+
+	if upload.writing > 0 {
+		writeRegistryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", "chunks are still being uploaded")
+		return
+	}
+	if start, end, gap := upload.missingRange(); gap {
+		writeRegistryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID",
+			fmt.Sprintf("bytes %d-%d of the blob were never uploaded", start, end-1))
+		return
+	}
+	info, err := os.Stat(upload.Path)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not read upload")
+		return
+	}
+	if info.Size() != upload.Size {
+		writeRegistryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID",
+			fmt.Sprintf("upload file is %d bytes but %d were received", info.Size(), upload.Size))
+		return
+	}
+
+	actual, err := fileDigest(upload.Path)
+	if err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not read upload")
+		return
+	}
+	if actual != digest {
+		requestLog(r).Warn("Blob upload digest mismatch", logDigest, digest, "actual_digest", actual)
+		s.webhooks.Emit(EventIntegrityFailed,
+			fmt.Sprintf("Upload from %s did not match digest %s", getClientIP(r), digest),
+			integrityEvent{Client: getClientIP(r), Digest: digest, ActualDigest: actual, Reason: "upload digest mismatch"})
+		s.removeUploadLocked(upload)
+		writeRegistryError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+		return
+	}
+
+	dest := blobPath(s.modelsDir, digest)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not create blobs directory")
+		return
+	}
+	if err := os.Rename(upload.Path, dest); err != nil {
+		requestLog(r).Error("Could not store blob", logDigest, digest, "error", err)
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not store blob")
+		return
+	}
+	s.removeUploadLocked(upload)
+
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", upload.Repo, digest))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+	requestLog(r).Info("Blob uploaded", logDigest, digest, logBytes, upload.Size, logDuration, time.Since(upload.Started))
+}
+
+func (s *ModelServer) removeUpload(upload *uploadSession) {
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	s.removeUploadLocked(upload)
+}
+
+func (s *ModelServer) removeUploadLocked(upload *uploadSession) {
+	os.Remove(upload.Path)
+	s.uploadMu.Lock()
+	delete(s.uploads, upload.ID)
+	s.uploadMu.Unlock()
+}
+
+// cleanupStaleUploads removes uploads that were abandoned mid-way.
+func (s *ModelServer) cleanupStaleUploads() {
+	s.uploadMu.Lock()
+	var stale []*uploadSession
+	for _, upload := range s.uploads {
+		upload.mu.Lock()
+		if time.Since(upload.LastActive) > uploadStaleAfter {
+			stale = append(stale, upload)
+		}
+		upload.mu.Unlock()
+	}
+	s.uploadMu.Unlock()
+
+	for _, upload := range stale {
+		slog.Info("Removing abandoned blob upload", "upload", upload.ID, "repository", upload.Repo)
+		s.removeUpload(upload)
+	}
+}
+
+// handleManifestPush stores a pushed manifest once every blob it references
+// is present, and publishes the model in the catalog.
+func (s *ModelServer) handleManifestPush(w http.ResponseWriter, r *http.Request, repo string, ref ModelRef) {
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxManifestSize+1))
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", "could not read manifest")
+		return
+	}
+	if len(data) > maxManifestSize {
+		writeRegistryError(w, http.StatusRequestEntityTooLarge, "MANIFEST_INVALID", "manifest too large")
+		return
+	}
+
+	manifest, err := parseManifest(data)
+	if err != nil {
+		writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	for _, layer := range manifest.Blobs() {
+		info, err := os.Stat(blobPath(s.modelsDir, layer.Digest))
+		if err != nil {
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "blob unknown to registry: "+layer.Digest)
+			return
+		}
+		if info.Size() != layer.Size {
+			s.webhooks.Emit(EventIntegrityFailed,
+				fmt.Sprintf("Manifest pushed by %s for %s has the wrong size for blob %s", getClientIP(r), ref.String(), layer.Digest),
+				integrityEvent{Client: getClientIP(r), Model: ref.String(), Digest: layer.Digest, Reason: "blob size mismatch"})
+			writeRegistryError(w, http.StatusBadRequest, "MANIFEST_INVALID", "size mismatch for blob "+layer.Digest)
+			return
+		}
+	}
+
+	if _, isAlias := s.aliases.Get(ref.String()); isAlias {
+		writeRegistryError(w, http.StatusConflict, "DENIED", ref.String()+" is an alias; push to its target instead")
+		return
+	}
+
+	dest := ref.manifestPath(s.modelsDir)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not create manifest directory")
+		return
+	}
+	if err := writeFileAtomic(dest, data, 0644); err != nil {
+		requestLog(r).Error("Could not store manifest", logModel, ref.String(), "error", err)
+		writeRegistryError(w, http.StatusInternalServerError, "UNKNOWN", "could not store manifest")
+		return
+	}
+
+	// Push credentials are not admin credentials: a pushed model goes
+	// through curation like one found on disk, keeping the status of an
+	// existing entry. Pushes with the admin token are published at once.
+	_, existed := s.catalog.Get(ref.String())
+	entry, err := s.catalog.Update(ref.String(), func(entry *CatalogEntry) error {
+		entry.RemovedAt = nil
+		if s.pushAuth.isAdmin(r) || (!existed && s.catalog.autoApprove) {
+			entry.Status = CatalogApproved
+		}
+		return nil
+	})
+	if err != nil {
+		requestLog(r).Warn("Could not record pushed model in the catalog", logModel, ref.String(), "error", err)
+		entry.Status = CatalogPending
+	}
+	if s.webhooks.announceOne(ref.String()) {
+		s.emitModelAdded(r.Context(), ref, entry.Status, "push")
+	}
+
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repo, ref.Tag))
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusCreated)
+
+	var total int64
+	for _, layer := range manifest.Layers {
+		total += layer.Size
+	}
+	requestLog(r).Info("Model pushed", logModel, ref.String(), "status", entry.Status, logDigest, digest, "layers", len(manifest.Layers), logBytes, total)
+}
+
+// manifestDigest returns the sha256 digest of a manifest file's bytes.
+func manifestDigest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
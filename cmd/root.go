@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
@@ -10,6 +12,10 @@ import (
 
 var (
 	cfgFile     string
+	
+	// configReadErr is why the config file could not be read, if it exists;
+	// commands that rely on it report it.
+	configReadErr error
 	version     = "dev"
 	commit      = "unknown"
 	buildTime   = "unknown"
@@ -38,6 +44,29 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
+// ExitError ends the process with Code. Without Err it reports a status
+// rather than a failure, and main prints nothing.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// silenceRunErrors is called first by RunE commands. Once a command runs, a
+// failure is not a usage mistake, and main prints the error itself.
+func silenceRunErrors(cmd *cobra.Command) {
+	cmd.SilenceUsage = true
+	cmd.SilenceErrors = true
+}
+
 // SetVersionInfo sets the version information for the CLI
 func SetVersionInfo(v, c, bt string) {
 	version = v
@@ -50,8 +79,12 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.ollama-lancache.yaml)")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: debug, info, warn or error (env LOG_LEVEL)")
+	rootCmd.PersistentFlags().String("log-format", "text", "Log format: text or json (env LOG_FORMAT)")
 	
 	viper.BindPFlags(rootCmd.PersistentFlags())
+	viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("log.format", rootCmd.PersistentFlags().Lookup("log-format"))
 }
 
 func initConfig() {
@@ -66,9 +99,19 @@ func initConfig() {
 		viper.SetConfigName(".ollama-lancache")
 	}
 	
-	viper.AutomaticEnv()
+	// Flags of every subcommand are bound by now
+	bindConfigEnv()
+	
+	configErr := viper.ReadInConfig()
+	var notFound viper.ConfigFileNotFoundError
+	if configErr != nil && !errors.As(configErr, &notFound) && !os.IsNotExist(configErr) {
+		configReadErr = configErr
+	}
 	
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+	if err := setupLogging(os.Stderr, viper.GetString("log.level"), viper.GetString("log.format")); err != nil {
+		cobra.CheckErr(err)
+	}
+	if configErr == nil {
+		slog.Info("Using config file", "path", viper.ConfigFileUsed())
 	}
 }
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotateTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an append-only log file that is rotated when it grows past
+// MaxSize bytes or has been open longer than MaxAge. Rotated files are
+// renamed to <name>-<timestamp><ext>, optionally gzipped, and only the newest
+// MaxBackups are kept. Zero values disable the corresponding limit.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	Compress   bool
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.openLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if (f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize) ||
+		(f.MaxAge > 0 && time.Since(f.openedAt) > f.MaxAge) {
+		if err := f.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) openLocked() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.openedAt = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *RotatingFile) rotateLocked() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	ext := filepath.Ext(f.Path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.Path, ext), time.Now().UTC().Format(rotateTimeFormat), ext)
+	if err := os.Rename(f.Path, backup); err != nil {
+		return err
+	}
+	if err := f.openLocked(); err != nil {
+		return err
+	}
+
+	// Compression and cleanup can take a while for large files
+	go func() {
+		if f.Compress {
+			if err := gzipFile(backup); err != nil {
+				slog.Warn("Could not compress rotated log", "path", backup, "error", err)
+			}
+		}
+		f.removeOldBackups()
+	}()
+	return nil
+}
+
+// backups returns the rotated files of this log, newest first.
+func (f *RotatingFile) backups() []string {
+	ext := filepath.Ext(f.Path)
+	prefix := filepath.Base(strings.TrimSuffix(f.Path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.Path))
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ext)
+		if _, err := time.Parse(rotateTimeFormat, stamp); err == nil {
+			names = append(names, name)
+		}
+	}
+	// Timestamps sort chronologically as strings
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(filepath.Dir(f.Path), name)
+	}
+	return paths
+}
+
+func (f *RotatingFile) removeOldBackups() {
+	if f.MaxBackups <= 0 {
+		return
+	}
+	backups := f.backups()
+	for i := f.MaxBackups; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil {
+			slog.Warn("Could not remove old log", "path", backups[i], "error", err)
+		}
+	}
+}
+
+// gzipFile compresses path to path.gz and removes the original.
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
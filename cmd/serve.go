@@ -1,19 +1,26 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"html"
+	"log/slog"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var serveCmd = &cobra.Command{
@@ -29,118 +36,303 @@ func init() {
 	serveCmd.Flags().IntP("port", "p", 8080, "Port to serve models on")
 	serveCmd.Flags().StringP("models-dir", "d", "", "Directory containing Ollama models (default: ~/.ollama/models)")
 	serveCmd.Flags().StringP("bind", "b", "0.0.0.0", "IP address to bind to")
+	serveCmd.Flags().String("data-dir", "", "Directory for server state such as catalog approvals (default: ~/.ollama-lancache)")
+	serveCmd.Flags().String("admin-token", "", "Bearer token required by the admin API (admin API is disabled when empty)")
+	serveCmd.Flags().Bool("auto-approve", false, "Publish newly discovered models immediately instead of marking them pending")
+	serveCmd.Flags().StringSlice("push-token", nil, "Bearer token allowed to push models via the registry API (repeatable)")
+	serveCmd.Flags().String("push-authorized-keys", "", "File of Ollama public keys (~/.ollama/id_ed25519.pub) allowed to `ollama push`")
+	serveCmd.Flags().String("access-log", "", "Write an HTTP access log to this file (\"-\" for stdout, disabled when empty)")
+	serveCmd.Flags().String("access-log-format", "combined", "Access log format: common, combined or json")
+	serveCmd.Flags().String("access-log-max-size", "100MB", "Rotate the access log when it reaches this size (0 to disable)")
+	serveCmd.Flags().String("access-log-max-age", "", "Rotate the access log after this long, e.g. 24h or 7d")
+	serveCmd.Flags().Int("access-log-max-backups", 10, "Rotated access logs to keep (0 keeps all)")
+	serveCmd.Flags().Bool("access-log-compress", true, "Gzip rotated access logs")
+	serveCmd.Flags().String("quota-per-client", "", "Download quota for every client IP, e.g. 200GB (disabled when empty)")
+	serveCmd.Flags().String("quota-window", "daily", "Rolling window of --quota-per-client: daily, weekly or a duration")
+	serveCmd.Flags().StringSlice("trusted-proxy", nil, "CIDR or IP of a reverse proxy whose forwarding headers are trusted (repeatable)")
+	serveCmd.Flags().String("trusted-header", "x-forwarded-for", "Header trusted proxies report the client address in: x-forwarded-for, forwarded or x-real-ip")
+	serveCmd.Flags().Bool("proxy-protocol", false, "Accept HAProxy PROXY protocol v1/v2 headers from trusted proxies")
+	serveCmd.Flags().StringSlice("webhook", nil, "URL to post session and catalog events to (repeatable; see serve.webhooks for per-target options)")
+	serveCmd.Flags().String("webhook-secret", "", "Secret used to sign payloads sent to --webhook URLs")
+	serveCmd.Flags().String("tracing", "none", "OpenTelemetry trace exporter: none, otlp or file")
+	serveCmd.Flags().String("tracing-endpoint", "", "OTLP/HTTP endpoint, e.g. http://collector:4318 (default: OTEL_EXPORTER_OTLP_ENDPOINT)")
+	serveCmd.Flags().String("tracing-file", "", "File the file exporter writes spans to, \"-\" for stdout (default: <data-dir>/traces.jsonl)")
+	serveCmd.Flags().Float64("tracing-sample-ratio", 1, "Fraction of new traces to record (0 to 1)")
+	serveCmd.Flags().String("public-url", "", "URL clients reach the server at, used in the install scripts (default: taken from each request)")
+	serveCmd.Flags().String("ca-cert", "", "PEM CA certificate for an https --public-url, served at /ca.pem and pinned in the install scripts")
+	serveCmd.Flags().String("downloads-dir", "", "Directory served at /downloads/ (default: <data-dir>/downloads)")
+	serveCmd.Flags().Bool("downloads-readme", true, "Write a README.txt when creating the downloads directory")
+	serveCmd.Flags().StringSlice("upload-token", nil, "Bearer token allowed to upload to /downloads/ (repeatable; the admin token also works)")
+	serveCmd.Flags().String("signing-key", "", "ed25519 private key (PEM) to sign SHA256SUMS and install scripts with; created if missing")
+	serveCmd.Flags().String("max-upload-size", "4GB", "Largest file accepted by uploads to /downloads/")
+	serveCmd.Flags().String("upload-expiry", "", "Delete uploaded files after this long, e.g. 7d (default: keep; uploads can set their own)")
+	serveCmd.Flags().String("script-dir", "", "Directory whose install.sh / install.ps1 templates replace the built-in install scripts")
 	
 	viper.BindPFlag("serve.port", serveCmd.Flags().Lookup("port"))
 	viper.BindPFlag("serve.models-dir", serveCmd.Flags().Lookup("models-dir"))
 	viper.BindPFlag("serve.bind", serveCmd.Flags().Lookup("bind"))
+	viper.BindPFlag("serve.data-dir", serveCmd.Flags().Lookup("data-dir"))
+	viper.BindPFlag("serve.admin-token", serveCmd.Flags().Lookup("admin-token"))
+	viper.BindPFlag("serve.auto-approve", serveCmd.Flags().Lookup("auto-approve"))
+	viper.BindPFlag("serve.push.tokens", serveCmd.Flags().Lookup("push-token"))
+	viper.BindPFlag("serve.push.authorized-keys", serveCmd.Flags().Lookup("push-authorized-keys"))
+	viper.BindPFlag("serve.access-log.path", serveCmd.Flags().Lookup("access-log"))
+	viper.BindPFlag("serve.access-log.format", serveCmd.Flags().Lookup("access-log-format"))
+	viper.BindPFlag("serve.access-log.max-size", serveCmd.Flags().Lookup("access-log-max-size"))
+	viper.BindPFlag("serve.access-log.max-age", serveCmd.Flags().Lookup("access-log-max-age"))
+	viper.BindPFlag("serve.access-log.max-backups", serveCmd.Flags().Lookup("access-log-max-backups"))
+	viper.BindPFlag("serve.access-log.compress", serveCmd.Flags().Lookup("access-log-compress"))
+	viper.BindPFlag("serve.quota.per-client", serveCmd.Flags().Lookup("quota-per-client"))
+	viper.BindPFlag("serve.quota.window", serveCmd.Flags().Lookup("quota-window"))
+	viper.BindPFlag("serve.trusted-proxies", serveCmd.Flags().Lookup("trusted-proxy"))
+	viper.BindPFlag("serve.trusted-header", serveCmd.Flags().Lookup("trusted-header"))
+	viper.BindPFlag("serve.proxy-protocol", serveCmd.Flags().Lookup("proxy-protocol"))
+	viper.BindPFlag("serve.webhook.urls", serveCmd.Flags().Lookup("webhook"))
+	viper.BindPFlag("serve.webhook.secret", serveCmd.Flags().Lookup("webhook-secret"))
+	viper.BindPFlag("serve.tracing.exporter", serveCmd.Flags().Lookup("tracing"))
+	viper.BindPFlag("serve.tracing.endpoint", serveCmd.Flags().Lookup("tracing-endpoint"))
+	viper.BindPFlag("serve.tracing.file", serveCmd.Flags().Lookup("tracing-file"))
+	viper.BindPFlag("serve.tracing.sample-ratio", serveCmd.Flags().Lookup("tracing-sample-ratio"))
+	viper.BindPFlag("serve.public-url", serveCmd.Flags().Lookup("public-url"))
+	viper.BindPFlag("serve.scripts.ca-cert", serveCmd.Flags().Lookup("ca-cert"))
+	viper.BindPFlag("serve.scripts.dir", serveCmd.Flags().Lookup("script-dir"))
+	viper.BindPFlag("serve.downloads.dir", serveCmd.Flags().Lookup("downloads-dir"))
+	viper.BindPFlag("serve.downloads.readme", serveCmd.Flags().Lookup("downloads-readme"))
+	viper.BindPFlag("serve.downloads.upload-tokens", serveCmd.Flags().Lookup("upload-token"))
+	viper.BindPFlag("serve.signing-key", serveCmd.Flags().Lookup("signing-key"))
+	viper.BindPFlag("serve.downloads.max-upload-size", serveCmd.Flags().Lookup("max-upload-size"))
+	viper.BindPFlag("serve.downloads.default-expiry", serveCmd.Flags().Lookup("upload-expiry"))
 }
 
 type ModelInfo struct {
 	Name         string    `json:"name"`
 	Tag          string    `json:"tag"`
 	Size         int64     `json:"size"`
+	UniqueSize   int64     `json:"unique_size"` // bytes only this model uses; freed if it is deleted
+	SharedSize   int64     `json:"shared_size"` // bytes in blobs shared with other models
 	Modified     time.Time `json:"modified"`
 	DownloadURL  string    `json:"download_url"`
 	ManifestURL  string    `json:"manifest_url"`
+	Digest       string    `json:"digest"` // sha256 of the manifest
+	
+	// Read from the manifest and config blob
+	Details    ModelDetails `json:"details"`
+	LayerTypes []string     `json:"layer_types"`
+	
+	// How often the model has been pulled from this server
+	Pulls int64 `json:"pulls"`
+	
+	// Curation data from the published catalog
+	Description        string `json:"description,omitempty"`
+	RecommendedUse     string `json:"recommended_use,omitempty"`
+	Deprecated         bool   `json:"deprecated"`
+	DeprecationMessage string `json:"deprecation_message,omitempty"`
+	
+	// Set when this entry is a server-side alias for another model
+	AliasOf string `json:"alias_of,omitempty"`
 }
 
 type ServerInfo struct {
 	ServerVersion string      `json:"server_version"`
 	ModelsDir     string      `json:"models_dir"`
 	Models        []ModelInfo `json:"models"`
-	TotalSize     int64       `json:"total_size_bytes"`
+	TotalSize     int64       `json:"total_size_bytes"`   // distinct blobs of published models
+	LogicalSize   int64       `json:"logical_size_bytes"` // sum of model sizes, counting shared blobs once per model
+	SharedSavings int64       `json:"shared_savings_bytes"`
+	DiskUsage     int64       `json:"disk_usage_bytes"` // every blob file on disk, published or not
+	OrphanedBlobs int         `json:"orphaned_blobs"`
+	OrphanedSize  int64       `json:"orphaned_size_bytes"`
 	TotalModels   int         `json:"total_models"`
 }
 
 func runServe(cmd *cobra.Command, args []string) {
-	port := viper.GetInt("serve.port")
-	bind := viper.GetString("serve.bind")
-	modelsDir := viper.GetString("serve.models-dir")
+	cfg := checkServeConfig()
+	port := cfg.Serve.Port
+	bind := cfg.Serve.Bind
+	modelsDir := cfg.Serve.ModelsDir
+	dataDir := cfg.Serve.DataDir
 	
 	if modelsDir == "" {
-		homeDir, err := os.UserHomeDir()
+		dir, err := defaultModelsDir()
+		if err != nil {
+			fatal("Cannot determine models directory", "error", err)
+		}
+		modelsDir = dir
+	}
+	
+	if dataDir == "" {
+		dir, err := defaultDataDir()
 		if err != nil {
-			log.Fatal("Could not determine home directory:", err)
+			fatal("Cannot determine data directory", "error", err)
 		}
-		modelsDir = filepath.Join(homeDir, ".ollama", "models")
+		dataDir = dir
 	}
 	
 	// Check if models directory exists
 	if _, err := os.Stat(modelsDir); os.IsNotExist(err) {
-		log.Fatalf("Models directory does not exist: %s", modelsDir)
-	}
-	
-	// Create downloads directory if it doesn't exist
-	downloadsDir := "downloads"
-	if _, err := os.Stat(downloadsDir); os.IsNotExist(err) {
-		if err := os.MkdirAll(downloadsDir, 0755); err != nil {
-			log.Printf("Warning: Could not create downloads directory: %v", err)
-		} else {
-			log.Printf("Created downloads directory: %s", downloadsDir)
-			
-			// Create a helpful README file in the new downloads directory
-			readmeContent := `# Downloads Directory
-
-This directory serves as a file sharing location for your ollama-lancache server.
-
-## What is this for?
-
-You can place any files here that you want to make available for download to clients on your network:
-
-### File Types Supported:
-- 📦 **Executable files**: .exe, .msi, .deb, .rpm, .dmg
-- 🗜️ **Archive files**: .zip, .tar.gz, .7z, .rar
-- 📄 **Documentation**: .pdf, .txt, .md, .docx
-- ⚙️ **Configuration files**: .json, .yaml, .conf, .ini
-- 📝 **Scripts**: .ps1, .sh, .bat, .py
-
-### How to Use:
-
-1. **Add files**: Simply copy files to this directory
-   ` + "`" + `cp my-app.exe downloads/` + "`" + `
-
-2. **Share URLs**: Files are available at:
-   - Browse all files: http://your-server:8080/downloads/
-   - Direct download: http://your-server:8080/downloads/filename.exe
-
-3. **Web interface**: Visit the downloads page for a clean file browser
-
-### Security Notes:
-- Only files (not subdirectories) are served for security
-- Directory traversal protection prevents access to parent directories
-- Files are served with appropriate content-type headers
-
-### Examples:
-- Share Ollama installers for different platforms
-- Distribute documentation and setup guides
-- Provide configuration templates
-- Share utility scripts and tools
-
-This directory is automatically created by ollama-lancache and is ignored by git.
-You can safely delete this README.txt file if you don't need it.
-
----
-Generated by ollama-lancache v` + version + `
-`
-			readmePath := filepath.Join(downloadsDir, "README.txt")
-			if err := os.WriteFile(readmePath, []byte(readmeContent), 0644); err != nil {
-				log.Printf("Warning: Could not create downloads README: %v", err)
-			} else {
-				log.Printf("Created downloads README: %s", readmePath)
-			}
+		fatal("Models directory does not exist", "path", modelsDir)
+	}
+	
+	downloadsConfig := cfg.Serve.Downloads
+	if downloadsConfig.Dir == "" {
+		downloadsConfig.Dir = filepath.Join(dataDir, "downloads")
+		if info, err := os.Stat("downloads"); err == nil && info.IsDir() {
+			// Earlier versions always served ./downloads
+			slog.Warn("Serving ./downloads from the working directory; set --downloads-dir to keep doing so, the default is now <data-dir>/downloads")
+			downloadsConfig.Dir = "downloads"
+		}
+	}
+	var signer *Signer
+	if cfg.Serve.SigningKey != "" {
+		var err error
+		signer, err = loadSigner(cfg.Serve.SigningKey)
+		if err != nil {
+			fatal("Could not load signing key", "error", err)
 		}
+		slog.Info("Signing checksums and install scripts", "key_fingerprint", "sha256:"+signer.fingerprint)
+		if cfg.Serve.PublicURL == "" {
+			slog.Warn("Install scripts are not signed without --public-url")
+		}
+	}
+	
+	downloads, err := newDownloads(downloadsConfig, dataDir, cfg.Serve.AdminToken)
+	if err != nil {
+		fatal("Could not set up downloads directory", "error", err)
+	}
+	
+	catalog, err := newCatalogStore(dataDir, cfg.Serve.AutoApprove)
+	if err != nil {
+		fatal("Could not open catalog", "error", err)
+	}
+	
+	aliases, err := newAliasStore(dataDir)
+	if err != nil {
+		fatal("Could not open aliases", "error", err)
+	}
+	if err := aliases.applyConfigAliases(modelsDir, cfg.Serve.Aliases); err != nil {
+		fatal("Could not apply configured aliases", "error", err)
+	}
+	
+	adminToken := cfg.Serve.AdminToken
+	pushAuth, err := newPushAuth(cfg.Serve.Push.Tokens, adminToken, cfg.Serve.Push.AuthorizedKeys)
+	if err != nil {
+		fatal("Could not load push credentials", "error", err)
+	}
+	
+	pulls, err := newPullStats(dataDir)
+	if err != nil {
+		fatal("Could not open pull stats", "error", err)
+	}
+	
+	changes, err := newChangeFeed(dataDir)
+	if err != nil {
+		fatal("Could not open catalog change feed", "error", err)
+	}
+	
+	accessLog, err := newAccessLog(cfg.Serve.AccessLog)
+	if err != nil {
+		fatal("Could not open access log", "error", err)
+	}
+	
+	quotaConfigs := cfg.Serve.Quotas
+	if limit := cfg.Serve.Quota.PerClient; limit != "" {
+		quotaConfigs = append(quotaConfigs, quotaConfig{
+			Name:   "per-client",
+			Client: "*",
+			Limit:  limit,
+			Window: cfg.Serve.Quota.Window,
+		})
+	}
+	quotas, err := newQuotas(dataDir, quotaConfigs)
+	if err != nil {
+		fatal("Invalid download quotas", "error", err)
+	}
+	
+	profiles, err := newProfiles(cfg.Serve.Profiles)
+	if err != nil {
+		fatal("Invalid profiles", "error", err)
+	}
+	
+	scriptConfig := cfg.Serve.Scripts
+	scriptConfig.PublicURL = cfg.Serve.PublicURL
+	installScripts, err := newInstallScripts(scriptConfig, profiles)
+	if err != nil {
+		fatal("Invalid install script settings", "error", err)
 	}
 	
+	webhookConfigs := cfg.Serve.Webhooks
+	for _, u := range cfg.Serve.Webhook.URLs {
+		webhookConfigs = append(webhookConfigs, webhookConfig{
+			URL:    u,
+			Secret: cfg.Serve.Webhook.Secret,
+		})
+	}
+	webhooks, err := newWebhooks(dataDir, webhookConfigs)
+	if err != nil {
+		fatal("Invalid webhooks", "error", err)
+	}
+	
+	trustedProxies, err := parseTrustedProxies(cfg.Serve.TrustedProxies)
+	if err != nil {
+		fatal("Invalid trusted proxies", "error", err)
+	}
+	trustedHeader, err := parseTrustedHeader(cfg.Serve.TrustedHeader)
+	if err != nil {
+		fatal("Invalid trusted header", "error", err)
+	}
+	proxyProtocol := cfg.Serve.ProxyProtocol
+	if proxyProtocol && len(trustedProxies) == 0 {
+		fatal("--proxy-protocol requires --trusted-proxy for the load balancers that send it")
+	}
+	
+	tracing := cfg.Serve.Tracing
+	if tracing.File == "" {
+		tracing.File = filepath.Join(dataDir, "traces.jsonl")
+	}
+	shutdownTracing, err := setupTracing(tracing)
+	if err != nil {
+		fatal("Could not set up tracing", "error", err)
+	}
 	server := &ModelServer{
-		modelsDir: modelsDir,
-		bind:      bind,
-		port:      port,
-		sessions:  make(map[string]*DownloadSession),
+		modelsDir:      modelsDir,
+		dataDir:        dataDir,
+		bind:           bind,
+		port:           port,
+		adminToken:     adminToken,
+		catalog:        catalog,
+		aliases:        aliases,
+		pushAuth:       pushAuth,
+		gguf:           newGGUFCache(),
+		pulls:          pulls,
+		changes:        changes,
+		profiles:       profiles,
+		installScripts: installScripts,
+		downloads:      downloads,
+		signer:         signer,
+		accessLog:      accessLog,
+		quotas:         quotas,
+		webhooks:       webhooks,
+		trustedProxies: trustedProxies,
+		trustedHeader:  trustedHeader,
+		proxyProtocol:  proxyProtocol,
+		sessions:       make(map[string]*DownloadSession),
+		uploads:        make(map[string]*uploadSession),
 	}
 	
 	server.start()
+	
+	// Spans of requests that were still running when the server stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Could not flush traces", "error", err)
+	}
 }
 
+// shutdownTimeout is how long running requests get to finish when the
+// server is stopped.
+const shutdownTimeout = 10 * time.Second
+
 // DownloadSession tracks a client's model download session
 type DownloadSession struct {
 	ClientIP    string
@@ -150,14 +342,38 @@ type DownloadSession struct {
 	BytesServed int64
 	TotalFiles  int
 	FilesServed int
+
+	// logger carries the request ID and client of the manifest request that
+	// started the session, so later session events can be traced back to it.
+	logger *slog.Logger
 }
 
 type ModelServer struct {
-	modelsDir string
-	bind      string
-	port      int
-	sessions  map[string]*DownloadSession // Key: clientIP:model
-	sessionMu sync.RWMutex
+	modelsDir      string
+	dataDir        string
+	bind           string
+	port           int
+	adminToken     string
+	catalog        *CatalogStore
+	aliases        *AliasStore
+	pushAuth       *PushAuth
+	gguf           *GGUFCache
+	pulls          *PullStats
+	changes        *ChangeFeed
+	profiles       *Profiles
+	installScripts *InstallScripts
+	downloads      *Downloads
+	signer         *Signer // nil when signing is disabled
+	accessLog      *AccessLog // nil when disabled
+	quotas         *Quotas
+	webhooks       *Webhooks
+	trustedProxies TrustedProxies
+	trustedHeader  string
+	proxyProtocol  bool
+	sessions       map[string]*DownloadSession // Key: clientIP:model
+	sessionMu      sync.RWMutex
+	uploads        map[string]*uploadSession // Key: upload ID
+	uploadMu       sync.Mutex
 }
 
 // getSessionKey creates a unique key for tracking download sessions
@@ -166,7 +382,7 @@ func (s *ModelServer) getSessionKey(clientIP, model string) string {
 }
 
 // startSession begins tracking a new download session
-func (s *ModelServer) startSession(clientIP, model string, totalFiles int) {
+func (s *ModelServer) startSession(logger *slog.Logger, clientIP, model string, totalFiles int) {
 	s.sessionMu.Lock()
 	defer s.sessionMu.Unlock()
 	
@@ -179,10 +395,12 @@ func (s *ModelServer) startSession(clientIP, model string, totalFiles int) {
 		BytesServed: 0,
 		TotalFiles:  totalFiles,
 		FilesServed: 0,
+		logger:      logger.With(logModel, model),
 	}
 	s.sessions[key] = session
 	
-	log.Printf("🚀 [%s] Started downloading model: %s (estimated %d files)", clientIP, model, totalFiles)
+	session.logger.Info("Download session started", "files_expected", totalFiles)
+	s.webhooks.Emit(EventSessionStarted, fmt.Sprintf("%s started pulling %s", clientIP, model), session.event())
 }
 
 // touchSession updates the LastActive timestamp for a session without changing progress
@@ -218,12 +436,11 @@ func (s *ModelServer) finishSession(clientIP, model string) {
 	if session, exists := s.sessions[key]; exists {
 		duration := time.Since(session.StartTime)
 		
-		log.Printf("🔄 [%s] Manually finishing session: %s", clientIP, model)
-		log.Printf("   📊 Duration: %v | Files: %d/%d | Data: %.2f MB", 
-			duration.Round(time.Second), 
-			session.FilesServed, 
-			session.TotalFiles,
-			float64(session.BytesServed)/1024/1024)
+		session.logger.Info("Download session finished manually",
+			logDuration, duration.Round(time.Second),
+			"files", session.FilesServed,
+			"files_expected", session.TotalFiles,
+			logBytes, session.BytesServed)
 		
 		delete(s.sessions, key)
 	}
@@ -263,13 +480,15 @@ func (s *ModelServer) checkSessionCompletion(clientIP, model string) {
 		duration := time.Since(session.StartTime)
 		avgSpeed := float64(session.BytesServed) / duration.Seconds() / 1024 / 1024 // MB/s
 		
-		log.Printf("✅ [%s] Completed downloading model: %s", clientIP, model)
-		log.Printf("   📊 Duration: %v | Files: %d/%d | Data: %.2f MB | Avg Speed: %.2f MB/s", 
-			duration.Round(time.Second), 
-			session.FilesServed, 
-			session.TotalFiles,
-			float64(session.BytesServed)/1024/1024,
-			avgSpeed)
+		session.logger.Info("Download session completed",
+			logDuration, duration.Round(time.Second),
+			"files", session.FilesServed,
+			"files_expected", session.TotalFiles,
+			logBytes, session.BytesServed,
+			"mb_per_second", math.Round(avgSpeed*100)/100)
+		s.webhooks.Emit(EventSessionCompleted,
+			fmt.Sprintf("%s finished pulling %s (%s in %s)", clientIP, model, formatBytes(session.BytesServed), duration.Round(time.Second)),
+			session.event())
 	}
 }
 
@@ -320,42 +539,98 @@ func (s *ModelServer) cleanupStaleSessions() {
 	for key, session := range s.sessions {
 		if now.Sub(session.LastActive) > staleTimeout {
 			duration := now.Sub(session.StartTime)
-			log.Printf("⏰ [%s] Session timeout: %s (duration: %v, files: %d/%d)", 
-				session.ClientIP, 
-				session.Model, 
-				duration.Round(time.Second),
-				session.FilesServed,
-				session.TotalFiles)
+			session.logger.Warn("Download session timed out",
+				logDuration, duration.Round(time.Second),
+				"files", session.FilesServed,
+				"files_expected", session.TotalFiles,
+				logBytes, session.BytesServed)
+			s.webhooks.Emit(EventSessionTimedOut,
+				fmt.Sprintf("%s stopped pulling %s after %d of %d files", session.ClientIP, session.Model, session.FilesServed, session.TotalFiles),
+				session.event())
 			delete(s.sessions, key)
 		}
 	}
 }
 
 func (s *ModelServer) start() {
+	// Scan the models directory now and then periodically, so model
+	// webhooks go out even when nobody lists the catalog
+	if _, err := s.syncCatalog(context.Background()); err != nil {
+		slog.Warn("Could not scan models directory", "error", err)
+	}
+	
 	// Start periodic cleanup of stale sessions
+	s.downloads.expireUploads()
+	go s.downloads.refreshChecksums()
 	go func() {
 		ticker := time.NewTicker(5 * time.Minute)
 		defer ticker.Stop()
 		for range ticker.C {
+			if _, err := s.syncCatalog(context.Background()); err != nil {
+				slog.Warn("Could not scan models directory", "error", err)
+			}
 			s.cleanupStaleSessions()
+			s.cleanupStaleUploads()
+			s.downloads.expireUploads()
+			s.downloads.refreshChecksums()
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(pullStatsFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := s.pulls.Flush(); err != nil {
+				slog.Warn("Could not save pull counts", "error", err)
+			}
 		}
 	}()
+	s.webhooks.Start()
 	
 	mux := http.NewServeMux()
 	
 	// API endpoints
 	mux.HandleFunc("/api/models", s.handleModelsAPI)
+	mux.HandleFunc("/api/models/", s.handleModelDetail)
+	mux.HandleFunc("/api/catalog/changes", s.handleCatalogChanges)
+	mux.HandleFunc("/api/profiles", s.handleProfilesAPI)
+	mux.HandleFunc("/api/profiles/", s.handleProfilesAPI)
 	mux.HandleFunc("/api/info", s.handleServerInfo)
 	mux.HandleFunc("/api/sessions", s.handleSessionsAPI)
+	mux.HandleFunc("/api/blobs/", s.handleBlobInfo)
+	mux.HandleFunc("/api/quota", s.handleQuotaAPI)
+	mux.HandleFunc("/api/downloads", s.handleDownloadsAPI)
+	mux.HandleFunc("/api/downloads/", s.handleDownloadsAPI)
+	
+	// Admin endpoints (require --admin-token)
+	mux.HandleFunc("/api/admin/catalog", s.handleAdminCatalog)
+	mux.HandleFunc("/api/admin/catalog/", s.handleAdminCatalog)
+	mux.HandleFunc("/api/admin/aliases", s.handleAdminAliases)
+	mux.HandleFunc("/api/admin/aliases/", s.handleAdminAliases)
+	mux.HandleFunc("/api/admin/quotas", s.handleAdminQuotas)
+	mux.HandleFunc("/api/admin/webhooks", s.handleAdminWebhooks)
+	mux.HandleFunc("/api/admin/webhooks/", s.handleAdminWebhooks)
 	
 	// Model download endpoints
 	mux.HandleFunc("/models/", s.handleModelDownload)
 	mux.HandleFunc("/manifests/", s.handleManifestDownload)
 	mux.HandleFunc("/blobs/", s.handleBlobDownload)
 	
+	// Registry v2 API for `ollama pull` and `ollama push`
+	mux.HandleFunc("/v2/", s.handleRegistry)
+	
+	// Read-only Ollama and OpenAI compatible model listing
+	mux.HandleFunc("/api/tags", s.handleOllamaTags)
+	mux.HandleFunc("/api/show", s.handleOllamaShow)
+	mux.HandleFunc("/v1/models", s.handleOpenAIModels)
+	mux.HandleFunc("/v1/models/", s.handleOpenAIModels)
+	
 	// Client scripts
-	mux.HandleFunc("/install.ps1", s.handlePowerShellScript)
-	mux.HandleFunc("/install.sh", s.handleBashScript)
+	mux.HandleFunc("/install.ps1", s.handleInstallScript)
+	mux.HandleFunc("/install.sh", s.handleInstallScript)
+	mux.HandleFunc("/install.ps1.sig", s.handleInstallScriptSignature)
+	mux.HandleFunc("/install.sh.sig", s.handleInstallScriptSignature)
+	mux.HandleFunc("/ca.pem", s.handleCACert)
+	mux.HandleFunc("/signing-key.pem", s.handleSigningKey)
 	
 	// File downloads server
 	mux.HandleFunc("/downloads/", s.handleDownloadsServer)
@@ -371,151 +646,321 @@ func (s *ModelServer) start() {
 	
 	addr := fmt.Sprintf("%s:%d", s.bind, s.port)
 	
-	log.Printf("=== ollama-lancache ===")
-	log.Printf("Models Directory: %s", s.modelsDir)
-	log.Printf("Server listening on: http://%s", addr)
-	log.Printf("")
-	log.Printf("📋 Available endpoints:")
-	log.Printf("  GET  /api/models     - List available models")
-	log.Printf("  GET  /api/info       - Server information")
-	log.Printf("  GET  /install.ps1    - PowerShell client script")
-	log.Printf("  GET  /install.sh     - Bash client script")
-	log.Printf("  GET  /downloads/     - File downloads server")
-	log.Printf("  GET  /health         - Health check")
-	log.Printf("")
-	log.Printf("🚀 Ready to serve models!")
-	log.Printf("")
+	slog.Info("ollama-lancache starting",
+		"version", version,
+		"listen", "http://"+addr,
+		"models_dir", s.modelsDir,
+		"downloads_dir", s.downloads.root,
+		"data_dir", s.dataDir)
+	
+	endpoints := [][2]string{
+		{"GET  /api/models", "List available models"},
+		{"GET  /api/catalog/changes", "Catalog changes since a cursor (long-poll with ?wait=)"},
+		{"GET  /api/profiles", "Named sets of models to install together"},
+		{"GET  /api/models/{ref}", "Model details, including GGUF header"},
+		{"GET  /api/models/{ref}/modelfile", "Reconstructed Modelfile"},
+		{"GET  /api/info", "Server information"},
+		{"GET  /api/blobs/{digest}", "Blob size and which models share it"},
+		{"GET  /api/quota", "Your download quota usage"},
+		{"*    /v2/", "Registry API (ollama pull/push)"},
+		{"GET  /api/tags, POST /api/show, GET /v1/models", "Ollama/OpenAI compatible listing"},
+		{"GET  /install.ps1", "PowerShell client script"},
+		{"GET  /install.sh", "Bash client script"},
+		{"GET  /ca.pem", "CA certificate pinned in the client scripts"},
+		{"GET  /downloads/", "File downloads server"},
+		{"GET  /api/downloads", "Files in the downloads area (JSON)"},
+		{"GET  /health", "Health check"},
+	}
+	if s.adminToken != "" {
+		endpoints = append(endpoints,
+			[2]string{"*    /api/admin/catalog", "Catalog curation (admin token required)"},
+			[2]string{"*    /api/admin/aliases", "Model aliases (admin token required)"},
+			[2]string{"GET  /api/admin/quotas", "Download quota usage of every client (admin token required)"},
+			[2]string{"GET  /api/admin/webhooks", "Webhook targets and delivery log (admin token required)"})
+	}
+	for _, e := range endpoints {
+		slog.Debug("Endpoint", "route", e[0], "description", e[1])
+	}
 	
 	// Get server IP for client instructions
 	if serverIPs := getServerIPs(); len(serverIPs) > 0 {
-		primaryIP := serverIPs[0]
-		log.Printf("📝 Client Usage:")
-		log.Printf("  Windows: powershell -c \"irm http://%s:%d/install.ps1 | iex\"", primaryIP, s.port)
-		log.Printf("  Linux:   curl -fsSL http://%s:%d/install.sh | bash", primaryIP, s.port)
-		log.Printf("  macOS:   curl -fsSL http://%s:%d/install.sh | bash", primaryIP, s.port)
-		log.Printf("")
+		base := fmt.Sprintf("http://%s:%d", serverIPs[0], s.port)
+		if s.installScripts.publicURL != "" {
+			base = s.installScripts.publicURL
+		}
+		slog.Info("Client usage",
+			"windows", fmt.Sprintf(`powershell -c "irm %s/install.ps1 | iex"`, base),
+			"linux_macos", fmt.Sprintf("curl -fsSL %s/install.sh | bash", base))
+	}
+	
+	var handler http.Handler = mux
+	if s.accessLog != nil {
+		handler = s.accessLog.Middleware(handler)
+	}
+	handler = withTracing(withRequestLog(handler), mux)
+	handler = withClientIP(handler, s.trustedProxies, s.trustedHeader)
+	
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fatal("Server failed to start", "error", err)
 	}
+	if s.proxyProtocol {
+		listener = &proxyListener{Listener: listener, trusted: s.trustedProxies}
+	}
+	
+	// On SIGINT/SIGTERM stop accepting connections and give running
+	// requests a moment to finish; a second signal stops at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		log.Fatal("Server failed to start:", err)
+	httpServer := &http.Server{Handler: handler}
+	served := make(chan error, 1)
+	go func() { served <- httpServer.Serve(listener) }()
+	
+	slog.Info("Ready to serve models")
+	select {
+	case err := <-served:
+		fatal("Server failed to start", "error", err)
+	case <-ctx.Done():
+	}
+	stop()
+	
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Closing connections that did not finish in time", "error", err)
+		httpServer.Close()
+	}
+	if err := s.pulls.Flush(); err != nil {
+		slog.Warn("Could not save pull counts", "error", err)
 	}
 }
 
-func (s *ModelServer) getAvailableModels() ([]ModelInfo, error) {
-	var models []ModelInfo
-	var totalSize int64
+// syncCatalog scans the models directory and records any newly found models
+// in the catalog, returning everything that is on disk.
+func (s *ModelServer) syncCatalog(ctx context.Context) (manifests []localManifest, err error) {
+	_, span := startSpan(ctx, "catalog.scan", attribute.String("models_dir", s.modelsDir))
+	defer func() { endSpan(span, err) }()
 	
-	manifestsDir := filepath.Join(s.modelsDir, "manifests")
+	manifests, err = scanManifests(s.modelsDir)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("models", len(manifests)))
 	
-	err := filepath.Walk(manifestsDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip errors
-		}
-		
-		if !info.IsDir() && !strings.Contains(info.Name(), ".") {
-			// Extract model name and tag from path
-			relPath, _ := filepath.Rel(manifestsDir, path)
-			parts := strings.Split(relPath, string(filepath.Separator))
-			
-			if len(parts) >= 2 {
-				registry := parts[0]
-				modelParts := parts[1:]
-				
-				// Skip registry.ollama.ai prefix
-				if registry == "registry.ollama.ai" && len(parts) >= 3 {
-					modelParts = parts[2:]
-				}
-				
-				if len(modelParts) >= 2 {
-					name := strings.Join(modelParts[:len(modelParts)-1], "/")
-					tag := modelParts[len(modelParts)-1]
-					
-					model := ModelInfo{
-						Name:        name,
-						Tag:         tag,
-						Size:        s.getModelSize(name, tag),
-						Modified:    info.ModTime(),
-						DownloadURL: fmt.Sprintf("/models/%s:%s", name, tag),
-						ManifestURL: fmt.Sprintf("/manifests/%s:%s", name, tag),
-					}
-					
-					models = append(models, model)
-					totalSize += model.Size
-				}
-			}
+	refs := make([]string, 0, len(manifests))
+	for _, m := range manifests {
+		refs = append(refs, m.Ref.String())
+	}
+	
+	added, removed, err := s.catalog.Sync(refs)
+	if err != nil {
+		return manifests, err
+	}
+	span.SetAttributes(attribute.Int("added", len(added)), attribute.Int("removed", len(removed)))
+	
+	// Events go by what this server has announced rather than by the catalog
+	// diff, which the catalog subcommand may already have written.
+	announced, gone := s.webhooks.Announce(refs)
+	for _, ref := range announced {
+		entry, _ := s.catalog.Get(ref)
+		slog.Info("New model found", logModel, ref, "status", entry.Status)
+		if parsed, err := parseModelRef(ref); err == nil {
+			s.emitModelAdded(ctx, parsed, entry.Status, "scan")
 		}
-		
-		return nil
-	})
+	}
+	for _, ref := range gone {
+		slog.Info("Model removed", logModel, ref)
+		s.webhooks.Emit(EventModelRemoved, fmt.Sprintf("Model %s was removed", ref), modelEvent{Model: ref, Source: "scan"})
+	}
 	
+	return manifests, nil
+}
+
+// getAvailableModels returns the published catalog: models that are on disk
+// and have been approved.
+func (s *ModelServer) getAvailableModels(ctx context.Context) ([]ModelInfo, error) {
+	models, _, err := s.getCatalog(ctx)
 	return models, err
 }
 
-func (s *ModelServer) getModelSize(name, tag string) int64 {
-	// Calculate total size by reading manifest and summing blob sizes
-	manifestPath := s.getManifestPath(name, tag)
-	
-	data, err := os.ReadFile(manifestPath)
-	if err != nil {
-		return 0
+// getCatalog returns the published catalog together with the blob index used
+// to compute shared and unique sizes.
+func (s *ModelServer) getCatalog(ctx context.Context) ([]ModelInfo, *BlobIndex, error) {
+	models := []ModelInfo{}
+	
+	gen := s.changes.Begin()
+	manifests, err := s.syncCatalog(ctx)
+	if err != nil && manifests == nil {
+		return nil, nil, err
 	}
+	idx := buildBlobIndex(ctx, s.modelsDir, manifests)
 	
-	var manifest map[string]interface{}
-	if err := json.Unmarshal(data, &manifest); err != nil {
-		return 0
+	for _, m := range manifests {
+		entry, ok := s.catalog.Get(m.Ref.String())
+		if !ok || entry.Status != CatalogApproved {
+			continue
+		}
+		
+		name, tag := m.Ref.Name(), m.Ref.Tag
+		unique, shared := idx.Sharing(m.Ref.String())
+		meta, _ := s.modelMetadata(ctx, m.Ref)
+		models = append(models, ModelInfo{
+			Name:               name,
+			Tag:                tag,
+			Size:               meta.Size,
+			UniqueSize:         unique,
+			SharedSize:         shared,
+			Modified:           m.Modified,
+			DownloadURL:        fmt.Sprintf("/models/%s:%s", name, tag),
+			ManifestURL:        fmt.Sprintf("/manifests/%s:%s", name, tag),
+			Digest:             meta.Digest,
+			Details:            meta.Details,
+			LayerTypes:         meta.LayerTypes,
+			Pulls:              s.pulls.Get(m.Ref.String()).Pulls,
+			Description:        entry.Description,
+			RecommendedUse:     entry.RecommendedUse,
+			Deprecated:         entry.Deprecated,
+			DeprecationMessage: entry.DeprecationMessage,
+		})
 	}
 	
-	var totalSize int64
-	if layers, ok := manifest["layers"].([]interface{}); ok {
-		for _, layer := range layers {
-			if layerMap, ok := layer.(map[string]interface{}); ok {
-				if size, ok := layerMap["size"].(float64); ok {
-					totalSize += int64(size)
-				}
-			}
+	// Aliases are listed under their own name when their target is published
+	aliases, aliasErr := s.aliases.List()
+	if aliasErr != nil {
+		return models, idx, aliasErr
+	}
+	for _, alias := range aliases {
+		aliasRef, err := parseModelRef(alias.Name)
+		if err != nil {
+			continue
+		}
+		target, err := parseModelRef(alias.Target)
+		if err != nil {
+			continue
+		}
+		entry, ok := s.publishedEntry(target)
+		if !ok {
+			continue
 		}
+		info, statErr := os.Stat(target.manifestPath(s.modelsDir))
+		if statErr != nil {
+			continue
+		}
+		
+		modified := alias.UpdatedAt
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		
+		// Deleting an alias frees nothing, so all of its bytes count as shared
+		name, tag := aliasRef.Name(), aliasRef.Tag
+		meta, _ := s.modelMetadata(ctx, target)
+		models = append(models, ModelInfo{
+			Name:               name,
+			Tag:                tag,
+			Size:               meta.Size,
+			SharedSize:         meta.Size,
+			Modified:           modified,
+			DownloadURL:        fmt.Sprintf("/models/%s:%s", name, tag),
+			ManifestURL:        fmt.Sprintf("/manifests/%s:%s", name, tag),
+			Digest:             meta.Digest,
+			Details:            meta.Details,
+			LayerTypes:         meta.LayerTypes,
+			Pulls:              s.pulls.Get(aliasRef.String()).Pulls,
+			Description:        entry.Description,
+			RecommendedUse:     entry.RecommendedUse,
+			Deprecated:         entry.Deprecated,
+			DeprecationMessage: entry.DeprecationMessage,
+			AliasOf:            alias.Target,
+		})
 	}
 	
-	return totalSize
+	if err == nil {
+		if recordErr := s.changes.Record(gen, models); recordErr != nil {
+			slog.Warn("Could not record catalog changes", "error", recordErr)
+		}
+	}
+	return models, idx, err
+}
+
+// publishedEntry returns the catalog entry for ref if the model is approved.
+func (s *ModelServer) publishedEntry(ref ModelRef) (CatalogEntry, bool) {
+	entry, ok := s.catalog.Get(ref.String())
+	if !ok || entry.Status != CatalogApproved {
+		return CatalogEntry{}, false
+	}
+	return entry, true
 }
 
 func (s *ModelServer) getManifestPath(name, tag string) string {
-	return filepath.Join(s.modelsDir, "manifests", "registry.ollama.ai", "library", name, tag)
+	ref, err := parseModelRef(name + ":" + tag)
+	if err != nil {
+		return ""
+	}
+	return ref.manifestPath(s.modelsDir)
 }
 
+// handleModelsAPI lists the published catalog. The response stays a plain
+// array; when a limit is given, the next page is linked in the Link header.
 func (s *ModelServer) handleModelsAPI(w http.ResponseWriter, r *http.Request) {
-	models, err := s.getAvailableModels()
+	query, err := parseModelQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	models, err := s.getAvailableModels(r.Context())
 	if err != nil {
 		http.Error(w, "Failed to list models", http.StatusInternalServerError)
 		return
 	}
 	
+	page, total, next := query.Apply(models)
+	w.Header().Set("X-Total-Count", fmt.Sprintf("%d", total))
+	if next != "" {
+		nextQuery := r.URL.Query()
+		nextQuery.Set("cursor", next)
+		w.Header().Set("X-Next-Cursor", next)
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, nextQuery.Encode()))
+	}
+	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models)
+	json.NewEncoder(w).Encode(page)
 	
-	log.Printf("📋 [%s] Listed %d models", getClientIP(r), len(models))
+	requestLog(r).Info("Listed models", "count", len(page), "total", total)
 }
 
 func (s *ModelServer) handleServerInfo(w http.ResponseWriter, r *http.Request) {
-	models, _ := s.getAvailableModels()
+	models, idx, _ := s.getCatalog(r.Context())
 	
-	var totalSize int64
+	var logicalSize int64
+	var refs []string
 	for _, model := range models {
-		totalSize += model.Size
+		if model.AliasOf == "" {
+			logicalSize += model.Size
+			refs = append(refs, model.Name+":"+model.Tag)
+		}
 	}
 	
 	info := ServerInfo{
 		ServerVersion: "1.0.0",
 		ModelsDir:     s.modelsDir,
 		Models:        models,
-		TotalSize:     totalSize,
+		LogicalSize:   logicalSize,
 		TotalModels:   len(models),
 	}
+	if idx != nil {
+		info.TotalSize = idx.DedupedSize(refs)
+		info.SharedSavings = logicalSize - info.TotalSize
+		info.DiskUsage, info.OrphanedBlobs, info.OrphanedSize = idx.DiskUsage()
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(info)
 	
-	log.Printf("ℹ️  [%s] Server info requested", getClientIP(r))
+	requestLog(r).Info("Server info requested")
 }
 
 func (s *ModelServer) handleSessionsAPI(w http.ResponseWriter, r *http.Request) {
@@ -562,14 +1007,18 @@ func (s *ModelServer) handleSessionsAPI(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 	
-	log.Printf("📊 [%s] Active sessions requested", getClientIP(r))
+	requestLog(r).Info("Active sessions requested")
 }
 
 func (s *ModelServer) handleModelDownload(w http.ResponseWriter, r *http.Request) {
 	// Extract model name:tag from URL
 	path := strings.TrimPrefix(r.URL.Path, "/models/")
 	
-	log.Printf("📦 [%s] Model download requested: %s", getClientIP(r), path)
+	requestLog(r).Info("Model download requested", logModel, path)
+	
+	if !s.checkQuota(w, r, 0) {
+		return
+	}
 	
 	// Create a tar/zip containing all model files
 	w.Header().Set("Content-Type", "application/octet-stream")
@@ -581,38 +1030,100 @@ func (s *ModelServer) handleModelDownload(w http.ResponseWriter, r *http.Request
 
 func (s *ModelServer) handleManifestDownload(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/manifests/")
-	parts := strings.Split(path, ":")
-	
-	if len(parts) != 2 {
+	ref, err := parseModelRef(path)
+	if err != nil || !strings.Contains(path, ":") {
 		http.Error(w, "Invalid manifest path", http.StatusBadRequest)
 		return
 	}
 	
-	name, tag := parts[0], parts[1]
-	model := fmt.Sprintf("%s:%s", name, tag)
+	s.serveManifest(w, r, ref)
+}
+
+// serveManifest sends the manifest for a published model (following aliases)
+// and starts a download session for the client. It backs both /manifests/
+// and the registry manifest route.
+func (s *ModelServer) serveManifest(w http.ResponseWriter, r *http.Request, ref ModelRef) {
+	// Aliases are served with their target's manifest; the session and the
+	// client keep using the requested name
+	model := ref.String()
+	target, alias := s.resolveRef(ref)
+	name, tag := target.Name(), target.Tag
 	clientIP := getClientIP(r)
-	manifestPath := s.getManifestPath(name, tag)
+	logger := requestLog(r).With(logModel, model)
+	manifestPath := target.manifestPath(s.modelsDir)
+	
+	ctx, span := startSpan(r.Context(), "manifest.serve",
+		attribute.String(logModel, model),
+		attribute.String(logClient, clientIP))
+	defer span.End()
 	
 	if _, err := os.Stat(manifestPath); os.IsNotExist(err) {
 		http.Error(w, "Manifest not found", http.StatusNotFound)
 		return
 	}
 	
+	// Only published models can be pulled
+	s.syncCatalog(ctx)
+	entry, ok := s.publishedEntry(target)
+	if !ok {
+		logger.Warn("Manifest requested for unpublished model")
+		http.Error(w, "Manifest not found", http.StatusNotFound)
+		return
+	}
+	
+	// Refuse to start a pull the client has no quota left for
+	if !s.checkQuota(w, r, 0) {
+		return
+	}
+	
+	if entry.Deprecated {
+		msg := deprecationNotice(entry.DeprecationMessage)
+		w.Header().Set("X-Lancache-Deprecated", msg)
+		w.Header().Set("Warning", fmt.Sprintf("299 ollama-lancache %q", msg))
+		logger.Warn("Deprecated model requested")
+	}
+	
 	// Count expected blobs for this model to track progress
 	expectedFiles := s.countModelFiles(name, tag)
 	
 	// Start tracking download session when manifest is first requested
-	s.startSession(clientIP, model, expectedFiles)
+	s.startSession(requestLog(r), clientIP, model, expectedFiles)
+	s.pulls.Record(model)
+	
+	if alias != nil {
+		w.Header().Set("X-Lancache-Alias-Of", alias.Target)
+		span.SetAttributes(attribute.String("alias_of", alias.Target))
+	}
+	digest, err := manifestDigest(manifestPath)
+	if err == nil {
+		w.Header().Set("Docker-Content-Digest", digest)
+		logger = logger.With(logDigest, digest)
+		span.SetAttributes(attribute.String(logDigest, digest))
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	http.ServeFile(w, r, manifestPath)
 	
-	log.Printf("📄 [%s] Manifest served: %s (expecting %d files)", clientIP, model, expectedFiles)
+	if alias != nil {
+		logger = logger.With("alias_of", alias.Target)
+	}
+	logger.Info("Manifest served", "files_expected", expectedFiles)
 }
 
 func (s *ModelServer) handleBlobDownload(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/blobs/")
+	s.serveBlob(w, r, strings.TrimPrefix(r.URL.Path, "/blobs/"))
+}
+
+// serveBlob sends a blob by digest and records it against the client's active
+// download session. It backs both /blobs/ and the registry blob route.
+func (s *ModelServer) serveBlob(w http.ResponseWriter, r *http.Request, path string) {
 	clientIP := getClientIP(r)
+	logger := requestLog(r).With(logDigest, path)
+	
+	_, span := startSpan(r.Context(), "blob.transfer",
+		attribute.String(logDigest, path),
+		attribute.String(logClient, clientIP))
+	defer span.End()
 	
 	// Convert colon to hyphen for file system compatibility
 	// Blobs are stored as sha256-abc123... but requested as sha256:abc123...
@@ -620,7 +1131,7 @@ func (s *ModelServer) handleBlobDownload(w http.ResponseWriter, r *http.Request)
 	blobPath := filepath.Join(s.modelsDir, "blobs", blobFileName)
 	
 	if _, err := os.Stat(blobPath); os.IsNotExist(err) {
-		log.Printf("❌ [%s] Blob not found: %s", clientIP, blobFileName[:12]+"...")
+		logger.Warn("Blob not found")
 		http.Error(w, "Blob not found", http.StatusNotFound)
 		return
 	}
@@ -628,36 +1139,40 @@ func (s *ModelServer) handleBlobDownload(w http.ResponseWriter, r *http.Request)
 	// Get file size for tracking
 	fileInfo, err := os.Stat(blobPath)
 	if err != nil {
-		log.Printf("❌ [%s] Error getting blob info: %s", clientIP, err)
+		logger.Error("Error getting blob info", "error", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 	
+	want := requestedBytes(r, fileInfo.Size())
+	if !s.checkQuota(w, r, want) {
+		return
+	}
+	
 	// Touch session activity BEFORE starting the potentially long file transfer
 	model := s.findActiveModel(clientIP)
 	if model != "" {
 		s.touchSession(clientIP, model)
+		span.SetAttributes(attribute.String(logModel, model))
 	}
 	
 	w.Header().Set("Content-Type", "application/octet-stream")
-	http.ServeFile(w, r, blobPath)
+	start := time.Now()
+	rec := &accessRecorder{ResponseWriter: w}
+	http.ServeFile(rec, r, blobPath)
+	s.chargeQuota(r, want, rec.bytes)
+	span.SetAttributes(attribute.Int64(logBytes, rec.bytes), attribute.Int64("size", fileInfo.Size()))
+	logger = logger.With(logBytes, fileInfo.Size(), logDuration, time.Since(start))
 	
 	// Update session progress - reuse model from above
 	if model != "" {
 		s.updateSession(clientIP, model, fileInfo.Size())
-		log.Printf("🗃️  [%s] Blob served: %s (%.2f MB) - %s", 
-			clientIP, 
-			path[:12]+"...", 
-			float64(fileInfo.Size())/1024/1024,
-			model)
+		logger.Info("Blob served", logModel, model)
 		
 		// Check if download session is complete
 		s.checkSessionCompletion(clientIP, model)
 	} else {
-		log.Printf("🗃️  [%s] Blob served: %s (%.2f MB) - no active session found", 
-			clientIP, 
-			path[:12]+"...", 
-			float64(fileInfo.Size())/1024/1024)
+		logger.Info("Blob served without an active session")
 	}
 }
 
@@ -667,7 +1182,51 @@ func (s *ModelServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	models, _ := s.getAvailableModels()
+	allModels, _ := s.getAvailableModels(r.Context())
+	
+	// The search form uses the same filters as /api/models, without paging
+	params := r.URL.Query()
+	searchError := ""
+	query, err := parseModelQuery(params)
+	if err != nil {
+		searchError = err.Error()
+		query, _ = parseModelQuery(url.Values{})
+	}
+	query.Limit, query.After = 0, nil
+	models, _, _ := query.Apply(allModels)
+	
+	countLabel := fmt.Sprintf("%d", len(models))
+	scriptBase := htmlEscape(s.installScripts.serverURL(r, s.trustedProxies, s.trustedHeader))
+	if len(models) != len(allModels) {
+		countLabel = fmt.Sprintf("%d of %d", len(models), len(allModels))
+	}
+	
+	sortOptions := ""
+	for _, option := range []string{"name", "size", "modified", "popularity"} {
+		selected := ""
+		if option == query.Sort {
+			selected = " selected"
+		}
+		sortOptions += fmt.Sprintf(`<option value="%s"%s>%s</option>`, option, selected, option)
+	}
+	
+	searchForm := `
+    <form class="search" method="get" action="/">
+        <input type="text" name="q" placeholder="Name or glob (llama*)" value="` + htmlEscape(params.Get("q")) + `">
+        <input type="text" name="namespace" placeholder="Namespace" size="10" value="` + htmlEscape(params.Get("namespace")) + `">
+        <input type="text" name="family" placeholder="Family" size="10" value="` + htmlEscape(params.Get("family")) + `">
+        <input type="text" name="quantization" placeholder="Quantization" size="10" value="` + htmlEscape(params.Get("quantization")) + `">
+        <input type="text" name="min_size" placeholder="Min size (1GB)" size="12" value="` + htmlEscape(params.Get("min_size")) + `">
+        <input type="text" name="max_size" placeholder="Max size (8GB)" size="12" value="` + htmlEscape(params.Get("max_size")) + `">
+        <input type="text" name="modified_since" placeholder="Modified since (7d)" size="16" value="` + htmlEscape(params.Get("modified_since")) + `">
+        <select name="sort">` + sortOptions + `</select>
+        <button type="submit">Search</button>
+        <a href="/">Clear</a>
+    </form>`
+	if searchError != "" {
+		searchForm += `
+    <p class="deprecated">⚠️ ` + htmlEscape(searchError) + `</p>`
+	}
 	
 	html := `<!DOCTYPE html>
 <html>
@@ -680,6 +1239,8 @@ func (s *ModelServer) handleRoot(w http.ResponseWriter, r *http.Request) {
         .header { color: #333; }
         .models { margin: 20px 0; }
         .model { padding: 10px; border: 1px solid #ddd; margin: 5px 0; border-radius: 5px; }
+        .deprecated { color: #b35900; }
+        .search input, .search select { padding: 4px; }
         .usage { background: #f5f5f5; padding: 15px; border-radius: 5px; margin: 20px 0; }
         code { background: #eee; padding: 8px 12px; border-radius: 3px; display: block; margin: 8px 0; font-family: 'Courier New', monospace; font-size: 13px; overflow-x: auto; }
     </style>
@@ -690,24 +1251,41 @@ func (s *ModelServer) handleRoot(w http.ResponseWriter, r *http.Request) {
     <div class="usage">
         <h3>📝 Client Usage:</h3>
         <p><strong>Windows PowerShell:</strong></p>
-        <code>$env:OLLAMA_MODEL='granite3.3:8b'; powershell -c "irm http://` + r.Host + `/install.ps1 | iex"</code>
+        <code>$env:OLLAMA_MODEL='granite3.3:8b'; powershell -c "irm ` + scriptBase + `/install.ps1 | iex"</code>
         
         <p><strong>Linux/macOS:</strong></p>
-        <code>curl -fsSL http://` + r.Host + `/install.sh | bash -s -- --server ` + r.Host + ` --model granite3.3:8b</code>
+        <code>curl -fsSL ` + scriptBase + `/install.sh | bash -s -- --model granite3.3:8b</code>
         
         <p><strong>List available models:</strong></p>
-        <code>curl -fsSL http://` + r.Host + `/install.sh | bash -s -- --server ` + r.Host + ` --list</code>
+        <code>curl -fsSL ` + scriptBase + `/install.sh | bash -s -- --list</code>
     </div>
     
-    <h3>📦 Available Models (` + fmt.Sprintf("%d", len(models)) + `):</h3>
+    <h3>📦 Available Models (` + countLabel + `):</h3>` + searchForm + `
     <div class="models">`
 	
 	for _, model := range models {
+		details := ""
+		if summary := model.Details.Summary(); summary != "" {
+			details += `<br><small>` + htmlEscape(summary) + `</small>`
+		}
+		if len(model.LayerTypes) > 0 {
+			details += `<br><small>Layers: ` + htmlEscape(strings.Join(model.LayerTypes, ", ")) + `</small>`
+		}
+		if model.Description != "" {
+			details += `<br>` + htmlEscape(model.Description)
+		}
+		if model.RecommendedUse != "" {
+			details += `<br><small>Recommended for: ` + htmlEscape(model.RecommendedUse) + `</small>`
+		}
+		if model.Deprecated {
+			details += `<br><span class="deprecated">⚠️ Deprecated: ` + htmlEscape(deprecationNotice(model.DeprecationMessage)) + `</span>`
+		}
+		
 		html += fmt.Sprintf(`
         <div class="model">
-            <strong>%s:%s</strong> - %.2f GB
+            <strong>%s:%s</strong> - %.2f GB%s
             <br><small>Modified: %s</small>
-        </div>`, model.Name, model.Tag, float64(model.Size)/(1024*1024*1024), model.Modified.Format("2006-01-02 15:04:05"))
+        </div>`, htmlEscape(model.Name), htmlEscape(model.Tag), float64(model.Size)/(1024*1024*1024), details, model.Modified.Format("2006-01-02 15:04:05"))
 	}
 	
 	html += `
@@ -716,10 +1294,15 @@ func (s *ModelServer) handleRoot(w http.ResponseWriter, r *http.Request) {
     <h3>🔗 API Endpoints:</h3>
     <ul>
         <li><a href="/api/models">GET /api/models</a> - List available models (JSON)</li>
-        <li><a href="/api/info">GET /api/info</a> - Server information (JSON)</li>
+        <li><a href="/api/info">GET /api/info</a> - Server information and disk usage (JSON)</li>
+        <li>GET /api/models/{ref} - Model layers, template, parameters, license and GGUF header (JSON)</li>
+        <li>GET /api/models/{ref}/modelfile - Reconstructed Modelfile</li>
+        <li><a href="/api/tags">GET /api/tags</a>, POST /api/show, <a href="/v1/models">GET /v1/models</a> - Ollama and OpenAI compatible listing (JSON)</li>
+        <li>GET /api/blobs/{digest} - Blob size and which models share it (JSON)</li>
         <li><a href="/install.ps1">GET /install.ps1</a> - PowerShell client script</li>
         <li><a href="/install.sh">GET /install.sh</a> - Bash client script</li>
         <li><a href="/downloads/">GET /downloads/</a> - File downloads server</li>
+        <li><a href="/api/downloads?recursive=true">GET /api/downloads</a> - Files in the downloads area with sizes and checksums (JSON)</li>
     </ul>
 </body>
 </html>`
@@ -728,148 +1311,6 @@ func (s *ModelServer) handleRoot(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(html))
 }
 
-func (s *ModelServer) handlePowerShellScript(w http.ResponseWriter, r *http.Request) {
-	script, err := os.ReadFile("scripts/install.ps1")
-	if err != nil {
-		// Fallback to embedded script if file not found
-		http.Error(w, "PowerShell script not found", http.StatusNotFound)
-		return
-	}
-	
-	// Inject server URL into the script as a comment for auto-detection
-	serverURL := fmt.Sprintf("http://%s", r.Host)
-	injectedScript := fmt.Sprintf("# AUTO_DETECTED_SERVER=%s\n%s", serverURL, string(script))
-	
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"install.ps1\"")
-	w.Write([]byte(injectedScript))
-	
-	log.Printf("📥 [%s] PowerShell script downloaded", getClientIP(r))
-}
-
-func (s *ModelServer) handleBashScript(w http.ResponseWriter, r *http.Request) {
-	script, err := os.ReadFile("scripts/install.sh")
-	if err != nil {
-		// Fallback to embedded script if file not found
-		http.Error(w, "Bash script not found", http.StatusNotFound)
-		return
-	}
-	
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"install.sh\"")
-	w.Write(script)
-	
-	log.Printf("📥 [%s] Bash script downloaded", getClientIP(r))
-}
-
-func (s *ModelServer) handleDownloadsServer(w http.ResponseWriter, r *http.Request) {
-	// Remove the "/downloads/" prefix to get the file path
-	filePath := strings.TrimPrefix(r.URL.Path, "/downloads/")
-	
-	// Security: prevent directory traversal attacks
-	if strings.Contains(filePath, "..") {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
-		return
-	}
-	
-	// If no file specified, show directory listing
-	if filePath == "" || filePath == "/" {
-		s.handleDownloadsListing(w, r)
-		return
-	}
-	
-	// Serve the requested file
-	fullPath := filepath.Join("downloads", filePath)
-	
-	// Check if file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
-	}
-	
-	// Check if it's a directory
-	if info, err := os.Stat(fullPath); err == nil && info.IsDir() {
-		http.Error(w, "Directory access not allowed", http.StatusForbidden)
-		return
-	}
-	
-	// Serve the file
-	http.ServeFile(w, r, fullPath)
-	
-	log.Printf("📁 [%s] Downloaded file: %s", getClientIP(r), filePath)
-}
-
-func (s *ModelServer) handleDownloadsListing(w http.ResponseWriter, r *http.Request) {
-	files, err := os.ReadDir("downloads")
-	if err != nil {
-		http.Error(w, "Could not read downloads directory", http.StatusInternalServerError)
-		return
-	}
-	
-	html := `<!DOCTYPE html>
-<html>
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-    <title>Downloads - ollama-lancache</title>
-    <style>
-        body { font-family: Arial, sans-serif; margin: 40px; }
-        .header { color: #333; }
-        .file { padding: 10px; border: 1px solid #ddd; margin: 5px 0; border-radius: 5px; }
-        .file a { text-decoration: none; color: #0066cc; }
-        .file a:hover { text-decoration: underline; }
-        .size { color: #666; font-size: 0.9em; }
-        .empty { color: #999; font-style: italic; padding: 20px; text-align: center; }
-    </style>
-</head>
-<body>
-    <h1 class="header">📁 Downloads</h1>
-    <p><a href="/">← Back to ollama-lancache</a></p>
-    
-    <div class="files">`
-	
-	if len(files) == 0 {
-		html += `<div class="empty">No files available for download</div>`
-	} else {
-		for _, file := range files {
-			if !file.IsDir() {
-				info, err := file.Info()
-				if err != nil {
-					continue
-				}
-				
-				size := float64(info.Size())
-				var sizeStr string
-				if size > 1024*1024*1024 {
-					sizeStr = fmt.Sprintf("%.2f GB", size/(1024*1024*1024))
-				} else if size > 1024*1024 {
-					sizeStr = fmt.Sprintf("%.2f MB", size/(1024*1024))
-				} else if size > 1024 {
-					sizeStr = fmt.Sprintf("%.2f KB", size/1024)
-				} else {
-					sizeStr = fmt.Sprintf("%.0f bytes", size)
-				}
-				
-				html += fmt.Sprintf(`
-        <div class="file">
-            <a href="/downloads/%s">📄 %s</a>
-            <div class="size">%s - Modified: %s</div>
-        </div>`, file.Name(), file.Name(), sizeStr, info.ModTime().Format("2006-01-02 15:04:05"))
-			}
-		}
-	}
-	
-	html += `
-    </div>
-</body>
-</html>`
-	
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(html))
-	
-	log.Printf("📂 [%s] Downloads directory listing requested", getClientIP(r))
-}
-
 func getServerIPs() []string {
 	var ips []string
 	
@@ -894,21 +1335,7 @@ func getServerIPs() []string {
 	return ips
 }
 
-func getClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Forwarded-For")
-	if ip == "" {
-		ip = r.Header.Get("X-Real-IP")
-	}
-	if ip == "" {
-		ip = r.RemoteAddr
-	}
-	
-	// Extract just the IP part (remove port if present)
-	if strings.Contains(ip, ":") {
-		if host, _, err := net.SplitHostPort(ip); err == nil {
-			ip = host
-		}
-	}
-	
-	return ip
+// htmlEscape escapes text for safe inclusion in the generated HTML pages.
+func htmlEscape(s string) string {
+	return html.EscapeString(s)
 }
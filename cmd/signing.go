@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// Signature contexts. The signed message is the context line followed by
+// the content, so a SHA256SUMS signature can never pass as an install
+// script signature or the other way round.
+const (
+	signContextSums   = "ollama-lancache/sha256sums/v1\n"
+	signContextScript = "ollama-lancache/install-script/v1\n"
+)
+
+// Signer makes detached ed25519 signatures of SHA256SUMS files and install
+// scripts, both generated by the server itself. The public key is published
+// at /signing-key.pem; a signature can be checked with OpenSSL 3:
+//
+//	{ printf 'ollama-lancache/sha256sums/v1\n'; cat SHA256SUMS; } > SHA256SUMS.signed
+//	openssl pkeyutl -verify -pubin -inkey signing-key.pem -rawin -in SHA256SUMS.signed -sigfile SHA256SUMS.sig
+type Signer struct {
+	key         ed25519.PrivateKey
+	publicPEM   []byte
+	fingerprint string // SHA-256 of the DER public key, lowercase hex
+}
+
+// loadSigner reads a PKCS#8 PEM ed25519 private key, as written by
+// `openssl genpkey -algorithm ed25519`. A missing file is created with a
+// new key.
+func loadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = generateSigningKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key (create one with: openssl genpkey -algorithm ed25519 -out %s)", path, path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key %s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not an ed25519 key", path)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &Signer{
+		key:         key,
+		publicPEM:   pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		fingerprint: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+func generateSigningKey(path string) ([]byte, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	slog.Warn("Generated a new signing key; publish its fingerprint so clients can pin it", "path", path)
+	return data, nil
+}
+
+// Sign returns the raw 64-byte signature of data in the given context.
+func (s *Signer) Sign(context string, data []byte) []byte {
+	msg := make([]byte, 0, len(context)+len(data))
+	msg = append(append(msg, context...), data...)
+	return ed25519.Sign(s.key, msg)
+}
+
+// writeSignature answers with the detached signature of data.
+func (s *Signer) writeSignature(w http.ResponseWriter, context string, data []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Lancache-Key-Fingerprint", "sha256:"+s.fingerprint)
+	w.Write(s.Sign(context, data))
+}
+
+var errSigningDisabled = errors.New("signing is not enabled (start the server with --signing-key)")
+
+// handleSigningKey serves the public half of the signing key.
+func (s *ModelServer) handleSigningKey(w http.ResponseWriter, r *http.Request) {
+	if s.signer == nil {
+		http.Error(w, errSigningDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("X-Lancache-Key-Fingerprint", "sha256:"+s.signer.fingerprint)
+	w.Write(s.signer.publicPEM)
+}
@@ -0,0 +1,528 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync [MODEL:TAG...]",
+	Short: "Make the local Ollama models match a list of models on the server",
+	Long: `Bring the local Ollama models directory in line with a list of models
+published by an ollama-lancache server.
+
+The list is the models given as arguments, in --file (one per line, # starts
+a comment) and in a --profile defined on the server. Without any of these,
+the profile the server assigns to this machine's network is used.
+
+Missing models are pulled, with blobs shared between models downloaded once.
+Models whose manifest changed on the server are updated, and with --prune
+every other local model is removed. --dry-run prints the plan only.
+
+Exit status is 1 on errors. With --detailed-exitcode it is 0 when nothing
+needed to change and 2 when models were changed (or, with --dry-run, would
+be), which is what configuration management tools expect.`,
+	Example: `  ollama-lancache sync --server http://192.168.1.10:8080 llama3.2:3b qwen2.5-coder:7b
+  ollama-lancache sync --server http://192.168.1.10:8080 --profile support --prune
+  ollama-lancache sync -f /etc/ollama/models.txt --dry-run --detailed-exitcode`,
+	RunE: runSync,
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().StringP("server", "s", "", "ollama-lancache server URL, e.g. http://192.168.1.10:8080 (config: client.server)")
+	syncCmd.Flags().StringSliceP("file", "f", nil, "File listing models, one per line (repeatable, - for stdin)")
+	syncCmd.Flags().String("profile", "", "Sync the models of this server profile")
+	syncCmd.Flags().StringP("models-dir", "d", "", "Ollama models directory (default: $OLLAMA_MODELS or ~/.ollama/models)")
+	syncCmd.Flags().Bool("prune", false, "Remove local models that are not on the list")
+	syncCmd.Flags().Bool("dry-run", false, "Print the plan without changing anything")
+	syncCmd.Flags().Bool("detailed-exitcode", false, "Exit 0 when already in sync and 2 when models were changed")
+	syncCmd.Flags().String("token", "", "Bearer token sent to the server, e.g. for a download quota (config: client.token)")
+
+	viper.BindPFlag("client.server", syncCmd.Flags().Lookup("server"))
+	viper.BindPFlag("client.token", syncCmd.Flags().Lookup("token"))
+}
+
+// Sync operations, in the order they are printed.
+const (
+	syncPull   = "pull"
+	syncUpdate = "update"
+	syncRepair = "repair" // manifest is current but blobs are missing
+	syncRemove = "remove"
+	syncOK     = "ok"
+)
+
+type syncAction struct {
+	Op          string
+	Ref         ModelRef
+	Digest      string // manifest digest on the server
+	LocalDigest string
+	Size        int64
+}
+
+func (a syncAction) String() string {
+	switch a.Op {
+	case syncPull:
+		return fmt.Sprintf("📥 pull    %s (%s)", a.Ref, formatBytes(a.Size))
+	case syncUpdate:
+		return fmt.Sprintf("🔄 update  %s (%s → %s)", a.Ref, shortDigest(a.LocalDigest), shortDigest(a.Digest))
+	case syncRepair:
+		return fmt.Sprintf("🩹 repair  %s (missing blobs)", a.Ref)
+	case syncRemove:
+		return fmt.Sprintf("🗑️  remove  %s", a.Ref)
+	default:
+		return fmt.Sprintf("✅ ok      %s", a.Ref)
+	}
+}
+
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return digest
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	silenceRunErrors(cmd)
+
+	server := strings.TrimRight(viper.GetString("client.server"), "/")
+	if server == "" {
+		return errors.New("no server given; use --server or set client.server in the config file")
+	}
+	if u, err := url.Parse(server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid server URL %q", server)
+	}
+	modelsDir, err := clientModelsDir(cmd)
+	if err != nil {
+		return err
+	}
+	files, _ := cmd.Flags().GetStringSlice("file")
+	profile, _ := cmd.Flags().GetString("profile")
+	prune, _ := cmd.Flags().GetBool("prune")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	detailed, _ := cmd.Flags().GetBool("detailed-exitcode")
+	out := cmd.OutOrStdout()
+
+	client := &lancacheClient{server: server, token: viper.GetString("client.token")}
+	ctx := cmd.Context()
+
+	names := append([]string{}, args...)
+	for _, file := range files {
+		listed, err := readModelList(file, cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		names = append(names, listed...)
+	}
+	if profile != "" {
+		var p Profile
+		if err := client.getJSON(ctx, "/api/profiles/"+url.PathEscape(profile), &p); err != nil {
+			return fmt.Errorf("could not fetch profile %q: %w", profile, err)
+		}
+		names = append(names, p.Models...)
+	}
+	if len(names) == 0 {
+		// Fall back to the profile the server assigns to this machine
+		var p Profile
+		if err := client.getJSON(ctx, "/api/profiles/default", &p); err != nil {
+			return errors.New("nothing to sync: give models as arguments, --file or --profile (the server has no default profile for this machine)")
+		}
+		fmt.Fprintf(out, "📋 Using default profile %q\n", p.Name)
+		names = p.Models
+	}
+
+	var desired []ModelRef
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		ref, err := parseModelRef(name)
+		if err != nil {
+			return err
+		}
+		if !wanted[ref.String()] {
+			wanted[ref.String()] = true
+			desired = append(desired, ref)
+		}
+	}
+
+	var catalog []ModelInfo
+	if err := client.getJSON(ctx, "/api/models", &catalog); err != nil {
+		return fmt.Errorf("could not fetch the server catalog: %w", err)
+	}
+	published := make(map[string]ModelInfo, len(catalog))
+	for _, m := range catalog {
+		published[m.Name+":"+m.Tag] = m
+	}
+
+	// Plan
+	var plan []syncAction
+	var failed []string
+	for _, ref := range desired {
+		info, ok := published[ref.String()]
+		if !ok {
+			fmt.Fprintf(cmd.ErrOrStderr(), "❌ %s is not published on %s\n", ref, server)
+			failed = append(failed, ref.String())
+			continue
+		}
+		action := syncAction{Ref: ref, Digest: info.Digest, Size: info.Size, Op: syncOK}
+		local, err := manifestDigest(ref.manifestPath(modelsDir))
+		switch {
+		case os.IsNotExist(err):
+			action.Op = syncPull
+		case err != nil:
+			return err
+		case local != info.Digest:
+			action.Op = syncUpdate
+			action.LocalDigest = local
+		case !localBlobsComplete(modelsDir, ref):
+			action.Op = syncRepair
+		}
+		plan = append(plan, action)
+	}
+	if prune {
+		manifests, err := scanManifests(modelsDir)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to scan models directory: %w", err)
+		}
+		for _, m := range manifests {
+			if !wanted[m.Ref.String()] {
+				plan = append(plan, syncAction{Op: syncRemove, Ref: m.Ref})
+			}
+		}
+	}
+
+	changes := 0
+	for _, action := range plan {
+		fmt.Fprintln(out, action)
+		if action.Op != syncOK {
+			changes++
+		}
+	}
+
+	if !dryRun {
+		var removed []ModelRef
+		for _, action := range plan {
+			switch action.Op {
+			case syncPull, syncUpdate, syncRepair:
+				if err := client.pullModel(ctx, action.Ref, action.Digest, modelsDir, out); err != nil {
+					fmt.Fprintf(cmd.ErrOrStderr(), "❌ %s: %v\n", action.Ref, err)
+					failed = append(failed, action.Ref.String())
+				}
+			case syncRemove:
+				removed = append(removed, action.Ref)
+			}
+		}
+		if len(removed) > 0 {
+			freed, err := removeLocalModels(modelsDir, removed)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "🧹 Removed %d models, freed %s\n", len(removed), formatBytes(freed))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not sync %s", strings.Join(failed, ", "))
+	}
+	switch {
+	case changes == 0:
+		fmt.Fprintf(out, "🎉 %d models in sync\n", len(desired))
+	case dryRun:
+		fmt.Fprintf(out, "📝 %d changes planned (dry run)\n", changes)
+	default:
+		fmt.Fprintf(out, "🎉 %d changes applied, %d models in sync\n", changes, len(desired))
+	}
+	if detailed && changes > 0 {
+		return &ExitError{Code: 2}
+	}
+	return nil
+}
+
+// clientModelsDir resolves --models-dir, then $OLLAMA_MODELS as Ollama
+// itself does, then ~/.ollama/models.
+func clientModelsDir(cmd *cobra.Command) (string, error) {
+	if dir, _ := cmd.Flags().GetString("models-dir"); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("OLLAMA_MODELS"); dir != "" {
+		return dir, nil
+	}
+	return defaultModelsDir()
+}
+
+// readModelList reads model references one per line, ignoring blank lines
+// and # comments.
+func readModelList(path string, stdin io.Reader) ([]string, error) {
+	r := stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var models []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			models = append(models, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return models, nil
+}
+
+// localBlobsComplete reports whether every blob of a local manifest exists
+// with the right size.
+func localBlobsComplete(modelsDir string, ref ModelRef) bool {
+	data, err := os.ReadFile(ref.manifestPath(modelsDir))
+	if err != nil {
+		return false
+	}
+	manifest, err := parseManifest(data)
+	if err != nil {
+		return false
+	}
+	for _, layer := range manifest.Blobs() {
+		info, err := os.Stat(blobPath(modelsDir, layer.Digest))
+		if err != nil || info.Size() != layer.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// removeLocalModels deletes manifests and then every blob that only they
+// used, like "ollama rm". It returns the bytes freed.
+func removeLocalModels(modelsDir string, refs []ModelRef) (int64, error) {
+	candidates := make(map[string]bool)
+	for _, ref := range refs {
+		path := ref.manifestPath(modelsDir)
+		if data, err := os.ReadFile(path); err == nil {
+			if manifest, err := parseManifest(data); err == nil {
+				for _, layer := range manifest.Blobs() {
+					candidates[layer.Digest] = true
+				}
+			}
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+
+	// Keep blobs that a remaining manifest still references
+	remaining, err := scanManifests(modelsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, m := range remaining {
+		data, err := os.ReadFile(m.Path)
+		if err != nil {
+			return 0, err
+		}
+		manifest, err := parseManifest(data)
+		if err != nil {
+			// Unknown blobs may be in use; keep everything
+			return 0, nil
+		}
+		for _, layer := range manifest.Blobs() {
+			delete(candidates, layer.Digest)
+		}
+	}
+
+	var freed int64
+	for digest := range candidates {
+		path := blobPath(modelsDir, digest)
+		if info, err := os.Stat(path); err == nil {
+			if err := os.Remove(path); err != nil {
+				return freed, err
+			}
+			freed += info.Size()
+		}
+	}
+	return freed, nil
+}
+
+// lancacheClient talks to an ollama-lancache server.
+type lancacheClient struct {
+	server string
+	token  string
+}
+
+func (c *lancacheClient) get(ctx context.Context, path string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.server+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("User-Agent", "ollama-lancache/"+version)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+func (c *lancacheClient) getJSON(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.get(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// responseError turns an error response into an error, using the message
+// of a {"error": ...} body when there is one.
+func responseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	msg := strings.TrimSpace(string(body))
+	if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+		msg = apiErr.Error
+	}
+	if msg == "" {
+		msg = http.StatusText(resp.StatusCode)
+	}
+	return fmt.Errorf("server responded %d: %s", resp.StatusCode, msg)
+}
+
+// pullModel downloads a manifest and the blobs that are not present yet,
+// verifying each, and writes the manifest last.
+func (c *lancacheClient) pullModel(ctx context.Context, ref ModelRef, digest, modelsDir string, out io.Writer) error {
+	resp, err := c.get(ctx, "/manifests/"+ref.String(), nil)
+	if err != nil {
+		return err
+	}
+	if msg := resp.Header.Get("X-Lancache-Deprecated"); msg != "" {
+		fmt.Fprintf(out, "⚠️  %s: %s\n", ref, msg)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	actual := "sha256:" + hex.EncodeToString(sum[:])
+	if header := resp.Header.Get("Docker-Content-Digest"); header != "" && header != actual {
+		return fmt.Errorf("manifest failed verification (got %s, server says %s)", actual, header)
+	}
+	if digest != "" && actual != digest {
+		// Changed on the server since the plan was made; what we got is
+		// verified and newer, so keep going
+		fmt.Fprintf(out, "ℹ️  %s changed on the server while syncing\n", ref)
+	}
+	manifest, err := parseManifest(data)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(modelsDir, "blobs"), 0755); err != nil {
+		return err
+	}
+	for _, layer := range manifest.Blobs() {
+		dest := blobPath(modelsDir, layer.Digest)
+		if info, err := os.Stat(dest); err == nil && info.Size() == layer.Size {
+			continue
+		}
+		fmt.Fprintf(out, "  📥 %s (%s)\n", shortDigest(layer.Digest)+"...", formatBytes(layer.Size))
+		if err := c.downloadBlob(ctx, layer, dest); err != nil {
+			return err
+		}
+	}
+
+	dest := ref.manifestPath(modelsDir)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(dest, data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "✅ %s\n", ref)
+	return nil
+}
+
+// downloadBlob fetches a blob into "<dest>-partial", resuming what an
+// interrupted run left there, and renames it once the digest matches.
+func (c *lancacheClient) downloadBlob(ctx context.Context, layer Layer, dest string) error {
+	partial := dest + "-partial"
+	h := sha256.New()
+
+	var offset int64
+	if info, err := os.Stat(partial); err == nil && info.Size() < layer.Size {
+		n, err := hashFile(h, partial)
+		if err != nil {
+			return err
+		}
+		offset = n
+	} else {
+		os.Remove(partial)
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := c.get(ctx, "/blobs/"+layer.Digest, header)
+	if err != nil {
+		return fmt.Errorf("blob %s: %w", layer.Digest, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server sent the whole blob
+		flags |= os.O_TRUNC
+		offset = 0
+		h.Reset()
+	}
+	f, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.CopyN(io.MultiWriter(f, h), resp.Body, layer.Size-offset)
+	closeErr := f.Close()
+	if copyErr != nil {
+		return fmt.Errorf("blob %s interrupted (run sync again to resume): %w", layer.Digest, copyErr)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != layer.Digest {
+		os.Remove(partial)
+		return fmt.Errorf("blob %s failed verification (got %s)", layer.Digest, actual)
+	}
+	return os.Rename(partial, dest)
+}
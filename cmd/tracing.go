@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/jjasghar/ollama-lancache"
+
+// tracer creates the server's own spans. It follows the global provider, so
+// it is a no-op until setupTracing installs an exporter.
+var tracer = otel.Tracer(tracerName)
+
+// httpClient is used for outbound requests so the current trace context is
+// propagated to the remote side.
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// tracingConfig mirrors the serve.tracing config keys.
+type tracingConfig struct {
+	Exporter    string  `mapstructure:"exporter"` // none, otlp or file
+	Endpoint    string  `mapstructure:"endpoint"`
+	File        string  `mapstructure:"file"`
+	SampleRatio float64 `mapstructure:"sample-ratio"`
+}
+
+// setupTracing installs the global tracer provider and W3C trace context
+// propagation. The returned function flushes and stops the exporter.
+func setupTracing(cfg tracingConfig) (func(context.Context) error, error) {
+	// Propagate incoming trace context even when we record nothing ourselves
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid tracing sample ratio %v (use 0 to 1)", cfg.SampleRatio)
+	}
+
+	kind := strings.ToLower(cfg.Exporter)
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch kind {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// Without an endpoint the exporter reads OTEL_EXPORTER_OTLP_* variables
+		var opts []otlptracehttp.Option
+		if strings.Contains(cfg.Endpoint, "://") {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		exp, err := otlptracehttp.New(context.Background(), opts...)
+		if err != nil {
+			return nil, fmt.Errorf("could not create OTLP exporter: %w", err)
+		}
+		exporter = exp
+	case "file":
+		var w io.Writer = os.Stdout
+		if cfg.File != "-" {
+			if err := os.MkdirAll(filepath.Dir(cfg.File), 0755); err != nil {
+				return nil, err
+			}
+			f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, fmt.Errorf("could not open trace file: %w", err)
+			}
+			w, closer = f, f
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %q (use none, otlp or file)", cfg.Exporter)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("ollama-lancache"), semconv.ServiceVersion(version)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not build trace resource: %w", err)
+	}
+
+	// Spans go to a file as soon as they end, so the file is complete even if
+	// the server is killed; a collector gets them in batches.
+	processor := sdktrace.WithBatcher(exporter)
+	if kind == "file" {
+		processor = sdktrace.WithSyncer(exporter)
+	}
+	provider := sdktrace.NewTracerProvider(
+		processor,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}
+
+// withTracing starts a server span for every request, named after the mux
+// pattern that handles it so blob and manifest paths do not each become a
+// separate operation.
+func withTracing(next http.Handler, mux *http.ServeMux) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			_, pattern := mux.Handler(r)
+			return r.Method + " " + pattern
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/health"
+		}),
+	)
+}
+
+// startSpan starts an internal span with the given attributes.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
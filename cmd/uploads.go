@@ -0,0 +1,511 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultMaxUploadSize is used when serve.downloads.max-upload-size is empty.
+const defaultMaxUploadSize = 4 << 30
+
+// uploadRecord is what is remembered about an uploaded file, in
+// uploads.json in the data directory.
+type uploadRecord struct {
+	Path       string     `json:"path"` // relative to the downloads root
+	Size       int64      `json:"size"`
+	SHA256     string     `json:"sha256"`
+	UploadedAt time.Time  `json:"uploaded_at"`
+	UploadedBy string     `json:"uploaded_by"` // client IP
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// uploadOptions are the per-file settings of an upload.
+type uploadOptions struct {
+	Expires   string // an age such as 7d, "never", or empty for the default
+	SHA256    string // expected checksum, checked when set
+	Overwrite bool
+	Client    string
+}
+
+// uploadError is an upload failure caused by the request, with the status
+// it is answered with.
+type uploadError struct {
+	Status int
+	Msg    string
+}
+
+func (e *uploadError) Error() string { return e.Msg }
+
+type uploadLimits struct {
+	maxUpload     int64
+	defaultExpiry time.Duration
+}
+
+func parseUploadLimits(cfg downloadsConfig) (uploadLimits, error) {
+	limits := uploadLimits{maxUpload: defaultMaxUploadSize}
+	if cfg.MaxUploadSize != "" {
+		n, err := parseByteSize(cfg.MaxUploadSize)
+		if err != nil || n <= 0 {
+			return limits, fmt.Errorf("invalid max upload size %q", cfg.MaxUploadSize)
+		}
+		limits.maxUpload = n
+	}
+	if cfg.DefaultExpiry != "" {
+		age, err := parseAge(cfg.DefaultExpiry)
+		if err != nil || age <= 0 {
+			return limits, fmt.Errorf("invalid upload expiry %q (use a duration such as 36h or 7d)", cfg.DefaultExpiry)
+		}
+		limits.defaultExpiry = age
+	}
+	return limits, nil
+}
+
+// uploadsEnabled reports whether any upload credential is configured.
+func (d *Downloads) uploadsEnabled() bool {
+	for _, t := range d.tokens {
+		if t != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// canUpload reports whether token is an upload token or the admin token.
+func (d *Downloads) canUpload(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, t := range d.tokens {
+		if t != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Downloads) loadUploads() error {
+	data, err := os.ReadFile(d.uploadsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload records: %w", err)
+	}
+	var records []*uploadRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to parse upload records %s: %w", d.uploadsPath, err)
+	}
+	for _, rec := range records {
+		d.uploads[rec.Path] = rec
+	}
+	return nil
+}
+
+func (d *Downloads) saveUploadsLocked() error {
+	records := make([]*uploadRecord, 0, len(d.uploads))
+	for _, rec := range d.uploads {
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Path < records[j].Path })
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(d.uploadsPath, data, 0644)
+}
+
+// expiry returns when the file at an absolute, resolved path expires, or nil.
+func (d *Downloads) expiry(real string) *time.Time {
+	rel, err := filepath.Rel(d.root, real)
+	if err != nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if rec, ok := d.uploads[filepath.ToSlash(rel)]; ok {
+		return rec.ExpiresAt
+	}
+	return nil
+}
+
+// expired reports whether the file at an absolute, resolved path is an
+// upload past its expiry. Such files are treated as gone until
+// expireUploads gets round to deleting them.
+func (d *Downloads) expired(real string) bool {
+	expiresAt := d.expiry(real)
+	return expiresAt != nil && !time.Now().Before(*expiresAt)
+}
+
+// uploadDir resolves the directory an upload to rel goes into, creating
+// missing directories. Like downloads, it refuses hidden names and
+// symlinks leading out of the root.
+func (d *Downloads) uploadDir(rel string) (dir, name string, err error) {
+	invalid := &uploadError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("invalid upload path %q", rel)}
+	var names []string
+	for _, n := range strings.Split(rel, "/") {
+		if n == "" {
+			continue
+		}
+		if hiddenName(n) || strings.ContainsRune(n, '\\') {
+			return "", "", invalid
+		}
+		if reservedName(n) {
+			return "", "", &uploadError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("%q is reserved for checksums generated by the server", n)}
+		}
+		names = append(names, n)
+	}
+	if len(names) == 0 {
+		return "", "", invalid
+	}
+
+	dir = d.root
+	for _, n := range names[:len(names)-1] {
+		next := filepath.Join(dir, n)
+		if err := os.Mkdir(next, 0755); err != nil && !os.IsExist(err) {
+			return "", "", err
+		}
+		real, err := filepath.EvalSymlinks(next)
+		if err != nil {
+			return "", "", err
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			return "", "", err
+		}
+		if !d.visible(real) {
+			return "", "", invalid
+		}
+		if !info.IsDir() {
+			return "", "", &uploadError{Status: http.StatusConflict, Msg: fmt.Sprintf("%s is not a directory", n)}
+		}
+		dir = real
+	}
+	name = names[len(names)-1]
+	if shadowsChecksum(dir, name) {
+		return "", "", &uploadError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("%q would replace the checksum generated by the server for %s", name, strings.TrimSuffix(name, ".sha256"))}
+	}
+	return dir, name, nil
+}
+
+// store streams body into the file at rel. The data goes to a hidden temp
+// file next to the target, which is renamed into place once it is complete
+// and its checksum is known, so a download never sees a partial file.
+func (d *Downloads) store(rel string, body io.Reader, opts uploadOptions) (*uploadRecord, error) {
+	var expiresAt *time.Time
+	switch opts.Expires {
+	case "":
+		if d.defaultExpiry > 0 {
+			t := time.Now().Add(d.defaultExpiry).UTC()
+			expiresAt = &t
+		}
+	case "never", "0":
+	default:
+		age, err := parseAge(opts.Expires)
+		if err != nil || age <= 0 {
+			return nil, &uploadError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("invalid expiry %q (use a duration such as 36h or 7d, or never)", opts.Expires)}
+		}
+		t := time.Now().Add(age).UTC()
+		expiresAt = &t
+	}
+	want := strings.ToLower(strings.TrimPrefix(opts.SHA256, "sha256:"))
+
+	dir, name, err := d.uploadDir(rel)
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.Join(dir, name)
+	if info, err := os.Lstat(target); err == nil {
+		if info.IsDir() {
+			return nil, &uploadError{Status: http.StatusConflict, Msg: fmt.Sprintf("%s is a directory", name)}
+		}
+		if !opts.Overwrite && !d.expired(target) {
+			return nil, &uploadError{Status: http.StatusConflict, Msg: fmt.Sprintf("%s already exists", name)}
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	tmpName := tmp.Name()
+	fail := func(err error) (*uploadRecord, error) {
+		tmp.Close()
+		os.Remove(tmpName)
+		return nil, err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(body, d.maxUpload+1))
+	if err != nil {
+		return fail(err)
+	}
+	if n > d.maxUpload {
+		return fail(&uploadError{Status: http.StatusRequestEntityTooLarge, Msg: fmt.Sprintf("file is larger than the %s upload limit", formatBytes(d.maxUpload))})
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if want != "" && want != sum {
+		return fail(&uploadError{Status: http.StatusBadRequest, Msg: fmt.Sprintf("checksum mismatch: got sha256:%s", sum)})
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return nil, err
+	}
+	if err := os.Rename(tmpName, target); err != nil {
+		os.Remove(tmpName)
+		return nil, err
+	}
+	d.rememberSum(target, sum)
+
+	relPath, _ := filepath.Rel(d.root, target)
+	rec := &uploadRecord{
+		Path:       filepath.ToSlash(relPath),
+		Size:       n,
+		SHA256:     sum,
+		UploadedAt: time.Now().UTC(),
+		UploadedBy: opts.Client,
+		ExpiresAt:  expiresAt,
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.uploads[rec.Path] = rec
+	if err := d.saveUploadsLocked(); err != nil {
+		slog.Warn("Could not save upload records", "path", d.uploadsPath, "error", err)
+	}
+	return rec, nil
+}
+
+// expireUploads deletes uploads whose expiry has passed and forgets records
+// of files that were removed by hand.
+func (d *Downloads) expireUploads() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	changed := false
+	for key, rec := range d.uploads {
+		file := filepath.Join(d.root, filepath.FromSlash(rec.Path))
+		info, err := os.Lstat(file)
+		if err != nil || info.Size() != rec.Size || !info.Mode().IsRegular() {
+			// Gone or replaced outside the server
+			delete(d.uploads, key)
+			changed = true
+			continue
+		}
+		if rec.ExpiresAt == nil || now.Before(*rec.ExpiresAt) {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			slog.Warn("Could not delete expired upload", "path", rec.Path, "error", err)
+			continue
+		}
+		slog.Info("Deleted expired upload", "path", rec.Path, "expired_at", rec.ExpiresAt)
+		delete(d.uploads, key)
+		changed = true
+	}
+	if changed {
+		if err := d.saveUploadsLocked(); err != nil {
+			slog.Warn("Could not save upload records", "path", d.uploadsPath, "error", err)
+		}
+	}
+}
+
+// uploadResponse describes a stored file.
+type uploadResponse struct {
+	Path      string     `json:"path"`
+	URL       string     `json:"url"`
+	Size      int64      `json:"size"`
+	SHA256    string     `json:"sha256"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func newUploadResponse(rec *uploadRecord) uploadResponse {
+	return uploadResponse{
+		Path:      rec.Path,
+		URL:       downloadURL(rec.Path, false),
+		Size:      rec.Size,
+		SHA256:    rec.SHA256,
+		ExpiresAt: rec.ExpiresAt,
+	}
+}
+
+// requireUploader checks token against the upload credentials, writing an
+// error response when it is not accepted.
+func (s *ModelServer) requireUploader(w http.ResponseWriter, r *http.Request, token string) bool {
+	if !s.downloads.uploadsEnabled() {
+		writeJSONError(w, http.StatusForbidden, "uploads are disabled (no upload or admin token configured)")
+		return false
+	}
+	if !s.downloads.canUpload(token) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="ollama-lancache"`)
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		requestLog(r).Warn("Rejected upload", "path", r.URL.Path)
+		return false
+	}
+	return true
+}
+
+// writeUploadError answers a failed upload.
+func writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var uerr *uploadError
+	switch {
+	case errors.As(err, &uerr):
+		writeJSONError(w, uerr.Status, uerr.Msg)
+	default:
+		requestLog(r).Error("Upload failed", "path", r.URL.Path, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "upload failed")
+	}
+}
+
+// handleDownloadPut stores the request body at /downloads/<path>:
+//
+//	curl -T setup.exe -H "Authorization: Bearer $TOKEN" http://server:8080/downloads/tools/setup.exe
+//
+// An existing file is replaced unless If-None-Match: * is sent. The
+// X-Expires-In header (or ?expires=) sets the file's expiry, and
+// X-Checksum-Sha256 (or ?sha256=) is verified before the file appears.
+func (s *ModelServer) handleDownloadPut(w http.ResponseWriter, r *http.Request, rel string) {
+	if !s.requireUploader(w, r, bearerToken(r)) {
+		return
+	}
+	if strings.HasSuffix(rel, "/") {
+		writeJSONError(w, http.StatusBadRequest, "PUT needs a file name; use POST with a form to upload into a directory")
+		return
+	}
+	if r.ContentLength > s.downloads.maxUpload {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than the %s upload limit", formatBytes(s.downloads.maxUpload)))
+		return
+	}
+
+	query := r.URL.Query()
+	opts := uploadOptions{
+		Expires:   firstNonEmpty(r.Header.Get("X-Expires-In"), query.Get("expires")),
+		SHA256:    firstNonEmpty(r.Header.Get("X-Checksum-Sha256"), query.Get("sha256")),
+		Overwrite: r.Header.Get("If-None-Match") != "*",
+		Client:    getClientIP(r),
+	}
+	_, _, statErr := s.downloads.resolve(rel)
+	rec, err := s.downloads.store(rel, r.Body, opts)
+	if err != nil {
+		var uerr *uploadError
+		if errors.As(err, &uerr) && uerr.Status == http.StatusConflict && !opts.Overwrite {
+			uerr.Status = http.StatusPreconditionFailed
+		}
+		writeUploadError(w, r, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if statErr == nil {
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", downloadURL(rec.Path, false))
+	w.Header().Set("X-Checksum-Sha256", rec.SHA256)
+	writeJSON(w, status, newUploadResponse(rec))
+	requestLog(r).Info("File uploaded", "path", rec.Path, "bytes", rec.Size, "sha256", rec.SHA256, "expires_at", rec.ExpiresAt)
+}
+
+// handleDownloadForm accepts a multipart form posted to a directory, as sent
+// by the downloads page: optional token, expires and overwrite fields
+// followed by one or more file parts. The parts are streamed, so the fields
+// must come first.
+func (s *ModelServer) handleDownloadForm(w http.ResponseWriter, r *http.Request, rel string) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "expected a multipart/form-data upload")
+		return
+	}
+
+	token := bearerToken(r)
+	opts := uploadOptions{Client: getClientIP(r)}
+	var stored []uploadResponse
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeUploadError(w, r, &uploadError{Status: http.StatusBadRequest, Msg: "malformed multipart body: " + err.Error()})
+			return
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 4096))
+			if err != nil {
+				writeUploadError(w, r, err)
+				return
+			}
+			v := strings.TrimSpace(string(value))
+			switch part.FormName() {
+			case "token":
+				if v != "" {
+					token = v
+				}
+			case "expires":
+				opts.Expires = v
+			case "overwrite":
+				opts.Overwrite = v == "true" || v == "on" || v == "1"
+			}
+			continue
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		if !s.requireUploader(w, r, token) {
+			return
+		}
+		// Browsers may send a full client-side path
+		name := part.FileName()
+		if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+			name = name[i+1:]
+		}
+		rec, err := s.downloads.store(path.Join(rel, name), part, opts)
+		if err != nil {
+			writeUploadError(w, r, err)
+			return
+		}
+		requestLog(r).Info("File uploaded", "path", rec.Path, "bytes", rec.Size, "sha256", rec.SHA256, "expires_at", rec.ExpiresAt)
+		stored = append(stored, newUploadResponse(rec))
+	}
+
+	if len(stored) == 0 {
+		if !s.requireUploader(w, r, token) {
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "no file in the form")
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, downloadURL(rel, true), http.StatusSeeOther)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"files": stored})
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,620 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Webhook event types.
+const (
+	EventSessionStarted   = "session.started"
+	EventSessionCompleted = "session.completed"
+	EventSessionTimedOut  = "session.timed_out"
+	EventModelAdded       = "model.added"
+	EventModelRemoved     = "model.removed"
+	EventIntegrityFailed  = "integrity.failed"
+)
+
+var webhookEvents = []string{
+	EventSessionStarted, EventSessionCompleted, EventSessionTimedOut,
+	EventModelAdded, EventModelRemoved, EventIntegrityFailed,
+}
+
+const (
+	webhookMaxAttempts = 8 // about 40 minutes of retries
+	webhookBaseDelay   = 10 * time.Second
+	webhookMaxDelay    = time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookLogSize     = 1000
+)
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// webhookConfig is one entry of the serve.webhooks config list.
+type webhookConfig struct {
+	Name   string   `mapstructure:"name"`
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"` // signs payloads with HMAC-SHA256 when set
+	Events []string `mapstructure:"events"` // event types, "session.*" style groups or "*" (the default)
+}
+
+type webhookTarget struct {
+	Name   string
+	URL    string
+	Events []string
+	secret string
+}
+
+func (t *webhookTarget) wants(event string) bool {
+	for _, pattern := range t.Events {
+		if pattern == "*" || pattern == event {
+			return true
+		}
+		if group, ok := strings.CutSuffix(pattern, ".*"); ok && strings.HasPrefix(event, group+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvent is the JSON body posted to a target.
+type WebhookEvent struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Text string      `json:"text"` // one-line summary, shown as-is by Slack or Mattermost incoming webhooks
+	Data interface{} `json:"data"`
+}
+
+// WebhookDelivery is one event queued for, or sent to, one target.
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	Target      string          `json:"target"`
+	Event       string          `json:"event"`
+	EventID     string          `json:"event_id"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+	LastStatus  int             `json:"last_status_code,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// webhookState is what webhooks.json holds: the delivery queue, the log
+// of finished deliveries, newest last, and the models model.added has been
+// sent for. The announced set is kept apart from catalog.json because the
+// `catalog` subcommand updates the catalog without sending events; nil
+// means no scan has run yet.
+type webhookState struct {
+	Pending   []*WebhookDelivery `json:"pending"`
+	Log       []*WebhookDelivery `json:"log"`
+	Announced map[string]bool    `json:"announced"`
+}
+
+// Webhooks posts lifecycle events to the configured targets. Deliveries are
+// queued in webhooks.json in the data directory, so they survive restarts,
+// and retried with exponential backoff.
+type Webhooks struct {
+	path    string
+	targets []*webhookTarget
+
+	mu    sync.Mutex
+	state webhookState
+	wake  chan struct{}
+}
+
+func newWebhooks(dataDir string, configs []webhookConfig) (*Webhooks, error) {
+	targets, err := parseWebhookTargets(configs)
+	if err != nil {
+		return nil, err
+	}
+	wh := &Webhooks{
+		path:    filepath.Join(dataDir, "webhooks.json"),
+		targets: targets,
+		wake:    make(chan struct{}, 1),
+	}
+	names := make(map[string]bool)
+	for _, target := range targets {
+		names[target.Name] = true
+	}
+
+	data, err := os.ReadFile(wh.path)
+	if os.IsNotExist(err) {
+		return wh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook queue: %w", err)
+	}
+	if err := json.Unmarshal(data, &wh.state); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook queue %s: %w", wh.path, err)
+	}
+
+	// Deliveries for targets that were removed from the config can never
+	// be sent
+	var pending, dropped []*WebhookDelivery
+	for _, d := range wh.state.Pending {
+		if names[d.Target] {
+			pending = append(pending, d)
+		} else {
+			dropped = append(dropped, d)
+		}
+	}
+	wh.state.Pending = pending
+	for _, d := range dropped {
+		wh.finishLocked(d, DeliveryFailed, 0, "target no longer configured")
+	}
+	return wh, nil
+}
+
+// parseWebhookTargets checks the serve.webhooks config list.
+func parseWebhookTargets(configs []webhookConfig) ([]*webhookTarget, error) {
+	var targets []*webhookTarget
+	names := make(map[string]bool)
+	for _, cfg := range configs {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %q: invalid url", cfg.Name)
+		}
+		target := &webhookTarget{Name: cfg.Name, URL: cfg.URL, Events: cfg.Events, secret: cfg.Secret}
+		if target.Name == "" {
+			target.Name = u.Host
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("duplicate webhook name %q", target.Name)
+		}
+		names[target.Name] = true
+		if len(target.Events) == 0 {
+			target.Events = []string{"*"}
+		}
+		for _, pattern := range target.Events {
+			if !validEventPattern(pattern) {
+				return nil, fmt.Errorf("webhook %q: unknown event %q (use %s, a group such as session.* or *)",
+					target.Name, pattern, strings.Join(webhookEvents, ", "))
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// validEventPattern reports whether a subscription matches any event type.
+func validEventPattern(pattern string) bool {
+	t := webhookTarget{Events: []string{pattern}}
+	for _, event := range webhookEvents {
+		if t.wants(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start begins delivering queued and future events.
+func (wh *Webhooks) Start() {
+	if len(wh.targets) == 0 {
+		return
+	}
+	go wh.run()
+}
+
+// Emit queues an event for every target subscribed to it.
+func (wh *Webhooks) Emit(event, text string, data interface{}) {
+	var targets []*webhookTarget
+	for _, target := range wh.targets {
+		if target.wants(event) {
+			targets = append(targets, target)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	eventID := newRequestID()
+	payload, err := json.Marshal(WebhookEvent{ID: eventID, Type: event, Time: now, Text: text, Data: data})
+	if err != nil {
+		slog.Error("Could not encode webhook event", "event", event, "error", err)
+		return
+	}
+
+	wh.mu.Lock()
+	for _, target := range targets {
+		wh.state.Pending = append(wh.state.Pending, &WebhookDelivery{
+			ID:          newRequestID(),
+			Target:      target.Name,
+			Event:       event,
+			EventID:     eventID,
+			Payload:     payload,
+			Status:      DeliveryPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+	}
+	wh.saveLocked()
+	wh.mu.Unlock()
+
+	select {
+	case wh.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (wh *Webhooks) run() {
+	for {
+		d, wait := wh.next()
+		if d == nil {
+			<-wh.wake
+			continue
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-wh.wake:
+				timer.Stop()
+			}
+			continue
+		}
+
+		status, err := wh.deliver(d)
+		wh.mu.Lock()
+		d.Attempts++
+		d.LastStatus = status
+		switch {
+		case err == nil:
+			wh.finishLocked(d, DeliveryDelivered, status, "")
+			slog.Debug("Webhook delivered", "target", d.Target, "event", d.Event, "attempts", d.Attempts)
+		case d.Attempts >= webhookMaxAttempts:
+			wh.finishLocked(d, DeliveryFailed, status, err.Error())
+			slog.Warn("Webhook delivery failed", "target", d.Target, "event", d.Event, "attempts", d.Attempts, "error", err)
+		default:
+			d.LastError = err.Error()
+			d.NextAttempt = time.Now().UTC().Add(webhookBackoff(d.Attempts))
+			slog.Debug("Webhook delivery will be retried", "target", d.Target, "event", d.Event,
+				"attempts", d.Attempts, "next_attempt", d.NextAttempt, "error", err)
+		}
+		wh.saveLocked()
+		wh.mu.Unlock()
+	}
+}
+
+// next returns the pending delivery that is due first and how long until it
+// is due.
+func (wh *Webhooks) next() (*WebhookDelivery, time.Duration) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	var next *WebhookDelivery
+	for _, d := range wh.state.Pending {
+		if next == nil || d.NextAttempt.Before(next.NextAttempt) {
+			next = d
+		}
+	}
+	if next == nil {
+		return nil, 0
+	}
+	return next, time.Until(next.NextAttempt)
+}
+
+// webhookBackoff doubles the delay after every failed attempt, with jitter
+// so targets that come back are not hit by every queued event at once.
+func webhookBackoff(attempts int) time.Duration {
+	delay := webhookBaseDelay << (attempts - 1)
+	if delay <= 0 || delay > webhookMaxDelay {
+		delay = webhookMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// deliver posts one delivery. Any 2xx response counts as delivered.
+func (wh *Webhooks) deliver(d *WebhookDelivery) (int, error) {
+	var target *webhookTarget
+	for _, t := range wh.targets {
+		if t.Name == d.Target {
+			target = t
+			break
+		}
+	}
+	if target == nil {
+		return 0, fmt.Errorf("target no longer configured")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	ctx, span := startSpan(ctx, "webhook.deliver",
+		attribute.String("target", d.Target),
+		attribute.String("event", d.Event),
+		attribute.Int("attempt", d.Attempts+1))
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ollama-lancache/"+version)
+	req.Header.Set("X-Lancache-Event", d.Event)
+	req.Header.Set("X-Lancache-Delivery", d.ID)
+	req.Header.Set("X-Lancache-Timestamp", timestamp)
+	if target.secret != "" {
+		req.Header.Set("X-Lancache-Signature", "sha256="+webhookSignature(target.secret, timestamp, d.Payload))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, redactURLError(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("target responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// redactURLError drops the target URL from request errors. Webhook URLs
+// often carry credentials, and delivery errors end up in the log and in
+// /api/admin/webhooks/deliveries.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// webhookSignature is the hex HMAC-SHA256 of "<timestamp>.<body>". Covering
+// the timestamp lets receivers reject replayed deliveries.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// finishLocked moves a delivery from the queue to the log.
+func (wh *Webhooks) finishLocked(d *WebhookDelivery, status string, code int, errMsg string) {
+	now := time.Now().UTC()
+	d.Status, d.LastStatus, d.LastError, d.FinishedAt = status, code, errMsg, &now
+	d.NextAttempt = time.Time{}
+
+	for i, p := range wh.state.Pending {
+		if p == d {
+			wh.state.Pending = append(wh.state.Pending[:i], wh.state.Pending[i+1:]...)
+			break
+		}
+	}
+	wh.state.Log = append(wh.state.Log, d)
+	if len(wh.state.Log) > webhookLogSize {
+		wh.state.Log = wh.state.Log[len(wh.state.Log)-webhookLogSize:]
+	}
+}
+
+func (wh *Webhooks) saveLocked() {
+	data, err := json.MarshalIndent(wh.state, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(wh.path, data, 0644)
+	}
+	if err != nil {
+		slog.Error("Could not save webhook queue", "path", wh.path, "error", err)
+	}
+}
+
+// Announce compares the models on disk with the ones already announced and
+// returns those that appeared and disappeared since. The first scan only
+// records what is there, so existing models are not announced on upgrade.
+func (wh *Webhooks) Announce(refs []string) (added, removed []string) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	present := make(map[string]bool, len(refs))
+	for _, ref := range refs {
+		present[ref] = true
+	}
+	if wh.state.Announced == nil {
+		wh.state.Announced = present
+		wh.saveLocked()
+		return nil, nil
+	}
+
+	for _, ref := range refs {
+		if !wh.state.Announced[ref] {
+			wh.state.Announced[ref] = true
+			added = append(added, ref)
+		}
+	}
+	for ref := range wh.state.Announced {
+		if !present[ref] {
+			delete(wh.state.Announced, ref)
+			removed = append(removed, ref)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	wh.saveLocked()
+	return added, removed
+}
+
+// announceOne records a single model as announced, reporting whether it
+// was not already.
+func (wh *Webhooks) announceOne(ref string) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.state.Announced == nil {
+		wh.state.Announced = make(map[string]bool)
+	}
+	if wh.state.Announced[ref] {
+		return false
+	}
+	wh.state.Announced[ref] = true
+	wh.saveLocked()
+	return true
+}
+
+// Deliveries returns queued and finished deliveries, newest first, optionally
+// filtered by target and status.
+func (wh *Webhooks) Deliveries(target, status string, limit int) []WebhookDelivery {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, list := range [][]*WebhookDelivery{wh.state.Pending, wh.state.Log} {
+		for _, d := range list {
+			if (target == "" || d.Target == target) && (status == "" || d.Status == status) {
+				deliveries = append(deliveries, *d)
+			}
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries
+}
+
+// webhookTargetInfo describes a target in the admin API. Only the URL's
+// scheme and host are shown, as chat webhook URLs embed their credentials.
+type webhookTargetInfo struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Signed  bool     `json:"signed"`
+	Pending int      `json:"pending"`
+}
+
+// handleAdminWebhooks serves the delivery log API:
+//
+//	GET /api/admin/webhooks             - configured targets and queue sizes
+//	GET /api/admin/webhooks/deliveries  - deliveries, filtered by ?target=, ?status= and ?limit=
+func (s *ModelServer) handleAdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !s.requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/webhooks"), "/") {
+	case "":
+		targets := []webhookTargetInfo{}
+		for _, t := range s.webhooks.targets {
+			info := webhookTargetInfo{Name: t.Name, URL: t.URL, Events: t.Events, Signed: t.secret != ""}
+			if u, err := url.Parse(t.URL); err == nil {
+				info.URL = u.Scheme + "://" + u.Host
+			}
+			info.Pending = len(s.webhooks.Deliveries(t.Name, DeliveryPending, 0))
+			targets = append(targets, info)
+		}
+		writeJSON(w, http.StatusOK, targets)
+	case "deliveries":
+		query := r.URL.Query()
+		status := query.Get("status")
+		switch status {
+		case "", DeliveryPending, DeliveryDelivered, DeliveryFailed:
+		default:
+			writeJSONError(w, http.StatusBadRequest, "status must be pending, delivered or failed")
+			return
+		}
+		limit := 100
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeJSONError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = min(n, webhookLogSize)
+		}
+		writeJSON(w, http.StatusOK, s.webhooks.Deliveries(query.Get("target"), status, limit))
+	default:
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// sessionEvent is the data of session.* events.
+type sessionEvent struct {
+	Client        string    `json:"client"`
+	Model         string    `json:"model"`
+	StartedAt     time.Time `json:"started_at"`
+	Duration      float64   `json:"duration_seconds"`
+	Files         int       `json:"files"`
+	FilesExpected int       `json:"files_expected"`
+	Bytes         int64     `json:"bytes"`
+}
+
+func (session *DownloadSession) event() sessionEvent {
+	return sessionEvent{
+		Client:        session.ClientIP,
+		Model:         session.Model,
+		StartedAt:     session.StartTime.UTC(),
+		Duration:      time.Since(session.StartTime).Round(time.Second).Seconds(),
+		Files:         session.FilesServed,
+		FilesExpected: session.TotalFiles,
+		Bytes:         session.BytesServed,
+	}
+}
+
+// modelEvent is the data of model.* events.
+type modelEvent struct {
+	Model   string `json:"model"`
+	Status  string `json:"status,omitempty"`
+	Source  string `json:"source"` // scan or push
+	Size    int64  `json:"size,omitempty"`
+	Summary string `json:"summary,omitempty"` // e.g. "llama · 8B · Q4_K_M · gguf"
+}
+
+// integrityEvent is the data of integrity.failed events.
+type integrityEvent struct {
+	Client       string `json:"client"`
+	Model        string `json:"model,omitempty"`
+	Digest       string `json:"digest"`
+	ActualDigest string `json:"actual_digest,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// emitModelAdded announces a model that appeared on disk or was pushed.
+func (s *ModelServer) emitModelAdded(ctx context.Context, ref ModelRef, status CatalogStatus, source string) {
+	meta, _ := s.modelMetadata(ctx, ref)
+	text := fmt.Sprintf("New model %s (%s)", ref.String(), status)
+	if summary := meta.Details.Summary(); summary != "" {
+		text = fmt.Sprintf("New model %s: %s, %s (%s)", ref.String(), summary, formatBytes(meta.Size), status)
+	}
+	s.webhooks.Emit(EventModelAdded, text, modelEvent{
+		Model:   ref.String(),
+		Status:  string(status),
+		Source:  source,
+		Size:    meta.Size,
+		Summary: meta.Details.Summary(),
+	})
+}
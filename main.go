@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -21,7 +22,14 @@ func init() {
 
 func main() {
 	if err := cmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		code := 1
+		var exitErr *cmd.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.Code
+		}
+		if msg := err.Error(); msg != "" {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", msg)
+		}
+		os.Exit(code)
 	}
 }
@@ -0,0 +1,12 @@
+// Package scripts holds the client install scripts served at /install.sh
+// and /install.ps1. They are text/template files rendered per request by
+// the server, so they are built into the binary instead of being read from
+// the working directory.
+package scripts
+
+import "embed"
+
+// FS contains install.sh and install.ps1.
+//
+//go:embed install.sh install.ps1
+var FS embed.FS
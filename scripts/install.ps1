@@ -4,12 +4,23 @@
 param(
     [string]$Server = "",
     [string]$Model = "",
+    [Alias("Profile")]
+    [string]$ModelProfile = "",
+    [string]$Token = "",
     [switch]$List,
     [switch]$Help
 )
 
 $ErrorActionPreference = "Stop"
 
+# Settings filled in by the server this script was downloaded from
+$DefaultServer = {{ ps .ServerURL }}
+$CAFingerprint = {{ ps .CAFingerprint }}
+$DefaultToken = {{ ps .Token }}
+$DefaultModel = {{ ps .DefaultModel }}
+$DefaultProfile = {{ ps .DefaultProfile }}
+$AvailableModels = {{ psArray .Models }}
+
 function Show-Help {
     Write-Host "🚀 Ollama Model Installer for Windows" -ForegroundColor Cyan
     Write-Host ""
@@ -26,11 +37,15 @@ function Show-Help {
     Write-Host "PARAMETERS:" -ForegroundColor Yellow
     Write-Host "  -Server   ollama-lancache server address (e.g., 192.168.1.100:8080)"
     Write-Host "  -Model    Model to install (e.g., granite3.3:8b)"
-    Write-Host "  -List     List available models"
+    Write-Host "  -Profile  Install every model of a server profile (e.g., support)"
+    Write-Host "  -Token    Bearer token sent to the server, e.g. for a download quota"
+    Write-Host "  -List     List available models and profiles"
     Write-Host "  -Help     Show this help"
     Write-Host ""
     Write-Host "ENVIRONMENT VARIABLES:" -ForegroundColor Yellow
     Write-Host "  OLLAMA_MODEL   Model to install (alternative to -Model parameter)"
+    Write-Host "  OLLAMA_PROFILE Profile to install (alternative to -Profile parameter)"
+    Write-Host "  OLLAMA_LANCACHE_TOKEN  Bearer token (alternative to -Token parameter)"
     Write-Host ""
     Write-Host "EXAMPLES:" -ForegroundColor Yellow
     Write-Host "  # List available models (auto-detects server)"
@@ -41,6 +56,9 @@ function Show-Help {
     Write-Host ""
     Write-Host "  # Download script first, then run with parameters"
     Write-Host "  powershell -c `"`$s = irm http://192.168.1.100:8080/install.ps1; iex `"`$s -Model granite3.3:8b`"`""
+    Write-Host ""
+    Write-Host "  # Install a team's set of models"
+    Write-Host "  powershell -c `"`$env:OLLAMA_PROFILE='support'; irm http://192.168.1.100:8080/install.ps1 | iex`""
 }
 
 function Get-OllamaModelsDir {
@@ -81,24 +99,10 @@ function Get-ServerFromRequest {
         return $Server
     }
     
-    # Try to extract server from auto-detection comment at top of script
-    try {
-        # Get the script content to look for AUTO_DETECTED_SERVER comment
-        $scriptContent = $MyInvocation.MyCommand.ScriptContents
-        if ($scriptContent) {
-            $lines = $scriptContent.Split("`n")
-            foreach ($line in $lines) {
-                if ($line.StartsWith("# AUTO_DETECTED_SERVER=")) {
-                    $detectedServer = $line.Substring("# AUTO_DETECTED_SERVER=".Length).Trim()
-                    if ($detectedServer) {
-                        Write-Host "🔍 Auto-detected server: $detectedServer" -ForegroundColor Green
-                        return $detectedServer
-                    }
-                }
-            }
-        }
-    } catch {
-        # Ignore errors in auto-detection
+    # Use the server this script was downloaded from
+    if ($DefaultServer) {
+        Write-Host "🔍 Auto-detected server: $DefaultServer" -ForegroundColor Green
+        return $DefaultServer
     }
     
     # Try to extract server from the request context if available
@@ -128,12 +132,43 @@ function Get-ServerFromRequest {
     return $inputServer
 }
 
+# Register-ServerCA trusts the server's CA certificate for this session if its
+# SHA-256 fingerprint matches the one this script was served with, so an
+# internal CA works without installing it system-wide
+function Register-ServerCA {
+    param([string]$ServerUrl)
+    
+    if (!$CAFingerprint -or !$ServerUrl.StartsWith("https://")) {
+        return
+    }
+    if ($PSVersionTable.PSEdition -eq "Core") {
+        Write-Host "⚠️  The server uses a private CA (sha256 $CAFingerprint); it must be trusted by the system for PowerShell 7" -ForegroundColor Yellow
+        return
+    }
+    
+    $fingerprint = $CAFingerprint
+    [System.Net.ServicePointManager]::ServerCertificateValidationCallback = {
+        param($request, $certificate, $chain, $errors)
+        if ($errors -eq [System.Net.Security.SslPolicyErrors]::None) {
+            return $true
+        }
+        foreach ($element in $chain.ChainElements) {
+            $hash = [System.Security.Cryptography.SHA256]::Create().ComputeHash($element.Certificate.RawData)
+            if ((([BitConverter]::ToString($hash)) -replace "-", "") -eq $fingerprint) {
+                return $true
+            }
+        }
+        return $false
+    }.GetNewClosure()
+    Write-Host "🔒 Trusting the server's CA certificate (sha256 $CAFingerprint)" -ForegroundColor Green
+}
+
 function Get-AvailableModels {
     param([string]$ServerUrl)
     
     try {
         Write-Host "📋 Fetching available models from $ServerUrl..." -ForegroundColor Blue
-        $response = Invoke-RestMethod -Uri "$ServerUrl/api/models" -Method Get
+        $response = Invoke-RestMethod -Uri "$ServerUrl/api/models" -Method Get -Headers $Headers
         return $response
     } catch {
         Write-Error "Failed to fetch models from server: $_"
@@ -152,78 +187,159 @@ function Show-AvailableModels {
         $sizeGB = [math]::Round($model.size / 1GB, 2)
         $modifiedDate = [DateTime]::Parse($model.modified).ToString("yyyy-MM-dd HH:mm")
         
-        Write-Host "🔹 $($model.name):$($model.tag)" -ForegroundColor Green
+        if ($model.deprecated) {
+            Write-Host "🔹 $($model.name):$($model.tag) ⚠️  DEPRECATED" -ForegroundColor Yellow
+        } else {
+            Write-Host "🔹 $($model.name):$($model.tag)" -ForegroundColor Green
+        }
         Write-Host "   Size: $sizeGB GB | Modified: $modifiedDate"
+        $details = @($model.details.family, $model.details.parameter_size, $model.details.quantization_level) | Where-Object { $_ }
+        if ($details) {
+            Write-Host "   $($details -join ' · ')"
+        }
+        if ($model.description) {
+            Write-Host "   $($model.description)"
+        }
         Write-Host ""
     }
     
     Write-Host "Total Models: $($Models.Count)" -ForegroundColor Yellow
 }
 
-function Install-Model {
+function Show-AvailableProfiles {
+    param([string]$ServerUrl)
+    
+    try {
+        $profiles = @(Invoke-RestMethod -Uri "$ServerUrl/api/profiles" -Method Get -Headers $Headers)
+    } catch {
+        return
+    }
+    if ($profiles.Count -eq 0) {
+        return
+    }
+    
+    Write-Host ""
+    Write-Host "👥 Profiles:" -ForegroundColor Cyan
+    Write-Host ("=" * 60)
+    foreach ($p in $profiles) {
+        $label = "🔸 $($p.name)"
+        if ($p.default) {
+            $label += " (your default)"
+        }
+        if ($p.description) {
+            $label += " - $($p.description)"
+        }
+        Write-Host $label -ForegroundColor Green
+        Write-Host "   $($p.models -join ', ')"
+        Write-Host "   Download: $([math]::Round($p.size / 1GB, 2)) GB"
+        Write-Host ""
+    }
+    Write-Host "Use -Profile NAME to install every model of a profile" -ForegroundColor Yellow
+}
+
+function Get-ProfileModels {
     param(
         [string]$ServerUrl,
+        [string]$ProfileName
+    )
+    
+    try {
+        $p = Invoke-RestMethod -Uri "$ServerUrl/api/profiles/$ProfileName" -Method Get -Headers $Headers
+    } catch {
+        Write-Error "Profile $ProfileName not found on server. Use -List to see available profiles."
+        return @()
+    }
+    
+    $missing = @($p.missing | Where-Object { $_ })
+    foreach ($m in $missing) {
+        Write-Host "⚠️  $m is not available on the server, skipping" -ForegroundColor Yellow
+    }
+    return @($p.models | Where-Object { $missing -notcontains $_ })
+}
+
+# Ollama keeps manifests at manifests\<registry>\<namespace>\<model>\<tag>;
+# "llama3" lives in the "library" namespace
+function Get-ManifestPath {
+    param(
+        [string]$OllamaDir,
         [string]$ModelName,
         [string]$ModelTag
     )
     
+    $parts = @($ModelName -split "/")
+    switch ($parts.Count) {
+        1 { $relative = "registry.ollama.ai\library\$ModelName" }
+        2 { $relative = "registry.ollama.ai\$($parts -join '\')" }
+        default { $relative = $parts -join "\" }
+    }
+    return Join-Path (Join-Path $OllamaDir "manifests\$relative") $ModelTag
+}
+
+# Install-Models downloads every manifest first, so blobs shared between the
+# models are only downloaded once, and puts the manifests in place after all
+# blobs have arrived.
+function Install-Models {
+    param(
+        [string]$ServerUrl,
+        [string[]]$Models
+    )
+    
     $ollamaDir = Get-OllamaModelsDir
-    $manifestsDir = Join-Path $ollamaDir "manifests\registry.ollama.ai\$ModelName"
     $blobsDir = Join-Path $ollamaDir "blobs"
     
-    Write-Host "🚀 Installing model: ${ModelName}:${ModelTag}" -ForegroundColor Cyan
+    Write-Host "🚀 Installing $($Models.Count) model(s): $($Models -join ', ')" -ForegroundColor Cyan
     Write-Host "📁 Target directory: $ollamaDir" -ForegroundColor Blue
     
     try {
-        # Create directories
-        if (!(Test-Path $manifestsDir)) {
-            New-Item -ItemType Directory -Path $manifestsDir -Force | Out-Null
-        }
         if (!(Test-Path $blobsDir)) {
             New-Item -ItemType Directory -Path $blobsDir -Force | Out-Null
         }
         
-        # Download manifest
-        Write-Host "📄 Downloading manifest..." -ForegroundColor Blue
-        $manifestUrl = "$ServerUrl/manifests/${ModelName}:${ModelTag}"
-        $manifestPath = Join-Path $manifestsDir "$ModelTag.json"
-        
-        Invoke-WebRequest -Uri $manifestUrl -OutFile $manifestPath -UseBasicParsing
-        
-        # Parse manifest to get blobs
-        $manifest = Get-Content $manifestPath | ConvertFrom-Json
+        # Download manifests
+        $manifests = @{}
+        $blobs = [ordered]@{}
+        $totalBlobs = 0
+        foreach ($m in $Models) {
+            Write-Host "📄 Downloading manifest for $m..." -ForegroundColor Blue
+            $response = Invoke-WebRequest -Uri "$ServerUrl/manifests/$m" -UseBasicParsing -Headers $Headers
+            $manifests[$m] = $response.Content
+            $manifest = $response.Content | ConvertFrom-Json
+            foreach ($layer in @($manifest.config) + @($manifest.layers)) {
+                if (!$layer.digest) {
+                    continue
+                }
+                $totalBlobs++
+                $blobs[$layer.digest] = $layer.size
+            }
+        }
         
+        # Download each distinct blob once
         Write-Host "📦 Downloading model blobs..." -ForegroundColor Blue
+        if ($blobs.Count -lt $totalBlobs) {
+            Write-Host "  $($totalBlobs - $blobs.Count) blob(s) are shared between models and downloaded once" -ForegroundColor Gray
+        }
         
-        $totalBlobs = $manifest.layers.Count
         $currentBlob = 0
-        
-        foreach ($layer in $manifest.layers) {
+        foreach ($digest in $blobs.Keys) {
             $currentBlob++
-            $digest = $layer.digest
-            $size = $layer.size
-            $sizeGB = [math]::Round($size / 1GB, 2)
+            $sizeGB = [math]::Round($blobs[$digest] / 1GB, 2)
             
             # Convert colon to hyphen for Windows file system compatibility
             # Ollama stores blobs as sha256-abc123... but manifests reference them as sha256:abc123...
             $blobFileName = $digest -replace ":", "-"
             $blobPath = Join-Path $blobsDir $blobFileName
             
-            Write-Host "  [$currentBlob/$totalBlobs] Downloading blob: $($digest.Substring(7, 12))... ($sizeGB GB)" -ForegroundColor Gray
+            Write-Host "  [$currentBlob/$($blobs.Count)] Downloading blob: $($digest.Substring(7, 12))... ($sizeGB GB)" -ForegroundColor Gray
             
             if (Test-Path $blobPath) {
                 Write-Host "    ✅ Already exists, skipping" -ForegroundColor Green
                 continue
             }
             
-            # Use the correct blob URL for our ollama-lancache server
             $blobUrl = "$ServerUrl/blobs/$digest"
-            
-            # Download with progress
             $tempPath = "$blobPath.tmp"
             try {
-                # Try to download from the blob endpoint
-                Invoke-WebRequest -Uri $blobUrl -OutFile $tempPath -UseBasicParsing
+                Invoke-WebRequest -Uri $blobUrl -OutFile $tempPath -UseBasicParsing -Headers $Headers
                 Move-Item $tempPath $blobPath
                 Write-Host "    ✅ Downloaded successfully" -ForegroundColor Green
             } catch {
@@ -234,17 +350,28 @@ function Install-Model {
             }
         }
         
+        # Install manifests
         Write-Host ""
-        Write-Host "✅ Model ${ModelName}:${ModelTag} installed successfully!" -ForegroundColor Green
+        foreach ($m in $Models) {
+            $modelName = $m.Substring(0, $m.LastIndexOf(":"))
+            $modelTag = $m.Substring($m.LastIndexOf(":") + 1)
+            $manifestPath = Get-ManifestPath -OllamaDir $ollamaDir -ModelName $modelName -ModelTag $modelTag
+            $manifestDir = Split-Path $manifestPath -Parent
+            if (!(Test-Path $manifestDir)) {
+                New-Item -ItemType Directory -Path $manifestDir -Force | Out-Null
+            }
+            [System.IO.File]::WriteAllText($manifestPath, $manifests[$m])
+            Write-Host "✅ Model $m installed successfully!" -ForegroundColor Green
+        }
         
         if (Test-OllamaInstalled) {
-            Write-Host "🎯 You can now use: ollama run ${ModelName}:${ModelTag}" -ForegroundColor Cyan
+            Write-Host "🎯 You can now use: ollama run $($Models[0])" -ForegroundColor Cyan
         } else {
             Write-Host "⚠️  Ollama not found in PATH. Please install Ollama first." -ForegroundColor Yellow
         }
         
     } catch {
-        Write-Error "Failed to install model: $_"
+        Write-Error "Failed to install models: $_"
     }
 }
 
@@ -259,6 +386,17 @@ if (!$Model -and $env:OLLAMA_MODEL) {
     $Model = $env:OLLAMA_MODEL
     Write-Host "📋 Using model from environment variable: $Model" -ForegroundColor Blue
 }
+if (!$ModelProfile -and $env:OLLAMA_PROFILE) {
+    $ModelProfile = $env:OLLAMA_PROFILE
+    Write-Host "📋 Using profile from environment variable: $ModelProfile" -ForegroundColor Blue
+}
+if (!$Token) {
+    $Token = if ($env:OLLAMA_LANCACHE_TOKEN) { $env:OLLAMA_LANCACHE_TOKEN } else { $DefaultToken }
+}
+$Headers = @{}
+if ($Token) {
+    $Headers["Authorization"] = "Bearer $Token"
+}
 
 # Get server URL
 $ServerUrl = Get-ServerFromRequest
@@ -268,10 +406,12 @@ if (!$ServerUrl) {
     return
 }
 
+Register-ServerCA -ServerUrl $ServerUrl
+
 # Test server connectivity
 try {
     Write-Host "🔍 Testing connection to $ServerUrl..." -ForegroundColor Blue
-    $null = Invoke-RestMethod -Uri "$ServerUrl/health" -Method Get -TimeoutSec 10
+    $null = Invoke-RestMethod -Uri "$ServerUrl/health" -Method Get -Headers $Headers -TimeoutSec 10
     Write-Host "✅ Server is reachable" -ForegroundColor Green
 } catch {
     Write-Error "Cannot connect to server $ServerUrl`: $_"
@@ -287,22 +427,48 @@ if (!$models) {
 
 if ($List) {
     Show-AvailableModels -Models $models
+    Show-AvailableProfiles -ServerUrl $ServerUrl
     return
 }
 
-if ($Model) {
+# Fall back to what the server picked for this machine
+if (!$Model -and !$ModelProfile) {
+    if ($DefaultProfile) {
+        $ModelProfile = $DefaultProfile
+        Write-Host "📋 Using default profile: $ModelProfile" -ForegroundColor Blue
+    } elseif ($DefaultModel) {
+        $Model = $DefaultModel
+        Write-Host "📋 Using default model: $Model" -ForegroundColor Blue
+    }
+}
+
+if ($ModelProfile) {
+    $profileModels = @(Get-ProfileModels -ServerUrl $ServerUrl -ProfileName $ModelProfile)
+    if ($profileModels.Count -eq 0) {
+        Write-Error "Profile $ModelProfile has no models available on the server"
+        return
+    }
+    Write-Host "👥 Profile $ModelProfile" -ForegroundColor Cyan
+    Install-Models -ServerUrl $ServerUrl -Models $profileModels
+} elseif ($Model) {
     if ($Model -match "^(.+):(.+)$") {
         $modelName = $matches[1]
         $modelTag = $matches[2]
         
         # Check if model exists
         $foundModel = $models | Where-Object { $_.name -eq $modelName -and $_.tag -eq $modelTag }
-        if (!$foundModel) {
+        if (!$foundModel -or $AvailableModels -notcontains "${modelName}:${modelTag}") {
             Write-Error "Model $Model not found on server. Use -List to see available models."
             return
         }
         
-        Install-Model -ServerUrl $ServerUrl -ModelName $modelName -ModelTag $modelTag
+        # Warn if the model has been deprecated on the server
+        if ($foundModel.deprecated) {
+            $deprecation = if ($foundModel.deprecation_message) { $foundModel.deprecation_message } else { "This model is deprecated" }
+            Write-Host "⚠️  $Model is deprecated: $deprecation" -ForegroundColor Yellow
+        }
+        
+        Install-Models -ServerUrl $ServerUrl -Models @("${modelName}:${modelTag}")
     } else {
         Write-Error "Invalid model format. Use format: name:tag (e.g., granite3.3:8b)"
     }
@@ -310,13 +476,17 @@ if ($Model) {
     Write-Host "🚀 ollama-lancache Client" -ForegroundColor Cyan
     Write-Host ""
     Show-AvailableModels -Models $models
+    Show-AvailableProfiles -ServerUrl $ServerUrl
     Write-Host ""
-    Write-Host "To install a model, use one of these methods:" -ForegroundColor Yellow
+    Write-Host "To install a model or a profile, use one of these methods:" -ForegroundColor Yellow
     Write-Host "  # Method 1 (Environment Variable):"
     Write-Host "  powershell -c `"`$env:OLLAMA_MODEL='MODEL:TAG'; irm $ServerUrl/install.ps1 | iex`""
     Write-Host ""
     Write-Host "  # Method 2 (Download & Execute):"
     Write-Host "  powershell -c `"`$s = irm $ServerUrl/install.ps1; iex `"`$s -Model MODEL:TAG`"`""
     Write-Host ""
+    Write-Host "  # Whole profile:"
+    Write-Host "  powershell -c `"`$env:OLLAMA_PROFILE='PROFILE'; irm $ServerUrl/install.ps1 | iex`""
+    Write-Host ""
     Write-Host "Use -Help for more options." -ForegroundColor Gray
 }
@@ -13,9 +13,19 @@ CYAN='\033[0;36m'
 GRAY='\033[0;37m'
 NC='\033[0m' # No Color
 
+# Settings filled in by the server this script was downloaded from
+DEFAULT_SERVER={{ sh .ServerURL }}
+CA_FINGERPRINT={{ sh .CAFingerprint }}
+DEFAULT_TOKEN={{ sh .Token }}
+DEFAULT_MODEL={{ sh .DefaultModel }}
+DEFAULT_PROFILE={{ sh .DefaultProfile }}
+AVAILABLE_MODELS={{ shArray .Models }}
+
 # Default values
 SERVER=""
 MODEL=""
+PROFILE=""
+TOKEN="${OLLAMA_LANCACHE_TOKEN:-$DEFAULT_TOKEN}"
 LIST_MODELS=false
 SHOW_HELP=false
 
@@ -30,6 +40,14 @@ while [[ $# -gt 0 ]]; do
             MODEL="$2"
             shift 2
             ;;
+        --profile)
+            PROFILE="$2"
+            shift 2
+            ;;
+        --token)
+            TOKEN="$2"
+            shift 2
+            ;;
         --list)
             LIST_MODELS=true
             shift
@@ -55,15 +73,24 @@ show_help() {
     echo -e "${YELLOW}PARAMETERS:${NC}"
     echo "  --server   ollama-lancache server address (e.g., 192.168.1.100:8080)"
     echo "  --model    Model to install (e.g., granite3.3:8b)"
-    echo "  --list     List available models"
+    echo "  --profile  Install every model of a server profile (e.g., support)"
+    echo "  --token    Bearer token sent to the server, e.g. for a download quota"
+    echo "  --list     List available models and profiles"
     echo "  --help     Show this help"
     echo ""
+    echo -e "${YELLOW}ENVIRONMENT VARIABLES:${NC}"
+    echo "  OLLAMA_MODELS          Ollama models directory (default: ~/.ollama/models)"
+    echo "  OLLAMA_LANCACHE_TOKEN  Bearer token (alternative to --token)"
+    echo ""
     echo -e "${YELLOW}EXAMPLES:${NC}"
     echo "  # List available models"
     echo "  curl -fsSL http://192.168.1.100:8080/install.sh | bash -s -- --list"
     echo ""
     echo "  # Install specific model"
     echo "  curl -fsSL http://192.168.1.100:8080/install.sh | bash -s -- --model granite3.3:8b"
+    echo ""
+    echo "  # Install a team's set of models"
+    echo "  curl -fsSL http://192.168.1.100:8080/install.sh | bash -s -- --profile support"
 }
 
 get_ollama_models_dir() {
@@ -80,13 +107,56 @@ test_ollama_installed() {
     command -v ollama >/dev/null 2>&1
 }
 
+CURL_ARGS=(-fsSL)
+
+# lancache_curl is curl with the token and CA settings for the server
+lancache_curl() {
+    curl "${CURL_ARGS[@]}" "$@"
+}
+
+# trust_server_ca downloads the server's CA certificate and trusts it for
+# further requests if its fingerprint matches the one this script was served
+# with, so an internal CA works without installing it system-wide
+trust_server_ca() {
+    local server_url="$1"
+    
+    if [[ -z "$CA_FINGERPRINT" || "$server_url" != https://* ]]; then
+        return 0
+    fi
+    if ! command -v openssl >/dev/null 2>&1; then
+        echo -e "${RED}openssl is needed to check the server's CA certificate${NC}" >&2
+        return 1
+    fi
+    
+    CA_FILE=$(mktemp)
+    trap 'rm -f "$CA_FILE"' EXIT
+    # Not verified yet: the fingerprint check below is what makes it trusted
+    if ! curl -fsSL --insecure "$server_url/ca.pem" -o "$CA_FILE"; then
+        echo -e "${RED}Failed to download the server's CA certificate${NC}" >&2
+        return 1
+    fi
+    local fingerprint
+    fingerprint=$(openssl x509 -in "$CA_FILE" -outform DER | openssl dgst -sha256 | awk '{print $NF}')
+    if [[ "$fingerprint" != "$CA_FINGERPRINT" ]]; then
+        echo -e "${RED}The server's CA certificate does not match the expected fingerprint${NC}" >&2
+        echo -e "${RED}  expected: $CA_FINGERPRINT${NC}" >&2
+        echo -e "${RED}  got:      $fingerprint${NC}" >&2
+        return 1
+    fi
+    CURL_ARGS+=(--cacert "$CA_FILE")
+    echo -e "${GREEN}🔒 Trusting the server's CA certificate (sha256 $CA_FINGERPRINT)${NC}" >&2
+}
+
 get_server_from_request() {
     local server_result=""
     
     # Try to extract server from environment or detect from curl
     if [[ -z "$SERVER" ]]; then
         # Try to detect from the URL this script was downloaded from
-        if [[ -n "$HTTP_REFERER" ]]; then
+        if [[ -n "$DEFAULT_SERVER" ]]; then
+            server_result="$DEFAULT_SERVER"
+            echo -e "${GREEN}🔍 Auto-detected server: $server_result${NC}" >&2
+        elif [[ -n "$HTTP_REFERER" ]]; then
             server_result="$HTTP_REFERER"
         elif [[ -n "$1" ]]; then
             server_result="$1"
@@ -123,7 +193,7 @@ get_available_models() {
     echo -e "${BLUE}📋 Fetching available models from $server_url...${NC}" >&2
     
     local response
-    if ! response=$(curl -fsSL "$server_url/api/models" 2>/dev/null); then
+    if ! response=$(lancache_curl "$server_url/api/models" 2>/dev/null); then
         echo -e "${RED}Failed to fetch models from server${NC}" >&2
         return 1
     fi
@@ -155,7 +225,7 @@ show_available_models() {
     
     # Parse JSON and display models (requires jq, fallback to basic parsing)
     if command -v jq >/dev/null 2>&1; then
-        echo "$models_json" | jq -r '.[] | "🔹 \(.name):\(.tag)\n   Size: \((.size / 1024 / 1024 / 1024 * 100 | floor) / 100) GB | Modified: \(.modified[:19])\n"'
+        echo "$models_json" | jq -r '.[] | "🔹 \(.name):\(.tag)\(if .deprecated then " ⚠️  DEPRECATED" else "" end)\n   Size: \((.size / 1024 / 1024 / 1024 * 100 | floor) / 100) GB | Modified: \(.modified[:19])\([.details.family, .details.parameter_size, .details.quantization_level] | map(select(. != null and . != "")) | if length > 0 then "\n   " + join(" · ") else "" end)\(if .description then "\n   \(.description)" else "" end)\n"'
     else
         # Basic parsing without jq
         echo "$models_json" | sed 's/},{/}\n{/g' | while read -r line; do
@@ -181,100 +251,151 @@ show_available_models() {
     echo -e "${YELLOW}Use --model MODEL:TAG to install a specific model${NC}"
 }
 
-install_model() {
+show_available_profiles() {
+    local server_url="$1"
+    
+    command -v jq >/dev/null 2>&1 || return 0
+    local profiles_json
+    profiles_json=$(lancache_curl "$server_url/api/profiles" 2>/dev/null) || return 0
+    [[ "$(echo "$profiles_json" | jq 'length')" == "0" ]] && return 0
+    
+    echo ""
+    echo -e "${CYAN}👥 Profiles:${NC}"
+    echo "============================================================"
+    echo "$profiles_json" | jq -r '.[] | "🔸 \(.name)\(if .default then " (your default)" else "" end)\(if .description then " - \(.description)" else "" end)\n   \(.models | join(", "))\n   Download: \((.size / 1024 / 1024 / 1024 * 100 | floor) / 100) GB\n"'
+    echo -e "${YELLOW}Use --profile NAME to install every model of a profile${NC}"
+}
+
+get_profile_models() {
     local server_url="$1"
+    local profile="$2"
+    
+    local response
+    if ! response=$(lancache_curl "$server_url/api/profiles/$profile" 2>/dev/null); then
+        echo -e "${RED}Profile $profile not found on server. Use --list to see available profiles.${NC}" >&2
+        return 1
+    fi
+    
+    if command -v jq >/dev/null 2>&1; then
+        echo "$response" | jq -r '.missing[]? | "⚠️  \(.) is not available on the server, skipping"' >&2
+        echo "$response" | jq -r '(.missing // []) as $missing | .models[] | select(. as $m | $missing | index($m) | not)'
+    else
+        echo "$response" | grep -o '"models":\[[^]]*\]' | grep -o '"[^"]*"' | tail -n +2 | tr -d '"'
+    fi
+}
+
+# Ollama keeps manifests at manifests/<registry>/<namespace>/<model>/<tag>;
+# "llama3" lives in the "library" namespace
+get_manifest_path() {
+    local ollama_dir="$1"
     local model_name="$2"
     local model_tag="$3"
     
+    case "$model_name" in
+        */*/*) echo "$ollama_dir/manifests/$model_name/$model_tag" ;;
+        */*)   echo "$ollama_dir/manifests/registry.ollama.ai/$model_name/$model_tag" ;;
+        *)     echo "$ollama_dir/manifests/registry.ollama.ai/library/$model_name/$model_tag" ;;
+    esac
+}
+
+# Prints "digest size" for the config and every layer of a manifest
+get_manifest_blobs() {
+    local manifest_path="$1"
+    
+    if command -v jq >/dev/null 2>&1; then
+        jq -r '([.config] + .layers)[] | select(.digest != null) | "\(.digest) \(.size)"' "$manifest_path"
+    else
+        tr -d ' \n' < "$manifest_path" | grep -o '"digest":"[^"]*","size":[0-9]*' | sed 's/"digest":"\([^"]*\)","size":\([0-9]*\)/\1 \2/'
+    fi
+}
+
+# install_models SERVER_URL MODEL:TAG... downloads every manifest first, so
+# blobs shared between the models are only downloaded once, and puts the
+# manifests in place after all blobs have arrived.
+install_models() {
+    local server_url="$1"
+    shift
+    
     local ollama_dir
     ollama_dir=$(get_ollama_models_dir)
-    local manifests_dir="$ollama_dir/manifests/registry.ollama.ai/$model_name"
     local blobs_dir="$ollama_dir/blobs"
+    local work_dir
+    work_dir=$(mktemp -d)
     
-    echo -e "${CYAN}🚀 Installing model: $model_name:$model_tag${NC}"
+    echo -e "${CYAN}🚀 Installing $# model(s): $*${NC}"
     echo -e "${BLUE}📁 Target directory: $ollama_dir${NC}"
+    mkdir -p "$blobs_dir"
     
-    # Create directories
-    mkdir -p "$manifests_dir" "$blobs_dir"
-    
-    # Download manifest
-    echo -e "${BLUE}📄 Downloading manifest...${NC}"
-    local manifest_url="$server_url/manifests/$model_name:$model_tag"
-    local manifest_path="$manifests_dir/$model_tag.json"
-    
-    if ! curl -fsSL "$manifest_url" -o "$manifest_path"; then
-        echo -e "${RED}Failed to download manifest${NC}" >&2
-        return 1
-    fi
+    # Download manifests
+    local i=0
+    local model
+    for model in "$@"; do
+        i=$((i + 1))
+        echo -e "${BLUE}📄 Downloading manifest for $model...${NC}"
+        if ! lancache_curl "$server_url/manifests/$model" -o "$work_dir/$i.json"; then
+            echo -e "${RED}Failed to download manifest for $model${NC}" >&2
+            rm -rf "$work_dir"
+            return 1
+        fi
+        get_manifest_blobs "$work_dir/$i.json" >> "$work_dir/blobs.txt"
+    done
     
-    # Parse manifest to get blobs
+    # Download each distinct blob once
+    sort -u -k1,1 "$work_dir/blobs.txt" > "$work_dir/unique.txt"
+    local total_blobs unique_blobs
+    total_blobs=$(wc -l < "$work_dir/blobs.txt" | tr -d ' ')
+    unique_blobs=$(wc -l < "$work_dir/unique.txt" | tr -d ' ')
     echo -e "${BLUE}📦 Downloading model blobs...${NC}"
+    if [[ "$unique_blobs" -lt "$total_blobs" ]]; then
+        echo -e "${GRAY}  $((total_blobs - unique_blobs)) blob(s) are shared between models and downloaded once${NC}"
+    fi
     
     local blob_count=0
-    local total_blobs
-    
-    if command -v jq >/dev/null 2>&1; then
-        total_blobs=$(jq '.layers | length' "$manifest_path")
+    local digest size
+    while read -r digest size; do
+        blob_count=$((blob_count + 1))
+        local size_gb
+        size_gb=$(echo "scale=2; $size / 1073741824" | bc 2>/dev/null || echo "N/A")
+        local digest_short="${digest:7:12}"
         
-        jq -r '.layers[] | "\(.digest) \(.size)"' "$manifest_path" | while read -r digest size; do
-            blob_count=$((blob_count + 1))
-            local size_gb
-            size_gb=$(echo "scale=2; $size / 1073741824" | bc 2>/dev/null || echo "N/A")
-            local digest_short="${digest:7:12}"
-            
-            local blob_path="$blobs_dir/$digest"
-            
-            echo -e "${GRAY}  [$blob_count/$total_blobs] Downloading blob: $digest_short... ($size_gb GB)${NC}"
-            
-            if [[ -f "$blob_path" ]]; then
-                echo -e "${GREEN}    ✅ Already exists, skipping${NC}"
-                continue
-            fi
-            
-            local blob_url="$server_url/blobs/$digest"
-            local temp_path="$blob_path.tmp"
-            
-            if curl -fsSL "$blob_url" -o "$temp_path" && mv "$temp_path" "$blob_path"; then
-                echo -e "${GREEN}    ✅ Downloaded successfully${NC}"
-            else
-                echo -e "${RED}    ❌ Failed to download blob $digest${NC}" >&2
-                [[ -f "$temp_path" ]] && rm -f "$temp_path"
-                return 1
-            fi
-        done
-    else
-        echo -e "${YELLOW}Warning: jq not found, using basic parsing${NC}"
-        # Basic parsing without jq
-        grep -o '"digest":"[^"]*"' "$manifest_path" | sed 's/"digest":"//;s/"//' | while read -r digest; do
-            blob_count=$((blob_count + 1))
-            local digest_short="${digest:7:12}"
-            local blob_path="$blobs_dir/$digest"
-            
-            echo -e "${GRAY}  [$blob_count/?] Downloading blob: $digest_short...${NC}"
-            
-            if [[ -f "$blob_path" ]]; then
-                echo -e "${GREEN}    ✅ Already exists, skipping${NC}"
-                continue
-            fi
-            
-            local blob_url="$server_url/blobs/$digest"
-            local temp_path="$blob_path.tmp"
-            
-            if curl -fsSL "$blob_url" -o "$temp_path" && mv "$temp_path" "$blob_path"; then
-                echo -e "${GREEN}    ✅ Downloaded successfully${NC}"
-            else
-                echo -e "${RED}    ❌ Failed to download blob $digest${NC}" >&2
-                [[ -f "$temp_path" ]] && rm -f "$temp_path"
-                return 1
-            fi
-        done
-    fi
+        # Blobs are stored as sha256-abc123... but referenced as sha256:abc123...
+        local blob_path="$blobs_dir/${digest/:/-}"
+        
+        echo -e "${GRAY}  [$blob_count/$unique_blobs] Downloading blob: $digest_short... ($size_gb GB)${NC}"
+        
+        if [[ -f "$blob_path" ]]; then
+            echo -e "${GREEN}    ✅ Already exists, skipping${NC}"
+            continue
+        fi
+        
+        local blob_url="$server_url/blobs/$digest"
+        local temp_path="$blob_path.tmp"
+        
+        if lancache_curl "$blob_url" -o "$temp_path" && mv "$temp_path" "$blob_path"; then
+            echo -e "${GREEN}    ✅ Downloaded successfully${NC}"
+        else
+            echo -e "${RED}    ❌ Failed to download blob $digest${NC}" >&2
+            [[ -f "$temp_path" ]] && rm -f "$temp_path"
+            rm -rf "$work_dir"
+            return 1
+        fi
+    done < "$work_dir/unique.txt"
     
+    # Install manifests
     echo ""
-    echo -e "${GREEN}✅ Model $model_name:$model_tag installed successfully!${NC}"
+    i=0
+    for model in "$@"; do
+        i=$((i + 1))
+        local manifest_path
+        manifest_path=$(get_manifest_path "$ollama_dir" "${model%:*}" "${model##*:}")
+        mkdir -p "$(dirname "$manifest_path")"
+        mv "$work_dir/$i.json" "$manifest_path"
+        echo -e "${GREEN}✅ Model $model installed successfully!${NC}"
+    done
+    rm -rf "$work_dir"
     
     if test_ollama_installed; then
-        echo -e "${CYAN}🎯 You can now use: ollama run $model_name:$model_tag${NC}"
+        echo -e "${CYAN}🎯 You can now use: ollama run $1${NC}"
     else
         echo -e "${YELLOW}⚠️  Ollama not found in PATH. Please install Ollama first.${NC}"
     fi
@@ -294,9 +415,14 @@ if [[ -z "$SERVER_URL" ]]; then
     exit 1
 fi
 
+if [[ -n "$TOKEN" ]]; then
+    CURL_ARGS+=(-H "Authorization: Bearer $TOKEN")
+fi
+trust_server_ca "$SERVER_URL"
+
 # Test server connectivity
 echo -e "${BLUE}🔍 Testing connection to $SERVER_URL...${NC}"
-if curl -fsSL "$SERVER_URL/health" -o /dev/null; then
+if lancache_curl "$SERVER_URL/health" -o /dev/null; then
     echo -e "${GREEN}✅ Server is reachable${NC}"
 else
     echo -e "${RED}Cannot connect to server $SERVER_URL${NC}" >&2
@@ -312,25 +438,58 @@ fi
 
 if [[ "$LIST_MODELS" == true ]]; then
     show_available_models "$models_json"
+    show_available_profiles "$SERVER_URL"
     exit 0
 fi
 
-if [[ -n "$MODEL" ]]; then
+# Fall back to what the server picked for this machine
+if [[ -z "$MODEL" && -z "$PROFILE" ]]; then
+    if [[ -n "$DEFAULT_PROFILE" ]]; then
+        PROFILE="$DEFAULT_PROFILE"
+        echo -e "${BLUE}📋 Using default profile: $PROFILE${NC}"
+    elif [[ -n "$DEFAULT_MODEL" ]]; then
+        MODEL="$DEFAULT_MODEL"
+        echo -e "${BLUE}📋 Using default model: $MODEL${NC}"
+    fi
+fi
+
+if [[ -n "$PROFILE" ]]; then
+    profile_models=()
+    while read -r model; do
+        [[ -n "$model" ]] && profile_models+=("$model")
+    done < <(get_profile_models "$SERVER_URL" "$PROFILE")
+    if [[ ${#profile_models[@]} -eq 0 ]]; then
+        echo -e "${RED}Profile $PROFILE has no models available on the server${NC}" >&2
+        exit 1
+    fi
+    echo -e "${CYAN}👥 Profile $PROFILE${NC}"
+    install_models "$SERVER_URL" "${profile_models[@]}"
+elif [[ -n "$MODEL" ]]; then
     if [[ "$MODEL" =~ ^(.+):(.+)$ ]]; then
         model_name="${BASH_REMATCH[1]}"
         model_tag="${BASH_REMATCH[2]}"
         
         # Check if model exists
+        model_found=false
+        for available in "${AVAILABLE_MODELS[@]}"; do
+            if [[ "$available" == "$model_name:$model_tag" ]]; then
+                model_found=true
+            fi
+        done
+        if [[ "$model_found" != true ]]; then
+            echo -e "${RED}Model $MODEL not found on server. Use --list to see available models.${NC}" >&2
+            exit 1
+        fi
+        
         if command -v jq >/dev/null 2>&1; then
-            if ! echo "$models_json" | jq -e ".[] | select(.name == \"$model_name\" and .tag == \"$model_tag\")" >/dev/null; then
-                echo -e "${RED}Model $MODEL not found on server. Use --list to see available models.${NC}" >&2
-                exit 1
+            # Warn if the model has been deprecated on the server
+            deprecation=$(echo "$models_json" | jq -r ".[] | select(.name == \"$model_name\" and .tag == \"$model_tag\" and .deprecated == true) | (.deprecation_message // \"This model is deprecated\")")
+            if [[ -n "$deprecation" ]]; then
+                echo -e "${YELLOW}⚠️  $MODEL is deprecated: $deprecation${NC}"
             fi
-        else
-            echo -e "${YELLOW}Warning: jq not found, skipping model existence check${NC}"
         fi
         
-        install_model "$SERVER_URL" "$model_name" "$model_tag"
+        install_models "$SERVER_URL" "$model_name:$model_tag"
     else
         echo -e "${RED}Invalid model format. Use format: name:tag (e.g., granite3.3:8b)${NC}" >&2
         exit 1
@@ -339,9 +498,11 @@ else
     echo -e "${CYAN}🚀 ollama-lancache Client${NC}"
     echo ""
     show_available_models "$models_json"
+    show_available_profiles "$SERVER_URL"
     echo ""
-    echo -e "${YELLOW}To install a model, run:${NC}"
+    echo -e "${YELLOW}To install a model or a profile, run:${NC}"
     echo "curl -fsSL $SERVER_URL/install.sh | bash -s -- --model MODEL:TAG"
+    echo "curl -fsSL $SERVER_URL/install.sh | bash -s -- --profile PROFILE"
     echo ""
     echo -e "${GRAY}Use --help for more options.${NC}"
 fi